@@ -0,0 +1,141 @@
+// Read-only admin view of the tasks being processed
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+	"trpc.group/trpc-go/trpc-a2a-go/taskmanager"
+)
+
+// phaseReceived is the phase of a task that has not reached intent detection yet,
+// e.g. while it waits for an LLM slot.
+const phaseReceived = "received"
+
+// track returns handle with the updates of taskID recorded in the registry, so
+// GET /admin/tasks can show how far the task has got. No update is changed or delayed.
+func (r *taskRegistry) track(taskID string, handle taskmanager.TaskHandle) taskmanager.TaskHandle {
+	return &trackedHandle{TaskHandle: handle, tasks: r, taskID: taskID}
+}
+
+// trackedHandle records what passes through it in a taskRegistry.
+type trackedHandle struct {
+	taskmanager.TaskHandle
+	tasks  *taskRegistry
+	taskID string
+}
+
+// UpdateStatus implements taskmanager.TaskHandle
+func (h *trackedHandle) UpdateStatus(state protocol.TaskState, msg *protocol.Message) error {
+	h.tasks.update(h.taskID, func(task *activeTask) {
+		task.state = state
+		if msg == nil {
+			return
+		}
+		if phase, ok := msg.Metadata["phase"].(string); ok && phase != "" {
+			task.phase = phase
+		}
+	})
+	return h.TaskHandle.UpdateStatus(state, msg)
+}
+
+// AddArtifact implements taskmanager.TaskHandle
+func (h *trackedHandle) AddArtifact(artifact protocol.Artifact) error {
+	h.tasks.update(h.taskID, func(task *activeTask) {
+		task.artifacts++
+		if length, ok := artifact.Metadata["total_length"].(int); ok && length > task.outputLength {
+			task.outputLength = length
+		}
+	})
+	return h.TaskHandle.AddArtifact(artifact)
+}
+
+// update applies change to taskID while it is active
+func (r *taskRegistry) update(taskID string, change func(task *activeTask)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if task, ok := r.active[taskID]; ok {
+		change(task)
+		task.updated = time.Now()
+	}
+}
+
+// activeTaskInfo describes an active task in GET /admin/tasks responses.
+type activeTaskInfo struct {
+	TaskID    string             `json:"taskId"`
+	SessionID string             `json:"sessionId"`
+	Phase     string             `json:"phase"`
+	State     protocol.TaskState `json:"state,omitempty"`
+	Persona   string             `json:"persona,omitempty"`
+	StartedAt time.Time          `json:"startedAt"`
+	UpdatedAt time.Time          `json:"updatedAt"`
+	ElapsedMs int64              `json:"elapsedMs"`
+	// OutputLength is the reply's length in bytes so far.
+	OutputLength int `json:"outputLength"`
+	Artifacts    int `json:"artifacts"`
+}
+
+// info describes task as of now; the caller holds r.mu
+func (task *activeTask) info(taskID string, now time.Time) activeTaskInfo {
+	return activeTaskInfo{
+		TaskID:       taskID,
+		SessionID:    task.session,
+		Phase:        task.phase,
+		State:        task.state,
+		Persona:      task.persona,
+		StartedAt:    task.started,
+		UpdatedAt:    task.updated,
+		ElapsedMs:    now.Sub(task.started).Milliseconds(),
+		OutputLength: task.outputLength,
+		Artifacts:    task.artifacts,
+	}
+}
+
+// snapshot returns the active tasks, oldest first
+func (r *taskRegistry) snapshot() []activeTaskInfo {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	tasks := make([]activeTaskInfo, 0, len(r.active))
+	for taskID, task := range r.active {
+		tasks = append(tasks, task.info(taskID, now))
+	}
+	sort.Slice(tasks, func(i, j int) bool {
+		if !tasks[i].StartedAt.Equal(tasks[j].StartedAt) {
+			return tasks[i].StartedAt.Before(tasks[j].StartedAt)
+		}
+		return tasks[i].TaskID < tasks[j].TaskID
+	})
+	return tasks
+}
+
+// lookup returns taskID's description, or false if it is not active
+func (r *taskRegistry) lookup(taskID string) (activeTaskInfo, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	task, ok := r.active[taskID]
+	if !ok {
+		return activeTaskInfo{}, false
+	}
+	return task.info(taskID, time.Now()), true
+}
+
+// handleActiveTasks lists the tasks being processed
+func (r *taskRegistry) handleActiveTasks(w http.ResponseWriter, req *http.Request) {
+	tasks := r.snapshot()
+	writeJSON(w, http.StatusOK, map[string]interface{}{"count": len(tasks), "tasks": tasks})
+}
+
+// handleActiveTask describes the active task in the URL path
+func (r *taskRegistry) handleActiveTask(w http.ResponseWriter, req *http.Request) {
+	taskID := req.PathValue("id")
+	task, ok := r.lookup(taskID)
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("task %q is not being processed", taskID))
+		return
+	}
+	writeJSON(w, http.StatusOK, task)
+}
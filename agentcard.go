@@ -0,0 +1,96 @@
+// Agent card served from the current persona configuration
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+	"trpc.group/trpc-go/trpc-a2a-go/server"
+)
+
+// agentFeatures records which optional features are enabled by configuration,
+// so the agent card advertises what the server actually does.
+type agentFeatures struct {
+	// streaming is false when FORCE_NON_STREAMING turns streaming off.
+	streaming         bool
+	pushNotifications bool
+	// audioInput accepts audio file parts (speech-to-text configured).
+	audioInput bool
+	// audioOutput may attach spoken audio artifacts (speech synthesis configured).
+	audioOutput bool
+}
+
+// buildCapabilities returns the A2A capability flags for the enabled features.
+// State transition history is always kept by the task manager.
+func buildCapabilities(features agentFeatures) server.AgentCapabilities {
+	return server.AgentCapabilities{
+		Streaming:              features.streaming,
+		PushNotifications:      features.pushNotifications,
+		StateTransitionHistory: true,
+	}
+}
+
+// inputModes returns the part types the server accepts
+func (f agentFeatures) inputModes() []string {
+	modes := []string{string(protocol.PartTypeText)}
+	if f.audioInput {
+		modes = append(modes, string(protocol.PartTypeFile))
+	}
+	return modes
+}
+
+// outputModes returns the part types the server may produce
+func (f agentFeatures) outputModes() []string {
+	modes := []string{string(protocol.PartTypeText)}
+	if f.audioOutput {
+		modes = append(modes, string(protocol.PartTypeFile))
+	}
+	return modes
+}
+
+// personaSkillPrefix prefixes the agent card skill ID advertised for each persona.
+const personaSkillPrefix = "persona:"
+
+// currentAgentCard returns base with one skill per persona in the current prompts appended,
+// so the advertised skills follow prompt reloads
+func currentAgentCard(base server.AgentCard, prompts *promptSet) server.AgentCard {
+	card := base
+	card.Skills = append([]server.AgentSkill(nil), base.Skills...)
+	for _, id := range prompts.personaIDs {
+		if prompts.isGuard(id) {
+			// The guard persona only declines; it is not a skill to offer.
+			continue
+		}
+		card.Skills = append(card.Skills, server.AgentSkill{
+			ID:          personaSkillPrefix + id,
+			Name:        id,
+			Description: stringPtr(prompts.descriptions[id]),
+			Tags:        []string{"persona", "chat"},
+			InputModes:  base.DefaultInputModes,
+			OutputModes: base.DefaultOutputModes,
+		})
+	}
+	return card
+}
+
+// agentCardHandler serves the agent card, rebuilt on every request from the current
+// prompts and limited to the ENABLED_SKILLS. It replaces the A2A server's handler,
+// which only knows the card it was created with.
+func agentCardHandler(base server.AgentCard, prompts *promptStore, skills skillFilter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		// Clients must not cache a card that changes when prompts are reloaded.
+		w.Header().Set("Cache-Control", "no-cache")
+		card := currentAgentCard(base, prompts.snapshot())
+		card.Skills = skills.filter(card.Skills)
+		if err := json.NewEncoder(w).Encode(card); err != nil {
+			log.Printf("Failed to encode agent card: %v", err)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+// Speech-to-text for audio input parts
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+	"trpc.group/trpc-go/trpc-a2a-go/taskmanager"
+)
+
+// sttProviderOpenAI selects OpenAI's transcription endpoint via STT_PROVIDER.
+const sttProviderOpenAI = "openai"
+
+// maxAudioBytes is the largest audio input accepted, matching OpenAI's transcription limit.
+const maxAudioBytes = 25 << 20
+
+// errNoSTT fails tasks that send audio when no speech-to-text provider is configured.
+var errNoSTT = errors.New("audio input received but no speech-to-text provider is configured (set STT_PROVIDER)")
+
+// audioExtensions maps audio MIME types to the file extensions transcription backends use to detect the format.
+var audioExtensions = map[string]string{
+	"audio/flac":  ".flac",
+	"audio/m4a":   ".m4a",
+	"audio/mp4":   ".m4a",
+	"audio/mpeg":  ".mp3",
+	"audio/mp3":   ".mp3",
+	"audio/ogg":   ".ogg",
+	"audio/wav":   ".wav",
+	"audio/x-wav": ".wav",
+	"audio/webm":  ".webm",
+}
+
+// transcriber converts audio to text. filename carries the audio format in its extension.
+type transcriber func(ctx context.Context, audio []byte, filename string) (string, error)
+
+// newTranscriber returns the transcriber for provider, nil when provider is empty,
+// or an error for an unknown provider
+func newTranscriber(provider string, client *openai.Client, model string) (transcriber, error) {
+	switch provider {
+	case "":
+		return nil, nil
+	case sttProviderOpenAI:
+		return openAITranscriber(client, model), nil
+	default:
+		return nil, fmt.Errorf("unknown STT_PROVIDER %q, expected %q", provider, sttProviderOpenAI)
+	}
+}
+
+// openAITranscriber transcribes audio with OpenAI's Whisper transcription endpoint
+func openAITranscriber(client *openai.Client, model string) transcriber {
+	return func(ctx context.Context, audio []byte, filename string) (string, error) {
+		resp, err := client.CreateTranscription(ctx, openai.AudioRequest{
+			Model:    model,
+			FilePath: filename,
+			Reader:   bytes.NewReader(audio),
+		})
+		if err != nil {
+			return "", fmt.Errorf("transcription failed: %w", err)
+		}
+		return strings.TrimSpace(resp.Text), nil
+	}
+}
+
+// extractAudioPart returns the first audio file part of a message, or nil
+func extractAudioPart(message protocol.Message) *protocol.FilePart {
+	for _, part := range message.Parts {
+		if p, ok := part.(protocol.FilePart); ok && audioFilename(p.File) != "" {
+			return &p
+		}
+	}
+	return nil
+}
+
+// audioFilename returns a filename whose extension names the audio format, or "" if file is not audio
+func audioFilename(file protocol.FileContent) string {
+	name := "audio"
+	if file.Name != nil && *file.Name != "" {
+		name = *file.Name
+		for _, ext := range audioExtensions {
+			if strings.EqualFold(path.Ext(name), ext) {
+				return name
+			}
+		}
+	}
+	if file.MimeType != nil {
+		mimeType := strings.ToLower(strings.TrimSpace(strings.Split(*file.MimeType, ";")[0]))
+		if ext, ok := audioExtensions[mimeType]; ok {
+			return strings.TrimSuffix(name, path.Ext(name)) + ext
+		}
+	}
+	return ""
+}
+
+// readAudio returns the audio bytes of a file part, decoding inline base64 or downloading its http(s) URI
+func readAudio(ctx context.Context, file protocol.FileContent) ([]byte, error) {
+	if file.Bytes != nil && *file.Bytes != "" {
+		audio, err := base64.StdEncoding.DecodeString(*file.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid base64 audio: %w", err)
+		}
+		if len(audio) > maxAudioBytes {
+			return nil, fmt.Errorf("audio is larger than %d bytes", maxAudioBytes)
+		}
+		return audio, nil
+	}
+	if file.URI == nil || !(strings.HasPrefix(*file.URI, "http://") || strings.HasPrefix(*file.URI, "https://")) {
+		return nil, errors.New("audio part has neither bytes nor an http(s) URI")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, *file.URI, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid audio URI: %w", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download audio: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download audio: %s", resp.Status)
+	}
+	audio, err := io.ReadAll(io.LimitReader(resp.Body, maxAudioBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download audio: %w", err)
+	}
+	if len(audio) > maxAudioBytes {
+		return nil, fmt.Errorf("audio is larger than %d bytes", maxAudioBytes)
+	}
+	return audio, nil
+}
+
+// transcribeAudio turns an audio part into the task's input text and emits the
+// transcript as an early artifact so clients can show what was heard
+func (p *streamingTaskProcessor) transcribeAudio(
+	ctx context.Context,
+	taskID string,
+	part *protocol.FilePart,
+	handle taskmanager.TaskHandle,
+) (string, error) {
+	if p.transcriber == nil {
+		return "", errNoSTT
+	}
+	audio, err := readAudio(ctx, part.File)
+	if err != nil {
+		return "", err
+	}
+
+	start := time.Now()
+	transcript, err := p.transcriber(ctx, audio, audioFilename(part.File))
+	if err != nil {
+		return "", err
+	}
+	if transcript == "" {
+		return "", errors.New("transcription returned no text")
+	}
+	log.Printf("Task %s: transcribed %d bytes of audio in %v", taskID, len(audio), time.Since(start))
+
+	artifact := protocol.Artifact{
+		Name:        stringPtr("Transcript"),
+		Description: stringPtr("Transcript of the audio input"),
+		Index:       0,
+		Parts:       []protocol.Part{protocol.NewTextPart(transcript)},
+		Metadata: map[string]interface{}{
+			"timestamp":   time.Now().UnixNano(),
+			"transcript":  true,
+			"audio_bytes": len(audio),
+		},
+	}
+	if err := handle.AddArtifact(artifact); err != nil {
+		log.Printf("Error adding transcript artifact for task %s: %v", taskID, err)
+	}
+	return transcript, nil
+}
@@ -0,0 +1,88 @@
+// Batch completion of many texts in one request
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// batchRequest is the body of POST /batch.
+type batchRequest struct {
+	Texts []string `json:"texts"`
+	// Locale picks the prompt locale of every text; it is detected per text when empty.
+	Locale string `json:"locale,omitempty"`
+}
+
+// batchResult is the outcome of one text of a batch: the /complete response, or the error.
+type batchResult struct {
+	completeResponse
+	Error string `json:"error,omitempty"`
+}
+
+// batchResponse is the body returned by POST /batch.
+type batchResponse struct {
+	Results []batchResult `json:"results"`
+}
+
+// handleBatch completes every text of the request like POST /complete, at most
+// batchConcurrency at a time, and returns the results in input order. Each text is
+// admitted against the quota and the LLM limiter on its own, so a batch cannot
+// crowd out interactive traffic beyond MAX_CONCURRENT_LLM_CALLS, and a failed text
+// does not fail the batch. Like /complete it has no TRTC side effects.
+func (p *streamingTaskProcessor) handleBatch(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if len(req.Texts) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "texts is required")
+		return
+	}
+	if p.batchMaxItems > 0 && len(req.Texts) > p.batchMaxItems {
+		writeJSONError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("batch has %d texts, at most %d are allowed", len(req.Texts), p.batchMaxItems))
+		return
+	}
+	// A batch outlasts the server's write timeout; it ends with the client's request.
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Batch: failed to lift the write deadline: %v", err)
+	}
+
+	start := time.Now()
+	results := make([]batchResult, len(req.Texts))
+	slots := make(chan struct{}, max(p.batchConcurrency, 1))
+	var wg sync.WaitGroup
+	for i, text := range req.Texts {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			results[i].Error = "text is required"
+			continue
+		}
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			resp, _, err := p.complete(r.Context(), text, req.Locale)
+			if err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+			results[i].completeResponse = resp
+		}()
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	log.Printf("Batch of %d texts done in %v, %d failed", len(results), time.Since(start).Round(time.Millisecond), failed)
+	writeJSON(w, http.StatusOK, batchResponse{Results: results})
+}
@@ -0,0 +1,58 @@
+// Task status once a task has been canceled mid-processing
+package main
+
+import (
+	"context"
+	"sync"
+
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+	"trpc.group/trpc-go/trpc-a2a-go/taskmanager"
+)
+
+// cancelHandle keeps a canceled task canceled. Its context is canceled when the SSE
+// client disconnects, on tasks/cancel and by CancelSession, but not by the task
+// deadline. Once that happens, the failure paths of the aborted processing report
+// canceled instead of failed, only the first final status goes through, and
+// in-progress updates are dropped. CancelSession sets the canceled status itself,
+// so every later status is dropped. Artifacts still pass, so partial output is kept.
+type cancelHandle struct {
+	taskmanager.TaskHandle
+	ctx context.Context
+
+	mu        sync.Mutex
+	finalSent bool
+}
+
+// withCancellation wraps handle for a task that is canceled through ctx
+func withCancellation(handle taskmanager.TaskHandle, ctx context.Context) *cancelHandle {
+	return &cancelHandle{TaskHandle: handle, ctx: ctx}
+}
+
+// canceled reports whether the task was canceled, rather than having run out of time
+func (h *cancelHandle) canceled() bool {
+	return h.ctx.Err() != nil
+}
+
+// UpdateStatus implements taskmanager.TaskHandle
+func (h *cancelHandle) UpdateStatus(state protocol.TaskState, msg *protocol.Message) error {
+	if !h.canceled() {
+		return h.TaskHandle.UpdateStatus(state, msg)
+	}
+	if canceledBySession(h.ctx) {
+		return nil
+	}
+	switch state {
+	case protocol.TaskStateFailed:
+		state, msg = protocol.TaskStateCanceled, nil
+	case protocol.TaskStateCanceled, protocol.TaskStateCompleted:
+	default:
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.finalSent {
+		return nil
+	}
+	h.finalSent = true
+	return h.TaskHandle.UpdateStatus(state, msg)
+}
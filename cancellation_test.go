@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+)
+
+func TestDisconnectMidStreamClosesUpstreamAndKeepsPartialOutput(t *testing.T) {
+	upstreamClosed := make(chan struct{})
+	client := streamServer(t, func(r *http.Request, send func(openai.ChatCompletionStreamResponse)) {
+		send(textDelta("Hello, "))
+		send(textDelta("I was saying"))
+		// The stream stays open until the server closes it.
+		select {
+		case <-r.Context().Done():
+			close(upstreamClosed)
+		case <-time.After(5 * time.Second):
+		}
+	})
+	p := testProcessor(t, client)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handle := &fakeHandle{}
+	go func() {
+		// Disconnect once both chunks have reached the client.
+		for handle.artifactCount() < 2 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	err := p.processWithOpenAIStreaming(ctx, "task-1", testTurn(t, "hi"), handle)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("processWithOpenAIStreaming error = %v, want context.Canceled", err)
+	}
+
+	select {
+	case <-upstreamClosed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("upstream stream was not closed after the disconnect")
+	}
+
+	handle.mu.Lock()
+	defer handle.mu.Unlock()
+	last := handle.artifacts[len(handle.artifacts)-1]
+	if last.Name == nil || *last.Name != "Partial Response" || last.Metadata["canceled"] != true {
+		t.Fatalf("last artifact = %+v, want the canceled partial response", last)
+	}
+	if text := last.Parts[0].(protocol.TextPart).Text; text != "Hello, I was saying" {
+		t.Errorf("partial response = %q", text)
+	}
+	if state := handle.states[len(handle.states)-1]; state != protocol.TaskStateCanceled {
+		t.Errorf("final state = %s, want canceled", state)
+	}
+}
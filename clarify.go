@@ -0,0 +1,132 @@
+// Clarifying questions that pause a task until the user answers
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+	"trpc.group/trpc-go/trpc-a2a-go/taskmanager"
+)
+
+// A persona asks a clarifying question by starting its reply with clarifyMarker.
+// The marker never reaches the client.
+const clarifyMarker = "[[input-required]]"
+
+// inputRequiredMetadataKey marks the input-required status and the reply that asked.
+const inputRequiredMetadataKey = "input_required"
+
+// clarifyInstruction tells the persona how to ask for missing information.
+const clarifyInstruction = "If you cannot help without more information from the user, start your reply with " +
+	clarifyMarker + " followed by one short clarifying question, and stop writing. " +
+	"Only do this when the request is genuinely ambiguous."
+
+// clarifyContinuation tells the persona that the user's message answers its
+// question; %q is the question.
+const clarifyContinuation = "You asked the user: %q\nTheir message answers that question; continue helping them with it."
+
+// clarifyScanner detects clarifyMarker at the start of a streamed reply. Text is
+// held back until it either begins with the marker, which is dropped, or cannot.
+// A nil *clarifyScanner passes text through.
+type clarifyScanner struct {
+	held    string
+	decided bool
+	found   bool
+}
+
+// write returns the text of delta that can be released
+func (s *clarifyScanner) write(delta string) string {
+	if s == nil || s.decided {
+		return delta
+	}
+	s.held += delta
+	text, found, decided := cutClarifyMarker(s.held)
+	if !decided {
+		return ""
+	}
+	s.held = ""
+	s.decided = true
+	s.found = found
+	return text
+}
+
+// flush returns the text still held back, once the reply has ended
+func (s *clarifyScanner) flush() string {
+	if s == nil {
+		return ""
+	}
+	held := s.held
+	s.held = ""
+	s.decided = true
+	return held
+}
+
+// asked reports whether the reply started with the marker
+func (s *clarifyScanner) asked() bool {
+	return s != nil && s.found
+}
+
+// cutClarifyMarker returns text without a leading clarifyMarker and whether it had
+// one. decided is false while text, ignoring leading whitespace, could still be the
+// start of the marker.
+func cutClarifyMarker(text string) (rest string, found, decided bool) {
+	trimmed := strings.TrimLeft(text, " \t\r\n")
+	if rest, ok := strings.CutPrefix(trimmed, clarifyMarker); ok {
+		return strings.TrimLeft(rest, " \t\r\n"), true, true
+	}
+	if strings.HasPrefix(clarifyMarker, trimmed) {
+		return text, false, false
+	}
+	return text, false, true
+}
+
+// clarifyPrompt returns the system prompt addition for the turn: the question the
+// user is answering, how to ask one while the turn may, and "" otherwise
+func clarifyPrompt(turn *completionTurn) string {
+	if turn.answering != "" {
+		return fmt.Sprintf(clarifyContinuation, turn.answering)
+	}
+	if turn.clarify {
+		return clarifyInstruction
+	}
+	return ""
+}
+
+// clarifyScanner returns the scanner for the turn's reply, or nil if it may not ask
+func (p *streamingTaskProcessor) clarifyScanner(turn *completionTurn) *clarifyScanner {
+	if !turn.clarify {
+		return nil
+	}
+	return &clarifyScanner{}
+}
+
+// clarifyReply strips the marker from a complete reply, recording on the turn whether it asked
+func clarifyReply(turn *completionTurn, text string) string {
+	turn.inputRequired = false
+	if !turn.clarify {
+		return text
+	}
+	rest, found, _ := cutClarifyMarker(text)
+	turn.inputRequired = found
+	return rest
+}
+
+// requestInput ends the turn by asking the user its question: the task moves to
+// input-required with the question as its message, and the session remembers it so
+// the next message goes to the same persona as the answer.
+func (p *streamingTaskProcessor) requestInput(taskID string, turn *completionTurn, handle taskmanager.TaskHandle) error {
+	question := strings.TrimSpace(turn.reply)
+	p.sessions.awaitAnswer(turn.session, turn.intent, question)
+	log.Printf("Task %s: %s asked a clarifying question, waiting for input", taskID, turn.intent)
+	message := protocol.NewMessage(
+		protocol.MessageRoleAgent,
+		[]protocol.Part{protocol.NewTextPart(question)},
+	)
+	message.Metadata = map[string]interface{}{inputRequiredMetadataKey: true}
+	if err := handle.UpdateStatus(protocol.TaskStateInputRequired, &message); err != nil {
+		log.Printf("Error updating input-required status for task %s: %v", taskID, err)
+		return fmt.Errorf("failed to update final task status: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+// HTTP response compression negotiated through Accept-Encoding
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Content codings the server can apply, in order of preference
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+// acceptedEncoding picks gzip or deflate from an Accept-Encoding header, or ""
+// when the client accepts neither. A q=0 weight excludes a coding, and * stands
+// for the codings the header does not name.
+func acceptedEncoding(header string) string {
+	accepted := make(map[string]bool)
+	wildcard := false
+	for _, entry := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(entry), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		weight := 1.0
+		if name, value, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(name) == "q" {
+			if q, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				weight = q
+			}
+		}
+		if coding == "*" {
+			wildcard = weight > 0
+			continue
+		}
+		accepted[coding] = weight > 0
+	}
+	for _, encoding := range []string{encodingGzip, encodingDeflate} {
+		if ok, named := accepted[encoding]; ok || (!named && wildcard) {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// compressWriter compresses a response once its headers show it is compressible.
+// SSE streams, bodiless statuses and responses that already carry a
+// Content-Encoding are passed through untouched, so events are never held back
+// in a compression buffer. A compressed response drops its Content-Length, which
+// no longer matches the body, and is sent chunked.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	decided    bool
+	compressor io.WriteCloser
+}
+
+// start decides whether to compress, given the status about to be written
+func (w *compressWriter) start(status int) {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	header := w.Header()
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified ||
+		header.Get("Content-Encoding") != "" ||
+		strings.HasPrefix(header.Get("Content-Type"), "text/event-stream") {
+		return
+	}
+	header.Del("Content-Length")
+	header.Set("Content-Encoding", w.encoding)
+	header.Add("Vary", "Accept-Encoding")
+	if w.encoding == encodingGzip {
+		w.compressor = gzip.NewWriter(w.ResponseWriter)
+		return
+	}
+	// HTTP's deflate coding is the zlib format, not raw deflate.
+	w.compressor = zlib.NewWriter(w.ResponseWriter)
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *compressWriter) WriteHeader(status int) {
+	w.start(status)
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		// net/http would sniff the compressed bytes, so sniff the plain ones here.
+		if w.Header().Get("Content-Type") == "" {
+			w.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		w.start(http.StatusOK)
+	}
+	if w.compressor != nil {
+		return w.compressor.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// Flush implements http.Flusher, flushing any compressed data first
+func (w *compressWriter) Flush() {
+	w.start(http.StatusOK)
+	if flusher, ok := w.compressor.(interface{ Flush() error }); ok {
+		_ = flusher.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close finishes the compressed stream
+func (w *compressWriter) close() error {
+	if w.compressor == nil {
+		return nil
+	}
+	return w.compressor.Close()
+}
+
+// withCompression compresses responses with gzip or deflate when the client's
+// Accept-Encoding allows. HEAD requests and WebSocket upgrades are passed through.
+func withCompression(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer func() {
+			if err := cw.close(); err != nil {
+				log.Printf("Failed to finish compressed response for %s: %v", r.URL.Path, err)
+			}
+		}()
+		next.ServeHTTP(cw, r)
+	})
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestAcceptedEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", encodingGzip},
+		{"deflate", encodingDeflate},
+		// gzip is preferred whatever the order or weights.
+		{"deflate, gzip", encodingGzip},
+		{"deflate;q=1.0, gzip;q=0.5", encodingGzip},
+		{"GZIP", encodingGzip},
+		{"gzip;q=0, deflate", encodingDeflate},
+		{"gzip; q=0", ""},
+		{"*", encodingGzip},
+		{"*;q=0", ""},
+		// A named coding's weight wins over the wildcard's.
+		{"gzip;q=0, *", encodingDeflate},
+		{"*, gzip;q=0", encodingDeflate},
+		{"br, *;q=0", ""},
+	}
+	for _, test := range tests {
+		if got := acceptedEncoding(test.header); got != test.want {
+			t.Errorf("acceptedEncoding(%q) = %q, want %q", test.header, got, test.want)
+		}
+	}
+}
+
+// serveCompressed runs handler behind withCompression for a request accepting acceptEncoding.
+func serveCompressed(t *testing.T, acceptEncoding string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", acceptEncoding)
+	rec := httptest.NewRecorder()
+	withCompression(handler).ServeHTTP(rec, r)
+	return rec
+}
+
+// decodedBody returns the response body decoded according to its Content-Encoding.
+func decodedBody(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	var body io.Reader = rec.Body
+	var err error
+	switch rec.Header().Get("Content-Encoding") {
+	case encodingGzip:
+		body, err = gzip.NewReader(rec.Body)
+	case encodingDeflate:
+		body, err = zlib.NewReader(rec.Body)
+	}
+	if err != nil {
+		t.Fatalf("open %s body: %v", rec.Header().Get("Content-Encoding"), err)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("read %s body: %v", rec.Header().Get("Content-Encoding"), err)
+	}
+	return string(data)
+}
+
+func TestCompressionNegotiatesEncoding(t *testing.T) {
+	const reply = `{"text": "hello"}`
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"deflate, gzip", encodingGzip},
+		{"gzip;q=0, deflate", encodingDeflate},
+		{"*", encodingGzip},
+		{"identity", ""},
+	}
+	for _, test := range tests {
+		rec := serveCompressed(t, test.acceptEncoding, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, reply)
+		})
+		if got := rec.Header().Get("Content-Encoding"); got != test.want {
+			t.Errorf("Accept-Encoding %q: Content-Encoding = %q, want %q", test.acceptEncoding, got, test.want)
+		}
+		if got := decodedBody(t, rec); got != reply {
+			t.Errorf("Accept-Encoding %q: body = %q, want %q", test.acceptEncoding, got, reply)
+		}
+	}
+}
+
+func TestCompressionDropsStaleContentLength(t *testing.T) {
+	const reply = "a body whose length was set by the handler"
+	rec := serveCompressed(t, encodingGzip, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Header().Set("Content-Length", strconv.Itoa(len(reply)))
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, reply)
+	})
+	if got := rec.Header().Get("Content-Length"); got != "" {
+		t.Errorf("Content-Length = %q on a compressed body", got)
+	}
+	if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Errorf("Vary = %q, want Accept-Encoding", got)
+	}
+	if got := decodedBody(t, rec); got != reply {
+		t.Errorf("body = %q, want %q", got, reply)
+	}
+}
+
+func TestCompressionPassesThrough(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		body    string
+	}{
+		{"event stream", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/event-stream")
+			_, _ = io.WriteString(w, "data: {}\n\n")
+			w.(http.Flusher).Flush()
+		}, "data: {}\n\n"},
+		{"no content", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}, ""},
+		{"not modified", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotModified)
+		}, ""},
+		{"already encoded", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", "br")
+			_, _ = io.WriteString(w, "brotli bytes")
+		}, "brotli bytes"},
+	}
+	for _, test := range tests {
+		rec := serveCompressed(t, encodingGzip, test.handler)
+		if got := rec.Header().Get("Content-Encoding"); got != "" && got != "br" {
+			t.Errorf("%s: Content-Encoding = %q, want the response passed through", test.name, got)
+		}
+		if got := rec.Body.String(); got != test.body {
+			t.Errorf("%s: body = %q, want %q", test.name, got, test.body)
+		}
+	}
+}
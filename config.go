@@ -0,0 +1,917 @@
+// Server configuration from an optional YAML or TOML file and environment variables
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/sashabaranov/go-openai"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultOpenAIBaseURL is used when neither OPENAI_BASE_URL nor OPENAI_BASE_URLS is set.
+const defaultOpenAIBaseURL = "https://api.openai.com/v1"
+
+// Config is the complete server configuration. It is read from the file named by
+// CONFIG_FILE, if any, and every environment variable that is set overrides the
+// corresponding file value.
+type Config struct {
+	Server    ServerConfig    `yaml:"server" toml:"server"`
+	Auth      AuthConfig      `yaml:"auth" toml:"auth"`
+	OpenAI    OpenAIConfig    `yaml:"openai" toml:"openai"`
+	Personas  PersonasConfig  `yaml:"personas" toml:"personas"`
+	Streaming StreamingConfig `yaml:"streaming" toml:"streaming"`
+	Limits    LimitsConfig    `yaml:"limits" toml:"limits"`
+	History   HistoryConfig   `yaml:"history" toml:"history"`
+	Session   SessionConfig   `yaml:"session" toml:"session"`
+	Speech    SpeechConfig    `yaml:"speech" toml:"speech"`
+	TRTC      TRTCConfig      `yaml:"trtc" toml:"trtc"`
+	Push      PushConfig      `yaml:"push" toml:"push"`
+	HTTP      HTTPConfig      `yaml:"http" toml:"http"`
+
+	Profiles map[string]map[string]interface{} `yaml:"profiles,omitempty" toml:"profiles"`
+	Profile  string                            `yaml:"-" toml:"-"`
+}
+
+// ServerConfig covers the listener, TLS, CORS and optional transports.
+type ServerConfig struct {
+	Host               string   `yaml:"host" toml:"host"`
+	Port               int      `yaml:"port" toml:"port"`
+	PublicURL          string   `yaml:"public_url" toml:"public_url"`
+	TLSCertFile        string   `yaml:"tls_cert_file" toml:"tls_cert_file"`
+	TLSKeyFile         string   `yaml:"tls_key_file" toml:"tls_key_file"`
+	CORSAllowedOrigins []string `yaml:"cors_allowed_origins" toml:"cors_allowed_origins"`
+	EnabledSkills      []string `yaml:"enabled_skills" toml:"enabled_skills"`
+	CORSAllowedMethods string   `yaml:"cors_allowed_methods" toml:"cors_allowed_methods"`
+	CORSAllowedHeaders string   `yaml:"cors_allowed_headers" toml:"cors_allowed_headers"`
+	WebSocket          bool     `yaml:"websocket" toml:"websocket"`
+	AccessLog          bool     `yaml:"access_log" toml:"access_log"`
+	Compression        bool     `yaml:"compression" toml:"compression"`
+	RecordTasks        bool     `yaml:"record_tasks" toml:"record_tasks"`
+	TaskLogDir         string   `yaml:"task_log_dir" toml:"task_log_dir"`
+	WarmupOnStart      bool     `yaml:"warmup_on_start" toml:"warmup_on_start"`
+	Metrics            bool     `yaml:"metrics" toml:"metrics"`
+}
+
+// AuthConfig covers client API keys and the admin token.
+type AuthConfig struct {
+	Disabled           bool          `yaml:"disabled" toml:"disabled"`
+	APIKeys            []string      `yaml:"api_keys" toml:"api_keys"`
+	APIKeysFile        string        `yaml:"api_keys_file" toml:"api_keys_file"`
+	AdminToken         string        `yaml:"admin_token" toml:"admin_token"`
+	SigningSecret      string        `yaml:"signing_secret" toml:"signing_secret"`
+	SignatureTolerance time.Duration `yaml:"signature_tolerance" toml:"signature_tolerance"`
+}
+
+// OpenAIConfig covers the OpenAI-compatible backend and the default sampling settings.
+type OpenAIConfig struct {
+	APIKey             string   `yaml:"api_key" toml:"api_key"`
+	Model              string   `yaml:"model" toml:"model"`
+	ModelAllowlist     []string `yaml:"model_allowlist" toml:"model_allowlist"`
+	OrgID              string   `yaml:"org_id" toml:"org_id"`
+	ProjectID          string   `yaml:"project_id" toml:"project_id"`
+	BaseURL            string   `yaml:"base_url" toml:"base_url"`
+	BaseURLs           []string `yaml:"base_urls" toml:"base_urls"`
+	StartupProbe       bool     `yaml:"startup_probe" toml:"startup_probe"`
+	StopSequences      []string `yaml:"stop_sequences" toml:"stop_sequences"`
+	MaxTokens          int      `yaml:"max_tokens" toml:"max_tokens"`
+	EmptyOutputRetries int      `yaml:"empty_output_retries" toml:"empty_output_retries"`
+	PresencePenalty    *float64 `yaml:"presence_penalty" toml:"presence_penalty"`
+	FrequencyPenalty   *float64 `yaml:"frequency_penalty" toml:"frequency_penalty"`
+	Temperature        *float64 `yaml:"temperature" toml:"temperature"`
+	TopP               *float64 `yaml:"top_p" toml:"top_p"`
+	ModerationMode     string   `yaml:"moderation_mode" toml:"moderation_mode"`
+	DegradedMode       bool     `yaml:"degraded_mode" toml:"degraded_mode"`
+	DegradedResponse   string   `yaml:"degraded_response" toml:"degraded_response"`
+	Seed               *int     `yaml:"seed" toml:"seed"`
+	PromptCache        bool     `yaml:"prompt_cache" toml:"prompt_cache"`
+}
+
+// PersonasConfig covers persona prompts and the per-persona overrides.
+type PersonasConfig struct {
+	PromptsDir         string             `yaml:"prompts_dir" toml:"prompts_dir"`
+	IntentPrompt       string             `yaml:"intent_prompt" toml:"intent_prompt"`
+	Locales            []string           `yaml:"locales" toml:"locales"`
+	DefaultLocale      string             `yaml:"default_locale" toml:"default_locale"`
+	HotReload          bool               `yaml:"hot_reload" toml:"hot_reload"`
+	Guard              string             `yaml:"guard" toml:"guard"`
+	MetadataKeys       []string           `yaml:"metadata_keys" toml:"metadata_keys"`
+	Models             map[string]string  `yaml:"models" toml:"models"`
+	PresencePenalties  map[string]float64 `yaml:"presence_penalties" toml:"presence_penalties"`
+	FrequencyPenalties map[string]float64 `yaml:"frequency_penalties" toml:"frequency_penalties"`
+	Temperatures       map[string]float64 `yaml:"temperatures" toml:"temperatures"`
+	TopP               map[string]float64 `yaml:"top_p" toml:"top_p"`
+	JSON               []string           `yaml:"json" toml:"json"`
+	DiscloseName       bool               `yaml:"disclose_name" toml:"disclose_name"`
+	DiscloseNames      map[string]bool    `yaml:"disclose_names" toml:"disclose_names"`
+	SelfDescription    bool               `yaml:"self_description" toml:"self_description"`
+	InterruptOnInput   map[string]bool    `yaml:"interrupt_on_new_input" toml:"interrupt_on_new_input"`
+	DegradedResponses  map[string]string  `yaml:"degraded_responses" toml:"degraded_responses"`
+	Handoff            bool               `yaml:"handoff" toml:"handoff"`
+	Clarify            bool               `yaml:"clarifying_questions" toml:"clarifying_questions"`
+	Router             string             `yaml:"router" toml:"router"`
+	RouterKeywords     []KeywordRule      `yaml:"router_keywords" toml:"router_keywords"`
+	IntentCacheSize    int                `yaml:"intent_cache_size" toml:"intent_cache_size"`
+	IntentCacheTTL     time.Duration      `yaml:"intent_cache_ttl" toml:"intent_cache_ttl"`
+}
+
+// StreamingConfig covers how replies are delivered to clients.
+type StreamingConfig struct {
+	ForceStreaming    bool          `yaml:"force_streaming" toml:"force_streaming"`
+	ForceNonStreaming bool          `yaml:"force_non_streaming" toml:"force_non_streaming"`
+	KeepAliveInterval time.Duration `yaml:"keepalive_interval" toml:"keepalive_interval"`
+	ChunkBatchSize    int           `yaml:"chunk_batch_size" toml:"chunk_batch_size"`
+	BufferSize        int           `yaml:"buffer_size" toml:"buffer_size"`
+	BufferPolicy      string        `yaml:"buffer_policy" toml:"buffer_policy"`
+	EmitFailureLimit  int           `yaml:"artifact_failure_limit" toml:"artifact_failure_limit"`
+	MaxOutputChars    int           `yaml:"max_output_chars" toml:"max_output_chars"`
+	Fallback          bool          `yaml:"fallback_non_streaming" toml:"fallback_non_streaming"`
+	FallbackMaxChunks int           `yaml:"fallback_max_chunks" toml:"fallback_max_chunks"`
+	PostProcessors    []string      `yaml:"post_processors" toml:"post_processors"`
+	Replacements      []ReplaceRule `yaml:"replacements" toml:"replacements"`
+	Disclaimer        string        `yaml:"disclaimer" toml:"disclaimer"`
+}
+
+// LimitsConfig covers concurrency, timeouts and quotas.
+type LimitsConfig struct {
+	MaxConcurrentLLMCalls int           `yaml:"max_concurrent_llm_calls" toml:"max_concurrent_llm_calls"`
+	LLMQueueTimeout       time.Duration `yaml:"llm_queue_timeout" toml:"llm_queue_timeout"`
+	LLMBusyPolicy         string        `yaml:"llm_busy_policy" toml:"llm_busy_policy"`
+	SessionConcurrency    int           `yaml:"max_concurrent_tasks_per_session" toml:"max_concurrent_tasks_per_session"`
+	SessionQueueTimeout   time.Duration `yaml:"session_queue_timeout" toml:"session_queue_timeout"`
+	SessionBusyPolicy     string        `yaml:"session_busy_policy" toml:"session_busy_policy"`
+	IdempotencyTTL        time.Duration `yaml:"idempotency_ttl" toml:"idempotency_ttl"`
+	MaxClientTimeout      time.Duration `yaml:"max_client_timeout" toml:"max_client_timeout"`
+	MaxTaskDuration       time.Duration `yaml:"max_task_duration" toml:"max_task_duration"`
+	IntentTimeout         time.Duration `yaml:"intent_timeout" toml:"intent_timeout"`
+	InjectionPolicy       string        `yaml:"injection_policy" toml:"injection_policy"`
+	DailyTokenQuota       int           `yaml:"daily_token_quota" toml:"daily_token_quota"`
+	DailyRequestQuota     int           `yaml:"daily_request_quota" toml:"daily_request_quota"`
+	QuotaUnlimitedKeys    []string      `yaml:"quota_unlimited_keys" toml:"quota_unlimited_keys"`
+	BatchConcurrency      int           `yaml:"batch_concurrency" toml:"batch_concurrency"`
+	BatchMaxItems         int           `yaml:"batch_max_items" toml:"batch_max_items"`
+	MaxInputChars         int           `yaml:"max_input_chars" toml:"max_input_chars"`
+	InputOverflowPolicy   string        `yaml:"input_overflow_policy" toml:"input_overflow_policy"`
+
+	APIKeyPriorities    map[string]int `yaml:"api_key_priorities" toml:"api_key_priorities"`
+	PriorityMetadataKey string         `yaml:"priority_metadata_key" toml:"priority_metadata_key"`
+}
+
+// SessionConfig covers how a task's session ID is derived.
+type SessionConfig struct {
+	Strategy    string `yaml:"id_strategy" toml:"id_strategy"`
+	Separator   string `yaml:"id_separator" toml:"id_separator"`
+	MetadataKey string `yaml:"id_metadata_key" toml:"id_metadata_key"`
+	Header      string `yaml:"id_header" toml:"id_header"`
+}
+
+// HistoryConfig covers per-session conversation history.
+type HistoryConfig struct {
+	MaxTurns      int    `yaml:"max_turns" toml:"max_turns"`
+	AutoSummarize bool   `yaml:"auto_summarize" toml:"auto_summarize"`
+	SummaryModel  string `yaml:"summary_model" toml:"summary_model"`
+}
+
+// SpeechConfig covers audio input and output.
+type SpeechConfig struct {
+	STTProvider   string            `yaml:"stt_provider" toml:"stt_provider"`
+	STTModel      string            `yaml:"stt_model" toml:"stt_model"`
+	Provider      string            `yaml:"provider" toml:"provider"`
+	Model         string            `yaml:"model" toml:"model"`
+	Voice         string            `yaml:"voice" toml:"voice"`
+	Personas      []string          `yaml:"personas" toml:"personas"`
+	PersonaVoices map[string]string `yaml:"persona_voices" toml:"persona_voices"`
+}
+
+// TRTCConfig covers the TRTC AI conversation API and the TTS credentials it is given.
+type TRTCConfig struct {
+	SecretID         string        `yaml:"secret_id" toml:"secret_id"`
+	SecretKey        string        `yaml:"secret_key" toml:"secret_key"`
+	Region           string        `yaml:"region" toml:"region"`
+	Endpoint         string        `yaml:"endpoint" toml:"endpoint"`
+	Timeout          time.Duration `yaml:"timeout" toml:"timeout"`
+	MaxRetries       int           `yaml:"max_retries" toml:"max_retries"`
+	TTSAppID         int           `yaml:"tts_app_id" toml:"tts_app_id"`
+	TTSSecretID      string        `yaml:"tts_secret_id" toml:"tts_secret_id"`
+	TTSSecretKey     string        `yaml:"tts_secret_key" toml:"tts_secret_key"`
+	FailureAction    string        `yaml:"failure_action" toml:"failure_action"`
+	FailureApology   string        `yaml:"failure_apology" toml:"failure_apology"`
+	InterruptOnInput bool          `yaml:"interrupt_on_new_input" toml:"interrupt_on_new_input"`
+	FanoutLimit      int           `yaml:"fanout_concurrency" toml:"fanout_concurrency"`
+	TaskIDMinLength  int           `yaml:"task_id_min_length" toml:"task_id_min_length"`
+	TaskIDPattern    string        `yaml:"task_id_pattern" toml:"task_id_pattern"`
+
+	TTSVoiceType     int            `yaml:"tts_voice_type" toml:"tts_voice_type"`
+	TTSPersonaVoices map[string]int `yaml:"persona_tts_voices" toml:"persona_tts_voices"`
+}
+
+// PushConfig covers A2A push notifications.
+type PushConfig struct {
+	Enabled       bool   `yaml:"enabled" toml:"enabled"`
+	SigningSecret string `yaml:"signing_secret" toml:"signing_secret"`
+	MaxRetries    int    `yaml:"max_retries" toml:"max_retries"`
+}
+
+// HTTPConfig covers the connection pool and timeouts of the HTTP client for OpenAI.
+type HTTPConfig struct {
+	MaxIdleConns          int           `yaml:"max_idle_conns" toml:"max_idle_conns"`
+	MaxIdleConnsPerHost   int           `yaml:"max_idle_conns_per_host" toml:"max_idle_conns_per_host"`
+	MaxConnsPerHost       int           `yaml:"max_conns_per_host" toml:"max_conns_per_host"`
+	IdleConnTimeout       time.Duration `yaml:"idle_conn_timeout" toml:"idle_conn_timeout"`
+	DialTimeout           time.Duration `yaml:"dial_timeout" toml:"dial_timeout"`
+	KeepAlive             time.Duration `yaml:"keep_alive" toml:"keep_alive"`
+	TLSHandshakeTimeout   time.Duration `yaml:"tls_handshake_timeout" toml:"tls_handshake_timeout"`
+	ResponseHeaderTimeout time.Duration `yaml:"response_header_timeout" toml:"response_header_timeout"`
+}
+
+// defaultConfig returns the configuration used when nothing is set
+func defaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Host:               "localhost",
+			Port:               8080,
+			CORSAllowedMethods: "GET, POST, OPTIONS",
+			CORSAllowedHeaders: "Content-Type, Authorization, X-API-Key",
+			AccessLog:          true,
+		},
+		Auth: AuthConfig{
+			SignatureTolerance: 5 * time.Minute,
+		},
+		OpenAI: OpenAIConfig{
+			Model:              "gpt-3.5-turbo",
+			StartupProbe:       true,
+			EmptyOutputRetries: 1,
+			ModerationMode:     moderationOff,
+		},
+		Personas: PersonasConfig{
+			DefaultLocale: defaultLocale,
+			DiscloseName:  true,
+			Router:        routerLLM,
+
+			IntentCacheTTL: 10 * time.Minute,
+		},
+		Streaming: StreamingConfig{
+			KeepAliveInterval: 15 * time.Second,
+			ChunkBatchSize:    1,
+			BufferSize:        64,
+			BufferPolicy:      streamBufferBlock,
+			FallbackMaxChunks: 5,
+		},
+		Limits: LimitsConfig{
+			LLMQueueTimeout:  5 * time.Second,
+			LLMBusyPolicy:    busyPolicyQueue,
+			IdempotencyTTL:   10 * time.Minute,
+			IntentTimeout:    5 * time.Second,
+			BatchConcurrency: 4,
+			BatchMaxItems:    100,
+
+			SessionConcurrency:  1,
+			SessionQueueTimeout: 30 * time.Second,
+			SessionBusyPolicy:   busyPolicyQueue,
+			InputOverflowPolicy: inputOverflowFail,
+		},
+		Speech: SpeechConfig{
+			STTModel: openai.Whisper1,
+			Model:    string(openai.TTSModel1),
+			Voice:    string(openai.VoiceAlloy),
+		},
+		TRTC: TRTCConfig{
+			Timeout:         defaultTRTCTimeout,
+			MaxRetries:      defaultTRTCMaxRetries,
+			FanoutLimit:     4,
+			TaskIDMinLength: defaultTRTCTaskIDMinLength,
+			TaskIDPattern:   defaultTRTCTaskIDPattern,
+			TTSVoiceType:    VoiceTypeXiaoMei,
+		},
+		Push: PushConfig{
+			MaxRetries: 3,
+		},
+		Session: SessionConfig{
+			Strategy:    sessionStrategySession,
+			Separator:   ":",
+			MetadataKey: "session_id",
+			Header:      "X-Session-ID",
+		},
+		HTTP: HTTPConfig{
+			MaxIdleConns:        100,
+			MaxIdleConnsPerHost: 32,
+			IdleConnTimeout:     90 * time.Second,
+			DialTimeout:         10 * time.Second,
+			KeepAlive:           30 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+	}
+}
+
+// LoadConfig builds the configuration from the defaults, the file named by
+// CONFIG_FILE and the environment, in increasing precedence, and validates it
+func LoadConfig() (*Config, error) {
+	cfg, err := buildConfig(func(cfg *Config) error {
+		if path := os.Getenv("CONFIG_FILE"); path != "" {
+			return cfg.loadFile(path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// buildConfig applies load and then the environment over the defaults and validates
+// the result. The configuration is returned with any validation error, so callers
+// can check it further; it is nil if load or the environment failed.
+func buildConfig(load func(*Config) error) (*Config, error) {
+	cfg := defaultConfig()
+	if err := load(cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if cfg.History.SummaryModel == "" {
+		cfg.History.SummaryModel = cfg.OpenAI.Model
+	}
+	return cfg, cfg.Validate()
+}
+
+// loadFile decodes a YAML (.yaml, .yml) or TOML (.toml) file over cfg
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		return c.load(data, configFormatYAML, "config file "+path)
+	case ".toml":
+		return c.load(data, configFormatTOML, "config file "+path)
+	default:
+		return fmt.Errorf("unsupported config file extension %q, expected .yaml, .yml or .toml", ext)
+	}
+}
+
+// Config file formats
+const (
+	configFormatYAML = "yaml"
+	configFormatTOML = "toml"
+)
+
+// decode decodes data in format over cfg, naming source in errors. Unknown keys are
+// rejected so a typo does not silently leave a setting at its default.
+func (c *Config) decode(data []byte, format, source string) error {
+	switch format {
+	case configFormatYAML:
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		if err := decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("invalid %s: %w", source, err)
+		}
+	case configFormatTOML:
+		meta, err := toml.Decode(string(data), c)
+		if err != nil {
+			return fmt.Errorf("invalid %s: %w", source, err)
+		}
+		for _, key := range meta.Undecoded() {
+			// Profiles are checked when one is applied.
+			if key[0] != "profiles" {
+				return fmt.Errorf("invalid %s: unknown key %q", source, key.String())
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported config format %q, expected %q or %q", format, configFormatYAML, configFormatTOML)
+	}
+	return nil
+}
+
+// applyEnv overrides cfg with every configuration environment variable that is set
+func (c *Config) applyEnv() error {
+	env := &envOverrides{}
+
+	env.str("SERVER_HOST", &c.Server.Host)
+	env.integer("SERVER_PORT", &c.Server.Port)
+	env.str("PUBLIC_URL", &c.Server.PublicURL)
+	env.str("TLS_CERT_FILE", &c.Server.TLSCertFile)
+	env.str("TLS_KEY_FILE", &c.Server.TLSKeyFile)
+	env.list("CORS_ALLOWED_ORIGINS", &c.Server.CORSAllowedOrigins)
+	env.list("ENABLED_SKILLS", &c.Server.EnabledSkills)
+	env.str("CORS_ALLOWED_METHODS", &c.Server.CORSAllowedMethods)
+	env.str("CORS_ALLOWED_HEADERS", &c.Server.CORSAllowedHeaders)
+	env.boolean("WS_ENABLED", &c.Server.WebSocket)
+	env.boolean("ACCESS_LOG", &c.Server.AccessLog)
+	env.boolean("HTTP_COMPRESSION", &c.Server.Compression)
+	env.boolean("RECORD_TASKS", &c.Server.RecordTasks)
+	env.str("TASK_LOG_DIR", &c.Server.TaskLogDir)
+	env.boolean("WARMUP_ON_START", &c.Server.WarmupOnStart)
+	env.boolean("METRICS_ENABLED", &c.Server.Metrics)
+
+	env.boolean("AUTH_DISABLED", &c.Auth.Disabled)
+	env.list("API_KEYS", &c.Auth.APIKeys)
+	env.str("API_KEYS_FILE", &c.Auth.APIKeysFile)
+	env.str("ADMIN_TOKEN", &c.Auth.AdminToken)
+	env.str("INBOUND_SIGNING_SECRET", &c.Auth.SigningSecret)
+	env.duration("INBOUND_SIGNATURE_TOLERANCE", &c.Auth.SignatureTolerance)
+
+	env.str("OPENAI_API_KEY", &c.OpenAI.APIKey)
+	env.str("OPENAI_MODEL", &c.OpenAI.Model)
+	env.list("MODEL_ALLOWLIST", &c.OpenAI.ModelAllowlist)
+	env.str("OPENAI_ORG_ID", &c.OpenAI.OrgID)
+	env.str("OPENAI_PROJECT_ID", &c.OpenAI.ProjectID)
+	env.str("OPENAI_BASE_URL", &c.OpenAI.BaseURL)
+	env.list("OPENAI_BASE_URLS", &c.OpenAI.BaseURLs)
+	env.boolean("OPENAI_STARTUP_PROBE", &c.OpenAI.StartupProbe)
+	env.list("OPENAI_STOP_SEQUENCES", &c.OpenAI.StopSequences)
+	env.integer("OPENAI_MAX_TOKENS", &c.OpenAI.MaxTokens)
+	env.optionalInteger("OPENAI_SEED", &c.OpenAI.Seed)
+	env.boolean("ENABLE_PROMPT_CACHE", &c.OpenAI.PromptCache)
+	env.integer("EMPTY_OUTPUT_RETRIES", &c.OpenAI.EmptyOutputRetries)
+	env.float("OPENAI_PRESENCE_PENALTY", &c.OpenAI.PresencePenalty)
+	env.float("OPENAI_FREQUENCY_PENALTY", &c.OpenAI.FrequencyPenalty)
+	env.float("OPENAI_TEMPERATURE", &c.OpenAI.Temperature)
+	env.float("OPENAI_TOP_P", &c.OpenAI.TopP)
+	env.str("MODERATION_MODE", &c.OpenAI.ModerationMode)
+	env.boolean("DEGRADED_MODE", &c.OpenAI.DegradedMode)
+	env.str("DEGRADED_RESPONSE", &c.OpenAI.DegradedResponse)
+
+	env.str("PROMPTS_DIR", &c.Personas.PromptsDir)
+	env.str("INTENT_PROMPT", &c.Personas.IntentPrompt)
+	env.list("PROMPT_LOCALES", &c.Personas.Locales)
+	env.str("PROMPT_DEFAULT_LOCALE", &c.Personas.DefaultLocale)
+	env.boolean("PROMPTS_HOT_RELOAD", &c.Personas.HotReload)
+	env.str("GUARD_PERSONA", &c.Personas.Guard)
+	env.list("PROMPT_METADATA_KEYS", &c.Personas.MetadataKeys)
+	env.stringMap("PERSONA_MODELS", &c.Personas.Models)
+	env.floatMap("PERSONA_PRESENCE_PENALTIES", &c.Personas.PresencePenalties)
+	env.floatMap("PERSONA_FREQUENCY_PENALTIES", &c.Personas.FrequencyPenalties)
+	env.floatMap("PERSONA_TEMPERATURES", &c.Personas.Temperatures)
+	env.floatMap("PERSONA_TOP_P", &c.Personas.TopP)
+	env.list("JSON_PERSONAS", &c.Personas.JSON)
+	env.boolean("DISCLOSE_NAME", &c.Personas.DiscloseName)
+	env.boolMap("PERSONA_DISCLOSE_NAME", &c.Personas.DiscloseNames)
+	env.boolean("PERSONA_SELF_DESCRIPTION", &c.Personas.SelfDescription)
+	env.boolMap("PERSONA_INTERRUPT_ON_NEW_INPUT", &c.Personas.InterruptOnInput)
+	env.stringMap("PERSONA_DEGRADED_RESPONSES", &c.Personas.DegradedResponses)
+	env.boolean("PERSONA_HANDOFF", &c.Personas.Handoff)
+	env.boolean("CLARIFYING_QUESTIONS", &c.Personas.Clarify)
+	env.str("ROUTER", &c.Personas.Router)
+	env.keywordRules("ROUTER_KEYWORDS", &c.Personas.RouterKeywords)
+	env.integer("INTENT_CACHE_SIZE", &c.Personas.IntentCacheSize)
+	env.duration("INTENT_CACHE_TTL", &c.Personas.IntentCacheTTL)
+
+	env.boolean("FORCE_STREAMING", &c.Streaming.ForceStreaming)
+	env.boolean("FORCE_NON_STREAMING", &c.Streaming.ForceNonStreaming)
+	env.duration("SSE_KEEPALIVE_INTERVAL", &c.Streaming.KeepAliveInterval)
+	env.integer("CHUNK_BATCH_SIZE", &c.Streaming.ChunkBatchSize)
+	env.integer("STREAM_BUFFER_SIZE", &c.Streaming.BufferSize)
+	env.str("STREAM_BUFFER_POLICY", &c.Streaming.BufferPolicy)
+	env.integer("STREAM_ARTIFACT_FAILURE_LIMIT", &c.Streaming.EmitFailureLimit)
+	env.integer("MAX_OUTPUT_CHARS", &c.Streaming.MaxOutputChars)
+	env.boolean("STREAM_FALLBACK_NONSTREAM", &c.Streaming.Fallback)
+	env.integer("STREAM_FALLBACK_MAX_CHUNKS", &c.Streaming.FallbackMaxChunks)
+	env.list("POST_PROCESSORS", &c.Streaming.PostProcessors)
+	env.replaceRules("POST_PROCESS_REPLACEMENTS", &c.Streaming.Replacements)
+	env.str("RESPONSE_DISCLAIMER", &c.Streaming.Disclaimer)
+
+	env.integer("MAX_CONCURRENT_LLM_CALLS", &c.Limits.MaxConcurrentLLMCalls)
+	env.duration("LLM_QUEUE_TIMEOUT", &c.Limits.LLMQueueTimeout)
+	env.str("LLM_BUSY_POLICY", &c.Limits.LLMBusyPolicy)
+	env.intMap("API_KEY_PRIORITIES", &c.Limits.APIKeyPriorities)
+	env.str("PRIORITY_METADATA_KEY", &c.Limits.PriorityMetadataKey)
+	env.integer("MAX_CONCURRENT_TASKS_PER_SESSION", &c.Limits.SessionConcurrency)
+	env.duration("SESSION_QUEUE_TIMEOUT", &c.Limits.SessionQueueTimeout)
+	env.str("SESSION_BUSY_POLICY", &c.Limits.SessionBusyPolicy)
+	env.duration("IDEMPOTENCY_TTL", &c.Limits.IdempotencyTTL)
+	env.duration("MAX_CLIENT_TIMEOUT", &c.Limits.MaxClientTimeout)
+	env.duration("MAX_TASK_DURATION", &c.Limits.MaxTaskDuration)
+	env.duration("INTENT_TIMEOUT", &c.Limits.IntentTimeout)
+	env.str("INJECTION_POLICY", &c.Limits.InjectionPolicy)
+	env.integer("DAILY_TOKEN_QUOTA", &c.Limits.DailyTokenQuota)
+	env.integer("DAILY_REQUEST_QUOTA", &c.Limits.DailyRequestQuota)
+	env.list("QUOTA_UNLIMITED_KEYS", &c.Limits.QuotaUnlimitedKeys)
+	env.integer("BATCH_CONCURRENCY", &c.Limits.BatchConcurrency)
+	env.integer("BATCH_MAX_ITEMS", &c.Limits.BatchMaxItems)
+	env.integer("MAX_INPUT_CHARS", &c.Limits.MaxInputChars)
+	env.str("INPUT_OVERFLOW_POLICY", &c.Limits.InputOverflowPolicy)
+
+	env.integer("HISTORY_MAX_TURNS", &c.History.MaxTurns)
+	env.boolean("AUTO_SUMMARIZE_HISTORY", &c.History.AutoSummarize)
+	env.str("SUMMARY_MODEL", &c.History.SummaryModel)
+
+	env.str("SESSION_ID_STRATEGY", &c.Session.Strategy)
+	env.str("SESSION_ID_SEPARATOR", &c.Session.Separator)
+	env.str("SESSION_ID_METADATA_KEY", &c.Session.MetadataKey)
+	env.str("SESSION_ID_HEADER", &c.Session.Header)
+
+	env.str("STT_PROVIDER", &c.Speech.STTProvider)
+	env.str("STT_MODEL", &c.Speech.STTModel)
+	env.str("SPEECH_PROVIDER", &c.Speech.Provider)
+	env.str("SPEECH_MODEL", &c.Speech.Model)
+	env.str("SPEECH_VOICE", &c.Speech.Voice)
+	env.list("SPEECH_PERSONAS", &c.Speech.Personas)
+	env.stringMap("PERSONA_SPEECH_VOICES", &c.Speech.PersonaVoices)
+
+	env.str("TRTC_SECRET_ID", &c.TRTC.SecretID)
+	env.str("TRTC_SECRET_KEY", &c.TRTC.SecretKey)
+	env.str("TRTC_REGION", &c.TRTC.Region)
+	env.str("TRTC_ENDPOINT", &c.TRTC.Endpoint)
+	env.duration("TRTC_TIMEOUT", &c.TRTC.Timeout)
+	env.integer("TRTC_MAX_RETRIES", &c.TRTC.MaxRetries)
+	env.integer("TTS_APP_ID", &c.TRTC.TTSAppID)
+	env.str("TTS_SECRET_ID", &c.TRTC.TTSSecretID)
+	env.str("TTS_SECRET_KEY", &c.TRTC.TTSSecretKey)
+	env.integer("TTS_VOICE_TYPE", &c.TRTC.TTSVoiceType)
+	env.intMap("PERSONA_TTS_VOICES", &c.TRTC.TTSPersonaVoices)
+	env.str("TRTC_FAILURE_ACTION", &c.TRTC.FailureAction)
+	env.boolean("TRTC_INTERRUPT_ON_NEW_INPUT", &c.TRTC.InterruptOnInput)
+	env.integer("TRTC_FANOUT_CONCURRENCY", &c.TRTC.FanoutLimit)
+	env.integer("TRTC_TASK_ID_MIN_LENGTH", &c.TRTC.TaskIDMinLength)
+	env.str("TRTC_TASK_ID_PATTERN", &c.TRTC.TaskIDPattern)
+	env.str("TRTC_FAILURE_APOLOGY", &c.TRTC.FailureApology)
+
+	env.boolean("PUSH_NOTIFICATIONS_ENABLED", &c.Push.Enabled)
+	env.str("PUSH_SIGNING_SECRET", &c.Push.SigningSecret)
+	env.integer("PUSH_MAX_RETRIES", &c.Push.MaxRetries)
+
+	env.integer("OPENAI_HTTP_MAX_IDLE_CONNS", &c.HTTP.MaxIdleConns)
+	env.integer("OPENAI_HTTP_MAX_IDLE_CONNS_PER_HOST", &c.HTTP.MaxIdleConnsPerHost)
+	env.integer("OPENAI_HTTP_MAX_CONNS_PER_HOST", &c.HTTP.MaxConnsPerHost)
+	env.duration("OPENAI_HTTP_IDLE_CONN_TIMEOUT", &c.HTTP.IdleConnTimeout)
+	env.duration("OPENAI_HTTP_DIAL_TIMEOUT", &c.HTTP.DialTimeout)
+	env.duration("OPENAI_HTTP_KEEP_ALIVE", &c.HTTP.KeepAlive)
+	env.duration("OPENAI_HTTP_TLS_HANDSHAKE_TIMEOUT", &c.HTTP.TLSHandshakeTimeout)
+	env.duration("OPENAI_HTTP_RESPONSE_HEADER_TIMEOUT", &c.HTTP.ResponseHeaderTimeout)
+
+	return errors.Join(env.errs...)
+}
+
+// Validate reports every invalid or conflicting setting in the merged configuration
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(failed bool, format string, args ...interface{}) {
+		if failed {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.OpenAI.APIKey == "", "OPENAI_API_KEY is required")
+	check(strings.ContainsAny(c.OpenAI.OrgID, " \t\r\n"), "OPENAI_ORG_ID %q must not contain whitespace", c.OpenAI.OrgID)
+	check(strings.ContainsAny(c.OpenAI.ProjectID, " \t\r\n"), "OPENAI_PROJECT_ID %q must not contain whitespace", c.OpenAI.ProjectID)
+	check(c.Server.Port <= 0 || c.Server.Port > 65535, "SERVER_PORT %d is not a valid port", c.Server.Port)
+	check((c.Server.TLSCertFile == "") != (c.Server.TLSKeyFile == ""), "TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	check(c.Server.RecordTasks && c.Server.TaskLogDir == "", "RECORD_TASKS needs TASK_LOG_DIR")
+	check(c.Streaming.ForceStreaming && c.Streaming.ForceNonStreaming, "FORCE_STREAMING and FORCE_NON_STREAMING cannot both be set")
+	check(c.TRTC.Region != "" && !trtcRegions[c.TRTC.Region], "unknown TRTC_REGION %q", c.TRTC.Region)
+	if _, err := compileKeywordRules(c.Personas.RouterKeywords); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := newPostProcessors(c.Streaming.PostProcessors, c.Streaming.Replacements, c.Streaming.Disclaimer); err != nil {
+		errs = append(errs, err)
+	}
+	for _, locale := range append([]string{c.Personas.DefaultLocale}, c.Personas.Locales...) {
+		check(!validLocale.MatchString(normalizeLocale(locale)), "locale %q is not a valid language tag such as en, zh or pt-BR", locale)
+	}
+	check(c.Personas.Router == routerKeyword && len(c.Personas.RouterKeywords) == 0, "ROUTER=%s needs ROUTER_KEYWORDS", routerKeyword)
+	check(c.Limits.MaxTaskDuration < 0, "MAX_TASK_DURATION must not be negative")
+	check(c.Limits.BatchConcurrency <= 0, "BATCH_CONCURRENCY must be positive")
+	check(c.Limits.SessionConcurrency < 0, "MAX_CONCURRENT_TASKS_PER_SESSION must not be negative")
+	check(c.Limits.BatchMaxItems < 0, "BATCH_MAX_ITEMS must not be negative")
+	check(c.Limits.MaxInputChars < 0, "MAX_INPUT_CHARS must not be negative")
+	check(c.OpenAI.MaxTokens < 0, "OPENAI_MAX_TOKENS must not be negative")
+	check(c.Personas.IntentCacheSize < 0, "INTENT_CACHE_SIZE must not be negative")
+	check(c.Personas.IntentCacheSize > 0 && c.Personas.IntentCacheTTL <= 0, "INTENT_CACHE_TTL must be positive")
+	check(c.Streaming.EmitFailureLimit < 0, "STREAM_ARTIFACT_FAILURE_LIMIT must not be negative")
+	check(c.Streaming.FallbackMaxChunks < 0, "STREAM_FALLBACK_MAX_CHUNKS must not be negative")
+	check(c.OpenAI.EmptyOutputRetries < 0, "EMPTY_OUTPUT_RETRIES must not be negative")
+	check(c.TRTC.Timeout <= 0, "TRTC_TIMEOUT must be positive")
+	check(c.TRTC.MaxRetries < 0, "TRTC_MAX_RETRIES must not be negative")
+	check(c.TRTC.FanoutLimit <= 0, "TRTC_FANOUT_CONCURRENCY must be positive")
+	check(c.TRTC.TaskIDMinLength <= 0, "TRTC_TASK_ID_MIN_LENGTH must be positive")
+	check(c.TRTC.TTSVoiceType <= 0, "TTS_VOICE_TYPE must be positive")
+	for persona, voice := range c.TRTC.TTSPersonaVoices {
+		check(voice <= 0, "PERSONA_TTS_VOICES entry %s must be a positive voice type", persona)
+	}
+	if _, err := regexp.Compile(c.TRTC.TaskIDPattern); err != nil {
+		errs = append(errs, fmt.Errorf("invalid TRTC_TASK_ID_PATTERN: %w", err))
+	}
+	check(c.Push.MaxRetries < 0, "PUSH_MAX_RETRIES must not be negative")
+	check(c.Session.Strategy == sessionStrategyPrefix && c.Session.Separator == "", "SESSION_ID_STRATEGY=prefix needs SESSION_ID_SEPARATOR")
+	check(c.Session.Strategy == sessionStrategyMetadata && c.Session.MetadataKey == "", "SESSION_ID_STRATEGY=metadata needs SESSION_ID_METADATA_KEY")
+	check(c.Session.Strategy == sessionStrategyHeader && c.Session.Header == "", "SESSION_ID_STRATEGY=header needs SESSION_ID_HEADER")
+	check(c.Auth.SignatureTolerance <= 0, "INBOUND_SIGNATURE_TOLERANCE must be positive")
+	// WebSocket frames carry tasks without a signed body, which would bypass the check.
+	check(c.Server.WebSocket && c.Auth.SigningSecret != "", "WS_ENABLED cannot be combined with INBOUND_SIGNING_SECRET")
+	check(c.HTTP.MaxIdleConns < 0 || c.HTTP.MaxIdleConnsPerHost < 0 || c.HTTP.MaxConnsPerHost < 0,
+		"OPENAI_HTTP_MAX_IDLE_CONNS, OPENAI_HTTP_MAX_IDLE_CONNS_PER_HOST and OPENAI_HTTP_MAX_CONNS_PER_HOST must not be negative")
+	check(c.HTTP.IdleConnTimeout < 0 || c.HTTP.DialTimeout < 0 || c.HTTP.KeepAlive < 0 ||
+		c.HTTP.TLSHandshakeTimeout < 0 || c.HTTP.ResponseHeaderTimeout < 0,
+		"OPENAI_HTTP_* timeouts must not be negative")
+
+	errs = append(errs,
+		oneOf("ROUTER", c.Personas.Router, routerLLM, routerKeyword, routerSticky),
+		oneOf("SESSION_ID_STRATEGY", c.Session.Strategy, sessionStrategySession, sessionStrategyTask,
+			sessionStrategyPrefix, sessionStrategyMetadata, sessionStrategyHeader),
+		oneOf("STREAM_BUFFER_POLICY", c.Streaming.BufferPolicy, streamBufferBlock, streamBufferDropOldest),
+		oneOf("LLM_BUSY_POLICY", c.Limits.LLMBusyPolicy, busyPolicyQueue, busyPolicyReject),
+		oneOf("SESSION_BUSY_POLICY", c.Limits.SessionBusyPolicy, busyPolicyQueue, busyPolicyReject),
+		oneOf("INPUT_OVERFLOW_POLICY", c.Limits.InputOverflowPolicy, inputOverflowFail, inputOverflowTruncateHead, inputOverflowTruncateTail),
+		oneOf("MODERATION_MODE", c.OpenAI.ModerationMode, moderationOff, moderationRedact, moderationFail),
+		oneOf("INJECTION_POLICY", c.Limits.InjectionPolicy, "", injectionOff, injectionLog, injectionFlag, injectionRefuse),
+		oneOf("STT_PROVIDER", c.Speech.STTProvider, "", sttProviderOpenAI),
+		oneOf("SPEECH_PROVIDER", c.Speech.Provider, "", speechProviderOpenAI),
+		oneOf("TRTC_FAILURE_ACTION", c.TRTC.FailureAction, "", trtcFailureNone, trtcFailureInterrupt, trtcFailureApology),
+	)
+
+	errs = append(errs,
+		checkPenalty("OPENAI_PRESENCE_PENALTY", c.OpenAI.PresencePenalty),
+		checkPenalty("OPENAI_FREQUENCY_PENALTY", c.OpenAI.FrequencyPenalty),
+	)
+	for persona, penalty := range c.Personas.PresencePenalties {
+		errs = append(errs, checkPenalty("PERSONA_PRESENCE_PENALTIES entry "+persona, &penalty))
+	}
+	for persona, penalty := range c.Personas.FrequencyPenalties {
+		errs = append(errs, checkPenalty("PERSONA_FREQUENCY_PENALTIES entry "+persona, &penalty))
+	}
+	errs = append(errs,
+		checkRange("OPENAI_TEMPERATURE", c.OpenAI.Temperature, 0, 2),
+		checkRange("OPENAI_TOP_P", c.OpenAI.TopP, 0, 1),
+	)
+	for persona, temperature := range c.Personas.Temperatures {
+		errs = append(errs, checkRange("PERSONA_TEMPERATURES entry "+persona, &temperature, 0, 2))
+	}
+	for persona, topP := range c.Personas.TopP {
+		errs = append(errs, checkRange("PERSONA_TOP_P entry "+persona, &topP, 0, 1))
+	}
+	return errors.Join(errs...)
+}
+
+// oneOf returns an error unless value is one of allowed
+func oneOf(name, value string, allowed ...string) error {
+	for _, candidate := range allowed {
+		if value == candidate {
+			return nil
+		}
+	}
+	var quoted []string
+	for _, candidate := range allowed {
+		if candidate != "" {
+			quoted = append(quoted, strconv.Quote(candidate))
+		}
+	}
+	return fmt.Errorf("unknown %s %q, expected one of %s", name, value, strings.Join(quoted, ", "))
+}
+
+// checkPenalty returns an error if a presence or frequency penalty is outside the -2 to 2 range OpenAI accepts
+func checkPenalty(name string, penalty *float64) error {
+	if penalty != nil && (*penalty < -2 || *penalty > 2) {
+		return fmt.Errorf("%s: penalty %v is outside the range -2 to 2", name, *penalty)
+	}
+	return nil
+}
+
+// checkRange returns an error if an optional sampling parameter is outside low to high
+func checkRange(name string, value *float64, low, high float64) error {
+	if value != nil && (*value < low || *value > high) {
+		return fmt.Errorf("%s: %v is outside the range %v to %v", name, *value, low, high)
+	}
+	return nil
+}
+
+// baseURLs returns the OpenAI base URLs to fail over between
+func (c *OpenAIConfig) baseURLs() []string {
+	if len(c.BaseURLs) > 0 {
+		return c.BaseURLs
+	}
+	if c.BaseURL != "" {
+		return []string{c.BaseURL}
+	}
+	return []string{defaultOpenAIBaseURL}
+}
+
+// sampling returns the default and per-persona sampling parameters
+func (c *Config) sampling() (samplingParams, map[string]samplingParams) {
+	defaults := samplingParams{
+		temperature: float32Ptr(c.OpenAI.Temperature),
+		topP:        float32Ptr(c.OpenAI.TopP),
+		presence:    float32Ptr(c.OpenAI.PresencePenalty),
+		frequency:   float32Ptr(c.OpenAI.FrequencyPenalty),
+	}
+	personas := make(map[string]samplingParams)
+	for persona, temperature := range c.Personas.Temperatures {
+		override := personas[persona]
+		override.temperature = float32Ptr(&temperature)
+		personas[persona] = override
+	}
+	for persona, topP := range c.Personas.TopP {
+		override := personas[persona]
+		override.topP = float32Ptr(&topP)
+		personas[persona] = override
+	}
+	for persona, penalty := range c.Personas.PresencePenalties {
+		override := personas[persona]
+		override.presence = float32Ptr(&penalty)
+		personas[persona] = override
+	}
+	for persona, penalty := range c.Personas.FrequencyPenalties {
+		override := personas[persona]
+		override.frequency = float32Ptr(&penalty)
+		personas[persona] = override
+	}
+	return defaults, personas
+}
+
+// float32Ptr converts an optional float64 to an optional float32
+func float32Ptr(value *float64) *float32 {
+	if value == nil {
+		return nil
+	}
+	result := float32(*value)
+	return &result
+}
+
+// secrets returns the configured credentials, which never appear in logs
+func (c *Config) secrets() []string {
+	var secrets []string
+	for _, field := range c.secretFields() {
+		secrets = append(secrets, *field)
+	}
+	return secrets
+}
+
+// envOverrides applies set environment variables to configuration fields,
+// collecting the ones that do not parse.
+type envOverrides struct {
+	errs []error
+}
+
+// lookup returns the value of key, or false when it is unset or empty
+func (e *envOverrides) lookup(key string) (string, bool) {
+	value := os.Getenv(key)
+	return value, value != ""
+}
+
+// fail records that key holds a value that does not parse
+func (e *envOverrides) fail(key, value, expected string) {
+	e.errs = append(e.errs, fmt.Errorf("invalid %s %q, expected %s", key, value, expected))
+}
+
+// str sets dst to the value of key
+func (e *envOverrides) str(key string, dst *string) {
+	if value, ok := e.lookup(key); ok {
+		*dst = value
+	}
+}
+
+// boolean sets dst to the boolean value of key
+func (e *envOverrides) boolean(key string, dst *bool) {
+	if value, ok := e.lookup(key); ok {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			e.fail(key, value, "true or false")
+			return
+		}
+		*dst = parsed
+	}
+}
+
+// integer sets dst to the integer value of key
+func (e *envOverrides) integer(key string, dst *int) {
+	if value, ok := e.lookup(key); ok {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			e.fail(key, value, "an integer")
+			return
+		}
+		*dst = parsed
+	}
+}
+
+// optionalInteger sets dst to the integer value of key
+func (e *envOverrides) optionalInteger(key string, dst **int) {
+	if value, ok := e.lookup(key); ok {
+		parsed, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			e.fail(key, value, "an integer")
+			return
+		}
+		*dst = &parsed
+	}
+}
+
+// duration sets dst to the duration value of key
+func (e *envOverrides) duration(key string, dst *time.Duration) {
+	if value, ok := e.lookup(key); ok {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			e.fail(key, value, "a duration such as 30s")
+			return
+		}
+		*dst = parsed
+	}
+}
+
+// float sets dst to the number in key
+func (e *envOverrides) float(key string, dst **float64) {
+	if value, ok := e.lookup(key); ok {
+		parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			e.fail(key, value, "a number")
+			return
+		}
+		*dst = &parsed
+	}
+}
+
+// list sets dst to the comma-separated values of key
+func (e *envOverrides) list(key string, dst *[]string) {
+	if _, ok := e.lookup(key); ok {
+		*dst = getEnvList(key)
+	}
+}
+
+// stringMap sets dst to the name=value pairs of key
+func (e *envOverrides) stringMap(key string, dst *map[string]string) {
+	if _, ok := e.lookup(key); ok {
+		*dst = getEnvMap(key)
+	}
+}
+
+// keywordRules sets dst to the persona=pattern rules of key
+func (e *envOverrides) keywordRules(key string, dst *[]KeywordRule) {
+	if value, ok := e.lookup(key); ok {
+		rules, err := parseKeywordRules(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("invalid %s: %w", key, err))
+			return
+		}
+		*dst = rules
+	}
+}
+
+// replaceRules sets dst to the pattern=>replacement rules of key
+func (e *envOverrides) replaceRules(key string, dst *[]ReplaceRule) {
+	if value, ok := e.lookup(key); ok {
+		rules, err := parseReplaceRules(value)
+		if err != nil {
+			e.errs = append(e.errs, fmt.Errorf("invalid %s: %w", key, err))
+			return
+		}
+		*dst = rules
+	}
+}
+
+// boolMap sets dst to the name=boolean pairs of key
+func (e *envOverrides) boolMap(key string, dst *map[string]bool) {
+	if _, ok := e.lookup(key); ok {
+		values := make(map[string]bool)
+		for name, value := range getEnvMap(key) {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				e.fail(key+" entry "+name, value, "true or false")
+				continue
+			}
+			values[name] = parsed
+		}
+		*dst = values
+	}
+}
+
+// intMap sets dst to the name=integer pairs of key
+func (e *envOverrides) intMap(key string, dst *map[string]int) {
+	if _, ok := e.lookup(key); ok {
+		values := make(map[string]int)
+		for name, value := range getEnvMap(key) {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				e.fail(key+" entry "+name, value, "an integer")
+				continue
+			}
+			values[name] = parsed
+		}
+		*dst = values
+	}
+}
+
+// floatMap sets dst to the name=number pairs of key
+func (e *envOverrides) floatMap(key string, dst *map[string]float64) {
+	if _, ok := e.lookup(key); ok {
+		values := make(map[string]float64)
+		for name, value := range getEnvMap(key) {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				e.fail(key+" entry "+name, value, "a number")
+				continue
+			}
+			values[name] = parsed
+		}
+		*dst = values
+	}
+}
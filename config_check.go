@@ -0,0 +1,152 @@
+// Validation of a candidate configuration without applying it
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// maxCandidateConfigBytes bounds the body of POST /admin/validate-config.
+const maxCandidateConfigBytes = 1 << 20
+
+// openAIVoices are the voices OpenAI speech synthesis offers; other backends may
+// offer more, so an unknown voice is only a warning.
+var openAIVoices = map[string]bool{
+	string(openai.VoiceAlloy): true, string(openai.VoiceEcho): true, string(openai.VoiceFable): true,
+	string(openai.VoiceOnyx): true, string(openai.VoiceNova): true, string(openai.VoiceShimmer): true,
+}
+
+// configReport is the result of checking a candidate configuration.
+type configReport struct {
+	Valid    bool     `json:"valid"`
+	Errors   []string `json:"errors"`
+	Warnings []string `json:"warnings"`
+}
+
+// checkCandidateConfig runs the startup checks on a config file in format, with the
+// server's environment applied over it as at startup: the merged settings are
+// validated and the prompts, router and post-processors are built, then discarded.
+// Errors would stop the server from starting; warnings are settings that would be
+// ignored or may not work.
+func checkCandidateConfig(data []byte, format string) configReport {
+	report := configReport{Errors: []string{}, Warnings: []string{}}
+	cfg, err := buildConfig(func(cfg *Config) error {
+		return cfg.load(data, format, "config")
+	})
+	report.addErrors(err)
+	if cfg == nil {
+		return report
+	}
+
+	prompts, err := newPromptStore(cfg.Personas.PromptsDir, cfg.Personas.Guard, cfg.Personas.IntentPrompt,
+		cfg.Personas.DefaultLocale, cfg.Personas.Locales)
+	if err != nil {
+		report.addErrors(fmt.Errorf("failed to load prompts: %w", err))
+	}
+	if _, err := newRouter(cfg.Personas.Router, cfg.Personas.RouterKeywords, nil); err != nil {
+		report.addErrors(fmt.Errorf("invalid routing settings: %w", err))
+	}
+	if _, err := newPostProcessors(cfg.Streaming.PostProcessors, cfg.Streaming.Replacements, cfg.Streaming.Disclaimer); err != nil {
+		report.addErrors(fmt.Errorf("invalid post-processing settings: %w", err))
+	}
+	if _, err := newTRTCFailureHandler(cfg.TRTC.FailureAction, cfg.TRTC.FailureApology); err != nil {
+		report.addErrors(fmt.Errorf("invalid TRTC failure settings: %w", err))
+	}
+
+	if prompts != nil {
+		report.warnUnknownPersonas(cfg, prompts.snapshot())
+	}
+	voices := map[string]string{"SPEECH_VOICE": cfg.Speech.Voice}
+	for persona, voice := range cfg.Speech.PersonaVoices {
+		voices["PERSONA_SPEECH_VOICES entry "+persona] = voice
+	}
+	for _, name := range sortedKeys(voices) {
+		if cfg.Speech.Provider == speechProviderOpenAI && !openAIVoices[voices[name]] {
+			report.Warnings = append(report.Warnings, fmt.Sprintf("%s: %q is not an OpenAI voice", name, voices[name]))
+		}
+	}
+	if cfg.Personas.HotReload && cfg.Personas.PromptsDir == "" {
+		report.Warnings = append(report.Warnings, "PROMPTS_HOT_RELOAD is ignored because PROMPTS_DIR is not set")
+	}
+	report.Valid = len(report.Errors) == 0
+	return report
+}
+
+// addErrors records err, one entry per joined error
+func (r *configReport) addErrors(err error) {
+	if err == nil {
+		return
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, err := range joined.Unwrap() {
+			r.addErrors(err)
+		}
+		return
+	}
+	r.Errors = append(r.Errors, err.Error())
+}
+
+// warnUnknownPersonas warns about per-persona settings naming a persona the prompts
+// do not define, which are never used
+func (r *configReport) warnUnknownPersonas(cfg *Config, prompts *promptSet) {
+	named := map[string][]string{
+		"PERSONA_MODELS":                 sortedKeys(cfg.Personas.Models),
+		"PERSONA_PRESENCE_PENALTIES":     sortedKeys(cfg.Personas.PresencePenalties),
+		"PERSONA_FREQUENCY_PENALTIES":    sortedKeys(cfg.Personas.FrequencyPenalties),
+		"PERSONA_TEMPERATURES":           sortedKeys(cfg.Personas.Temperatures),
+		"PERSONA_TOP_P":                  sortedKeys(cfg.Personas.TopP),
+		"PERSONA_DISCLOSE_NAME":          sortedKeys(cfg.Personas.DiscloseNames),
+		"PERSONA_INTERRUPT_ON_NEW_INPUT": sortedKeys(cfg.Personas.InterruptOnInput),
+		"PERSONA_DEGRADED_RESPONSES":     sortedKeys(cfg.Personas.DegradedResponses),
+		"JSON_PERSONAS":                  cfg.Personas.JSON,
+		"SPEECH_PERSONAS":                cfg.Speech.Personas,
+		"PERSONA_SPEECH_VOICES":          sortedKeys(cfg.Speech.PersonaVoices),
+		"PERSONA_TTS_VOICES":             sortedKeys(cfg.TRTC.TTSPersonaVoices),
+	}
+	for _, setting := range sortedKeys(named) {
+		for _, persona := range named[setting] {
+			if !prompts.hasPersona(persona) {
+				r.Warnings = append(r.Warnings, fmt.Sprintf("%s names unknown persona %q", setting, persona))
+			}
+		}
+	}
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// handleValidateConfig serves POST /admin/validate-config: the body is a YAML config
+// file, or TOML with ?format=toml, checked as in checkCandidateConfig. Nothing is applied.
+func handleValidateConfig(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = configFormatYAML
+	}
+	if format != configFormatYAML && format != configFormatTOML {
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("format must be %q or %q", configFormatYAML, configFormatTOML))
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxCandidateConfigBytes))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("config must be at most %d bytes", maxCandidateConfigBytes))
+			return
+		}
+		writeJSONError(w, http.StatusBadRequest, "failed to read config: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, checkCandidateConfig(data, format))
+}
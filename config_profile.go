@@ -0,0 +1,96 @@
+// Environment-specific config profiles selected by APP_ENV, and the effective config log
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// profileEnvVar names the config file profile the server runs with.
+const profileEnvVar = "APP_ENV"
+
+// load decodes data in format over cfg and then the profile named by APP_ENV
+func (c *Config) load(data []byte, format, source string) error {
+	if err := c.decode(data, format, source); err != nil {
+		return err
+	}
+	return c.applyProfile(os.Getenv(profileEnvVar), format, source)
+}
+
+// applyProfile decodes the profile named by APP_ENV, a section of the config file's
+// profiles in format, over the rest of the file. Nothing is applied when APP_ENV is
+// unset or the file has no profiles; naming a profile the file lacks is an error.
+func (c *Config) applyProfile(name, format, source string) error {
+	if name == "" || len(c.Profiles) == 0 {
+		return nil
+	}
+	profile, ok := c.Profiles[name]
+	if !ok {
+		names := make([]string, 0, len(c.Profiles))
+		for candidate := range c.Profiles {
+			names = append(names, candidate)
+		}
+		sort.Strings(names)
+		return fmt.Errorf("invalid %s: %s %q has no profile, expected one of %s", source, profileEnvVar, name, strings.Join(names, ", "))
+	}
+	if _, nested := profile["profiles"]; nested {
+		return fmt.Errorf("invalid %s: profile %q must not define profiles", source, name)
+	}
+	var data bytes.Buffer
+	switch format {
+	case configFormatYAML:
+		if err := yaml.NewEncoder(&data).Encode(profile); err != nil {
+			return fmt.Errorf("invalid %s: profile %q: %w", source, name, err)
+		}
+	case configFormatTOML:
+		if err := toml.NewEncoder(&data).Encode(profile); err != nil {
+			return fmt.Errorf("invalid %s: profile %q: %w", source, name, err)
+		}
+	}
+	if err := c.decode(data.Bytes(), format, fmt.Sprintf("%s profile %q", source, name)); err != nil {
+		return err
+	}
+	c.Profile = name
+	return nil
+}
+
+// secretFields returns the settings that hold credentials
+func (c *Config) secretFields() []*string {
+	return []*string{
+		&c.OpenAI.APIKey,
+		&c.Auth.AdminToken,
+		&c.TRTC.SecretID,
+		&c.TRTC.SecretKey,
+		&c.TRTC.TTSSecretID,
+		&c.TRTC.TTSSecretKey,
+		&c.Push.SigningSecret,
+		&c.Auth.SigningSecret,
+	}
+}
+
+// describe renders the effective configuration as YAML for the startup log, with
+// credentials and API keys replaced by a placeholder. The profiles are left out;
+// the one in effect has been merged in.
+func (c *Config) describe() string {
+	redacted := *c
+	redacted.Profiles = nil
+	for _, field := range redacted.secretFields() {
+		if *field != "" {
+			*field = redactedPlaceholder
+		}
+	}
+	if len(redacted.Auth.APIKeys) > 0 {
+		redacted.Auth.APIKeys = []string{fmt.Sprintf("%s (%d keys)", redactedPlaceholder, len(redacted.Auth.APIKeys))}
+	}
+	out, err := yaml.Marshal(redacted)
+	if err != nil {
+		return fmt.Sprintf("unavailable: %v", err)
+	}
+	return string(out)
+}
@@ -0,0 +1,59 @@
+// Structured data parts for JSON replies
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+	"trpc.group/trpc-go/trpc-a2a-go/taskmanager"
+)
+
+// Content types of a reply, as recorded in content_type artifact metadata
+const (
+	contentTypeText = "text/plain"
+	contentTypeJSON = "application/json"
+)
+
+// contentType returns the content type of the turn's reply
+func (t *completionTurn) contentType() string {
+	if t.jsonOutput {
+		return contentTypeJSON
+	}
+	return contentTypeText
+}
+
+// newContentPart returns content as an artifact part: a DataPart holding the parsed
+// value when contentType is JSON and content parses, so clients can use the result
+// without parsing it again, and a TextPart otherwise
+func newContentPart(content, contentType string) protocol.Part {
+	if contentType == contentTypeJSON {
+		var data interface{}
+		if err := json.Unmarshal([]byte(strings.TrimSpace(content)), &data); err == nil {
+			return protocol.DataPart{Type: protocol.PartTypeData, Data: data}
+		}
+	}
+	return protocol.NewTextPart(content)
+}
+
+// addStructuredArtifact adds a validated JSON mode reply that was streamed as text
+// as one data part at index. It is not the last chunk, so the stream stays open
+// for the final chunk marker.
+func addStructuredArtifact(taskID string, handle taskmanager.TaskHandle, reply string, index int) {
+	artifact := protocol.Artifact{
+		Name:        stringPtr("Structured Response"),
+		Description: stringPtr("JSON reply parsed from the streamed text"),
+		Index:       index,
+		Parts:       []protocol.Part{newContentPart(reply, contentTypeJSON)},
+		Metadata: map[string]interface{}{
+			"timestamp":    time.Now().UnixNano(),
+			"total_length": len(reply),
+			"content_type": contentTypeJSON,
+		},
+	}
+	if err := handle.AddArtifact(artifact); err != nil {
+		log.Printf("Error adding structured response artifact for task %s: %v", taskID, err)
+	}
+}
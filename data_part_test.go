@@ -0,0 +1,29 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+)
+
+func TestNewContentPart(t *testing.T) {
+	tests := []struct {
+		name        string
+		content     string
+		contentType string
+		want        protocol.Part
+	}{
+		{"text", `{"a": 1}`, contentTypeText, protocol.NewTextPart(`{"a": 1}`)},
+		{"json object", " {\"a\": 1, \"b\": [true]}\n", contentTypeJSON, protocol.DataPart{
+			Type: protocol.PartTypeData,
+			Data: map[string]interface{}{"a": float64(1), "b": []interface{}{true}},
+		}},
+		{"invalid json", `{"a": `, contentTypeJSON, protocol.NewTextPart(`{"a": `)},
+	}
+	for _, test := range tests {
+		if got := newContentPart(test.content, test.contentType); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: newContentPart = %#v, want %#v", test.name, got, test.want)
+		}
+	}
+}
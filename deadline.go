@@ -0,0 +1,83 @@
+// Task deadlines requested by clients through message metadata, and the maximum task duration
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"time"
+)
+
+// Message metadata keys for a task deadline: deadline_ms is an absolute Unix time
+// in milliseconds, timeout_ms a duration in milliseconds from when the task starts.
+const (
+	deadlineMetadataKey = "deadline_ms"
+	timeoutMetadataKey  = "timeout_ms"
+)
+
+// errDeadlineExceeded fails tasks that run past their deadline.
+var errDeadlineExceeded = errors.New("the task did not finish before its deadline")
+
+// errTaskDurationExceeded fails tasks that run longer than MAX_TASK_DURATION.
+var errTaskDurationExceeded = errors.New("task exceeded maximum duration")
+
+// taskDeadline returns the deadline the client asked for in metadata, the earlier
+// of deadline_ms and timeout_ms if both are set, capped at now+maxTimeout when
+// maxTimeout is positive. ok is false when the client set no deadline.
+func taskDeadline(metadata map[string]interface{}, now time.Time, maxTimeout time.Duration) (deadline time.Time, ok bool) {
+	if ms, found := metadataMillis(metadata, deadlineMetadataKey); found {
+		deadline, ok = time.UnixMilli(ms), true
+	}
+	if ms, found := metadataMillis(metadata, timeoutMetadataKey); found {
+		if timeout := now.Add(time.Duration(ms) * time.Millisecond); !ok || timeout.Before(deadline) {
+			deadline, ok = timeout, true
+		}
+	}
+	if !ok {
+		return time.Time{}, false
+	}
+	if maxTimeout > 0 && deadline.After(now.Add(maxTimeout)) {
+		deadline = now.Add(maxTimeout)
+	}
+	return deadline, true
+}
+
+// metadataMillis returns a non-negative integer metadata value; JSON numbers decode as float64
+func metadataMillis(metadata map[string]interface{}, key string) (int64, bool) {
+	value, ok := metadata[key].(float64)
+	if !ok || value < 0 || value > float64(math.MaxInt64/int64(time.Millisecond)) {
+		return 0, false
+	}
+	return int64(value), true
+}
+
+// withTaskDeadline derives a context that ends at the client's deadline, if any
+func withTaskDeadline(ctx context.Context, metadata map[string]interface{}, maxTimeout time.Duration) (context.Context, context.CancelFunc) {
+	deadline, ok := taskDeadline(metadata, time.Now(), maxTimeout)
+	if !ok {
+		return ctx, func() {}
+	}
+	return context.WithDeadlineCause(ctx, deadline, errDeadlineExceeded)
+}
+
+// withMaxTaskDuration derives a context that ends once the task has run for
+// maxDuration, whatever phase it is in; 0 sets no limit
+func withMaxTaskDuration(ctx context.Context, maxDuration time.Duration) (context.Context, context.CancelFunc) {
+	if maxDuration <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeoutCause(ctx, maxDuration, errTaskDurationExceeded)
+}
+
+// deadlineError returns errDeadlineExceeded or errTaskDurationExceeded when err was
+// caused by ctx reaching the task deadline or the maximum task duration, and err
+// otherwise, so failures name the limit rather than a low-level context error
+func deadlineError(ctx context.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if cause := context.Cause(ctx); errors.Is(cause, errDeadlineExceeded) || errors.Is(cause, errTaskDurationExceeded) {
+		return cause
+	}
+	return err
+}
@@ -0,0 +1,111 @@
+// Canned persona replies while OpenAI is unreachable
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+	"trpc.group/trpc-go/trpc-a2a-go/taskmanager"
+)
+
+// degradedMetadataKey marks the status and artifact of a task answered with a canned reply.
+const degradedMetadataKey = "degraded"
+
+// defaultDegradedResponse is the canned reply of personas without their own.
+const defaultDegradedResponse = "Sorry, I can't answer right now because my service is temporarily unavailable. Please try again in a moment."
+
+// degradedResponder answers tasks with a canned per-persona reply when OpenAI cannot
+// be reached, so voice sessions hear something polite instead of an error.
+// A nil *degradedResponder lets such tasks fail.
+type degradedResponder struct {
+	fallback string
+	personas map[string]string
+}
+
+// newDegradedResponder returns nil unless DEGRADED_MODE is on
+func newDegradedResponder(enabled bool, fallback string, personas map[string]string) *degradedResponder {
+	if !enabled {
+		return nil
+	}
+	if fallback == "" {
+		fallback = defaultDegradedResponse
+	}
+	return &degradedResponder{fallback: fallback, personas: personas}
+}
+
+// response returns the persona's canned reply
+func (d *degradedResponder) response(persona string) string {
+	if text := d.personas[persona]; text != "" {
+		return text
+	}
+	return d.fallback
+}
+
+// applies reports whether a completion that failed with err, before any of the
+// reply was sent, is answered with the canned reply instead
+func (d *degradedResponder) applies(ctx context.Context, turn *completionTurn, err error) bool {
+	return d != nil && !turn.emitted && ctx.Err() == nil && openAIUnreachable(err)
+}
+
+// openAIUnreachable reports whether err means OpenAI could not serve the request at
+// all: no base URL answered, or the one that did failed with a 5xx status. Client
+// errors such as a bad key or model are configuration problems and are not masked.
+func openAIUnreachable(err error) bool {
+	var netErr net.Error
+	var apiErr *openai.APIError
+	var reqErr *openai.RequestError
+	switch {
+	case errors.Is(err, errNoHealthyEndpoint), errors.As(err, &netErr):
+		return true
+	case errors.As(err, &apiErr):
+		return apiErr.HTTPStatusCode >= http.StatusInternalServerError
+	case errors.As(err, &reqErr):
+		return reqErr.HTTPStatusCode >= http.StatusInternalServerError
+	}
+	return false
+}
+
+// respondDegraded completes the task with the persona's canned reply after the
+// completion failed with cause, and pushes it to the task's TRTC conversation and rooms
+func (p *streamingTaskProcessor) respondDegraded(taskID string, turn *completionTurn, handle taskmanager.TaskHandle, cause error) error {
+	text := p.degraded.response(turn.intent)
+	log.Printf("Task %s answered with the degraded-mode reply, OpenAI is unreachable: %v", taskID, cause)
+
+	artifact := protocol.Artifact{
+		Name:        stringPtr("Processed Text"),
+		Description: stringPtr("Canned reply sent while OpenAI is unreachable"),
+		Index:       0,
+		Parts:       []protocol.Part{protocol.NewTextPart(text)},
+		LastChunk:   boolPtr(true),
+		Metadata: map[string]interface{}{
+			"timestamp":         time.Now().UnixNano(),
+			"total_length":      len(text),
+			"is_streaming":      false,
+			degradedMetadataKey: true,
+		},
+	}
+	if err := handle.AddArtifact(artifact); err != nil {
+		log.Printf("Error adding degraded-mode artifact for task %s: %v", taskID, err)
+	}
+
+	conversations := trtcConversations(taskID, turn.rooms)
+	logPushFailures(taskID, "degraded-mode reply", len(conversations),
+		pushToConversations(conversations, text, p.trtcFanout))
+
+	completeMessage := protocol.NewMessage(
+		protocol.MessageRoleAgent,
+		[]protocol.Part{protocol.NewTextPart(text)},
+	)
+	completeMessage.Metadata = map[string]interface{}{degradedMetadataKey: true}
+	if err := handle.UpdateStatus(protocol.TaskStateCompleted, &completeMessage); err != nil {
+		return fmt.Errorf("failed to complete task %s in degraded mode: %w", taskID, err)
+	}
+	return nil
+}
@@ -0,0 +1,23 @@
+// Retry of completions that produce no output
+package main
+
+import (
+	"errors"
+	"log"
+)
+
+// errEmptyCompletion fails a task whose completion stayed empty after every retry.
+var errEmptyCompletion = errors.New("the model returned an empty response, possibly because it declined to answer; please rephrase and try again")
+
+// withEmptyRetry runs call, repeating it up to EMPTY_OUTPUT_RETRIES times while it
+// reports errEmptyCompletion. An empty stream has emitted nothing to the client yet,
+// so the retry is invisible apart from the delay.
+func (p *streamingTaskProcessor) withEmptyRetry(taskID string, call func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := call()
+		if !errors.Is(err, errEmptyCompletion) || attempt >= p.emptyOutputRetries {
+			return err
+		}
+		log.Printf("Task %s: completion was empty, retrying (%d/%d)", taskID, attempt+1, p.emptyOutputRetries)
+	}
+}
@@ -0,0 +1,148 @@
+// Startup probe of the configured OpenAI-compatible endpoint
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// endpointProbeTimeout bounds the startup probe request.
+const endpointProbeTimeout = 10 * time.Second
+
+// endpointProbe checks once, at startup, that each configured base URL answers like
+// an OpenAI-compatible API. Results feed the failover health that GET /readyz reports.
+type endpointProbe struct {
+	endpoints *baseURLFailover
+	apiKey    string
+	// headers scope the probe like the client's requests.
+	headers http.Header
+
+	mu   sync.RWMutex
+	done bool
+}
+
+// newEndpointProbe creates an unstarted probe of the endpoints' base URLs
+func newEndpointProbe(endpoints *baseURLFailover, apiKey string, headers http.Header) *endpointProbe {
+	return &endpointProbe{endpoints: endpoints, apiKey: apiKey, headers: headers}
+}
+
+// start runs the probe in the background. A failure is logged as a warning with a
+// hint at the likely misconfiguration; it never stops the server.
+func (p *endpointProbe) start() {
+	go func() {
+		for i, baseURL := range p.endpoints.urls {
+			ctx, cancel := context.WithTimeout(context.Background(), endpointProbeTimeout)
+			err := p.check(ctx, baseURL)
+			cancel()
+			p.endpoints.setHealth(i, err)
+			if err != nil {
+				log.Printf("WARNING: OpenAI base URL %s does not look like an OpenAI-compatible API: %v", baseURL, err)
+				continue
+			}
+			log.Printf("OpenAI endpoint %s is reachable", baseURL)
+		}
+
+		p.mu.Lock()
+		p.done = true
+		p.mu.Unlock()
+	}()
+}
+
+// finished reports whether the probe has run; a nil probe (probing disabled) counts as finished
+func (p *endpointProbe) finished() bool {
+	if p == nil {
+		return true
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.done
+}
+
+// check lists the models at baseURL and turns the common misconfigurations into actionable errors
+func (p *endpointProbe) check(ctx context.Context, baseURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, baseURL+"/models", nil)
+	if err != nil {
+		return fmt.Errorf("invalid base URL: %w", err)
+	}
+	for name, values := range p.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("endpoint unreachable, check the host and port: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return fmt.Errorf("authentication failed (%s), check OPENAI_API_KEY", resp.Status)
+	case resp.StatusCode == http.StatusNotFound:
+		return fmt.Errorf("%s/models returned 404, check the path: OpenAI-compatible APIs usually live under a version prefix such as /v1", baseURL)
+	case strings.Contains(resp.Header.Get("Content-Type"), "text/html"):
+		return fmt.Errorf("endpoint returned an HTML page (%s), the base URL probably points at a website rather than an API", resp.Status)
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("listing models returned %s", resp.Status)
+	}
+	var models struct {
+		Data []json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &models); err != nil {
+		return fmt.Errorf("listing models returned a response that is not OpenAI-style JSON: %w", err)
+	}
+	return nil
+}
+
+// endpointStatus is one base URL's entry in the GET /readyz response.
+type endpointStatus struct {
+	URL     string `json:"url"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// readinessHandler serves GET /readyz: 200 once the startup probe has run and at
+// least one base URL is healthy, 503 otherwise. Request failures seen by the
+// failover transport mark a base URL unhealthy until it answers again. In degraded
+// mode the server still answers without a healthy base URL, so it reports 200
+// with status "degraded" instead.
+func readinessHandler(probe *endpointProbe, endpoints *baseURLFailover, degradedMode bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		health := endpoints.snapshot()
+		statuses := make([]endpointStatus, len(health))
+		for i, h := range health {
+			statuses[i] = endpointStatus{URL: endpoints.urls[i], Healthy: h.healthy}
+			if h.err != nil {
+				statuses[i].Error = h.err.Error()
+			}
+		}
+		body := map[string]interface{}{"endpoints": statuses}
+		switch {
+		case !probe.finished():
+			body["status"] = "starting"
+			writeJSON(w, http.StatusServiceUnavailable, body)
+		case !endpoints.anyHealthy() && degradedMode:
+			body["status"] = "degraded"
+			body["degraded"] = true
+			body["error"] = errNoHealthyEndpoint.Error()
+			writeJSON(w, http.StatusOK, body)
+		case !endpoints.anyHealthy():
+			body["status"] = "not_ready"
+			body["error"] = errNoHealthyEndpoint.Error()
+			writeJSON(w, http.StatusServiceUnavailable, body)
+		default:
+			body["status"] = "ready"
+			writeJSON(w, http.StatusOK, body)
+		}
+	}
+}
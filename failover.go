@@ -0,0 +1,170 @@
+// Failover between several OpenAI-compatible base URLs
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// endpointHealth is the last known state of one base URL.
+type endpointHealth struct {
+	healthy bool
+	err     error
+}
+
+// baseURLFailover is an http.RoundTripper for the OpenAI client that retries a
+// request against the next base URL when the current one fails at the connection
+// level (DNS, refused, reset, TLS). HTTP error responses are returned as they are,
+// since another gateway would most likely answer the same way. The client is
+// configured with the first URL; requests are rewritten to whichever URL is tried.
+type baseURLFailover struct {
+	urls []string
+	next http.RoundTripper
+
+	mu sync.Mutex
+	// preferred is the URL tried first: the last one that answered.
+	preferred int
+	health    []endpointHealth
+}
+
+// newBaseURLFailover creates a failover transport over urls, all assumed healthy until proven otherwise
+func newBaseURLFailover(urls []string, next http.RoundTripper) *baseURLFailover {
+	f := &baseURLFailover{next: next, health: make([]endpointHealth, len(urls))}
+	for i, u := range urls {
+		f.urls = append(f.urls, strings.TrimRight(u, "/"))
+		f.health[i].healthy = true
+	}
+	return f
+}
+
+// RoundTrip implements http.RoundTripper
+func (f *baseURLFailover) RoundTrip(req *http.Request) (*http.Response, error) {
+	original := req.URL.String()
+	if !strings.HasPrefix(original, f.urls[0]) {
+		return f.next.RoundTrip(req)
+	}
+	suffix := strings.TrimPrefix(original, f.urls[0])
+
+	f.mu.Lock()
+	start := f.preferred
+	f.mu.Unlock()
+
+	var lastErr error
+	for attempt := 0; attempt < len(f.urls); attempt++ {
+		i := (start + attempt) % len(f.urls)
+		if attempt > 0 && req.Body != nil && req.GetBody == nil {
+			// The body has been consumed and cannot be replayed.
+			break
+		}
+		attemptReq, err := f.rewrite(req, f.urls[i]+suffix, attempt > 0)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := f.next.RoundTrip(attemptReq)
+		if err == nil {
+			f.setHealth(i, nil)
+			f.mu.Lock()
+			f.preferred = i
+			f.mu.Unlock()
+			if served, ok := req.Context().Value(servedByContextKey{}).(*servedBy); ok {
+				served.set(f.urls[i])
+				if strings.HasSuffix(req.URL.Path, "/chat/completions") {
+					served.setRequestID(openAIRequestID(resp.Header))
+				}
+			}
+			return resp, nil
+		}
+		if req.Context().Err() != nil {
+			// The caller gave up; that says nothing about the endpoint.
+			return nil, err
+		}
+		lastErr = err
+		f.setHealth(i, err)
+		if len(f.urls) > 1 {
+			log.Printf("OpenAI base URL %s failed, failing over: %v", f.urls[i], err)
+		}
+	}
+	return nil, lastErr
+}
+
+// rewrite returns a copy of req addressed to target, with a fresh body for retries
+func (f *baseURLFailover) rewrite(req *http.Request, target string, retry bool) (*http.Request, error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OpenAI base URL: %w", err)
+	}
+	attemptReq := req.Clone(req.Context())
+	attemptReq.URL = u
+	attemptReq.Host = u.Host
+	if retry && req.GetBody != nil {
+		if attemptReq.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return attemptReq, nil
+}
+
+// setHealth records the outcome of a request or probe against urls[i]
+func (f *baseURLFailover) setHealth(i int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.health[i] = endpointHealth{healthy: err == nil, err: err}
+}
+
+// snapshot returns the current health of every base URL, in configuration order
+func (f *baseURLFailover) snapshot() []endpointHealth {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]endpointHealth(nil), f.health...)
+}
+
+// anyHealthy reports whether at least one base URL is usable
+func (f *baseURLFailover) anyHealthy() bool {
+	for _, health := range f.snapshot() {
+		if health.healthy {
+			return true
+		}
+	}
+	return false
+}
+
+// servedByContextKey carries a *servedBy through the OpenAI client to the failover transport.
+type servedByContextKey struct{}
+
+// servedBy records which base URL answered the requests made with a context, and
+// the provider's request ID of the latest chat completion.
+type servedBy struct {
+	mu        sync.Mutex
+	url       string
+	requestID string
+}
+
+// withServedBy returns a context whose OpenAI requests record the base URL that served them
+func withServedBy(ctx context.Context) (context.Context, *servedBy) {
+	served := &servedBy{}
+	return context.WithValue(ctx, servedByContextKey{}, served), served
+}
+
+// set records u as the serving base URL
+func (s *servedBy) set(u string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.url = u
+}
+
+// get returns the serving base URL, or "" if no request has been answered
+func (s *servedBy) get() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.url
+}
+
+// errNoHealthyEndpoint reports that every configured base URL failed.
+var errNoHealthyEndpoint = errors.New("no OpenAI base URL is healthy")
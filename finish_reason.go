@@ -0,0 +1,53 @@
+// Surfacing of completions that OpenAI cut short
+package main
+
+import (
+	"errors"
+
+	"github.com/sashabaranov/go-openai"
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+)
+
+// finishReasonMetadataKey records the completion's finish_reason on the final
+// artifact and status.
+const finishReasonMetadataKey = "finish_reason"
+
+// errContentFiltered fails a task whose completion was stopped by OpenAI's content
+// filter before producing any text. It is not retried: the same prompt is filtered again.
+var errContentFiltered = errors.New("the response was blocked by the content filter; please rephrase and try again")
+
+// finishNotice explains a finish reason that cut the reply short, for the final
+// status text; "" for replies that ended normally
+func finishNotice(reason openai.FinishReason) string {
+	switch reason {
+	case openai.FinishReasonContentFilter:
+		return "the response was cut short by the content filter"
+	case openai.FinishReasonLength:
+		return "the response reached the token limit"
+	}
+	return ""
+}
+
+// finishMetadata records reason in metadata, if the completion reported one
+func finishMetadata(metadata map[string]interface{}, reason openai.FinishReason) {
+	if reason != "" && reason != openai.FinishReasonNull {
+		metadata[finishReasonMetadataKey] = string(reason)
+	}
+}
+
+// finishMessage returns the final status message of a reply with the given text,
+// noting and recording a finish reason that cut it short
+func finishMessage(text string, reason openai.FinishReason) protocol.Message {
+	notice := finishNotice(reason)
+	if notice != "" {
+		text += " Note: " + notice + "."
+	}
+	message := protocol.NewMessage(
+		protocol.MessageRoleAgent,
+		[]protocol.Part{protocol.NewTextPart(text)},
+	)
+	if notice != "" {
+		message.Metadata = map[string]interface{}{finishReasonMetadataKey: string(reason)}
+	}
+	return message
+}
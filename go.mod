@@ -5,10 +5,14 @@ go 1.23.0
 toolchain go1.24.2
 
 require (
+	github.com/BurntSushi/toml v1.6.0
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/gorilla/websocket v1.5.3
 	github.com/joho/godotenv v1.5.1
 	github.com/sashabaranov/go-openai v1.19.2
 	github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common v1.0.1159
 	github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/trtc v1.0.1155
+	gopkg.in/yaml.v3 v3.0.1
 	trpc.group/trpc-go/trpc-a2a-go v0.0.1
 )
 
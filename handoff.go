@@ -0,0 +1,223 @@
+// Mid-reply handoff from one persona to another
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+	"trpc.group/trpc-go/trpc-a2a-go/taskmanager"
+)
+
+// A persona hands the reply over by writing handoffMarkerPrefix, the target persona
+// ID and handoffMarkerSuffix, e.g. "[[handoff:tech]]". The marker never reaches the client.
+const (
+	handoffMarkerPrefix = "[[handoff:"
+	handoffMarkerSuffix = "]]"
+)
+
+// handoffMarkerMaxLength bounds how much text is held back while a possible marker
+// is incomplete; anything longer is released as ordinary text.
+const handoffMarkerMaxLength = 64
+
+// handoffInstruction tells the persona how to hand over; %s lists the other personas.
+const handoffInstruction = "If another assistant is better suited to answer, for example because the user needs to be escalated, " +
+	"write " + handoffMarkerPrefix + "<id>" + handoffMarkerSuffix + " with that assistant's id and stop writing. " +
+	"Only do this when it clearly helps the user. The assistants are:\n%s"
+
+// handoffContinuation tells the persona taking over what has been said; %s are the
+// previous persona and its words.
+const handoffContinuation = "You are taking over this reply from the %s assistant, which has already told the user: %q\n" +
+	"Continue the reply in your own role without repeating what was said."
+
+// handoffScanner finds the first valid handoff marker in a streamed reply. Text that
+// may be the start of a marker is held back until the marker is complete or ruled
+// out, so a marker split across deltas is still found and never shown. Markers whose
+// target accept rejects are dropped from the text and scanning goes on.
+// A nil *handoffScanner passes text through.
+type handoffScanner struct {
+	accept func(target string) bool
+	held   string
+	found  bool
+}
+
+// write returns the text of delta that can be released and, once a marker is
+// complete, its target. Text after an accepted marker is discarded.
+func (s *handoffScanner) write(delta string) (string, string) {
+	if s == nil || s.found {
+		return delta, ""
+	}
+	text := s.held + delta
+	s.held = ""
+	start := strings.Index(text, handoffMarkerPrefix)
+	if start < 0 {
+		keep := partialPrefixLength(text, handoffMarkerPrefix)
+		s.held = text[len(text)-keep:]
+		return text[:len(text)-keep], ""
+	}
+	after := text[start+len(handoffMarkerPrefix):]
+	if target, rest, closed := strings.Cut(after, handoffMarkerSuffix); closed {
+		target = strings.TrimSpace(target)
+		if s.accept(target) {
+			s.found = true
+			return text[:start], target
+		}
+		released, target := s.write(rest)
+		return text[:start] + released, target
+	}
+	if len(after) > handoffMarkerMaxLength {
+		released, target := s.write(after)
+		return text[:start+len(handoffMarkerPrefix)] + released, target
+	}
+	s.held = text[start:]
+	return text[:start], ""
+}
+
+// flush returns the text still held back, once the reply has ended
+func (s *handoffScanner) flush() string {
+	if s == nil {
+		return ""
+	}
+	held := s.held
+	s.held = ""
+	return held
+}
+
+// partialPrefixLength returns the length of the longest end of text that is a
+// proper beginning of prefix
+func partialPrefixLength(text, prefix string) int {
+	for n := min(len(prefix)-1, len(text)); n > 0; n-- {
+		if strings.HasSuffix(text, prefix[:n]) {
+			return n
+		}
+	}
+	return 0
+}
+
+// handoffTargets returns the personas the turn's persona may hand over to: every
+// other persona except the guard
+func handoffTargets(turn *completionTurn) []string {
+	var targets []string
+	for _, id := range turn.prompts.personaIDs {
+		if id != turn.intent && !turn.prompts.isGuard(id) {
+			targets = append(targets, id)
+		}
+	}
+	return targets
+}
+
+// handoffPrompt returns the system prompt addition for the turn: how to hand over
+// while a handoff is allowed, what was said once one has happened, and "" otherwise
+func handoffPrompt(turn *completionTurn) string {
+	if turn.handoffFrom != "" {
+		return fmt.Sprintf(handoffContinuation, turn.handoffFrom, turn.handoffSaid)
+	}
+	if !turn.handoff {
+		return ""
+	}
+	targets := handoffTargets(turn)
+	if len(targets) == 0 {
+		return ""
+	}
+	options := make([]string, len(targets))
+	for i, id := range targets {
+		options[i] = fmt.Sprintf("- %s: %s", id, turn.prompts.description(id))
+	}
+	return fmt.Sprintf(handoffInstruction, strings.Join(options, "\n"))
+}
+
+// handoffScanner returns the scanner for the turn's reply, or nil if it may not be handed over
+func (p *streamingTaskProcessor) handoffScanner(taskID string, turn *completionTurn) *handoffScanner {
+	if !turn.handoff || turn.handoffFrom != "" {
+		return nil
+	}
+	return &handoffScanner{accept: func(target string) bool {
+		switch {
+		case target == turn.intent:
+			log.Printf("Task %s: ignoring handoff of %s to itself", taskID, target)
+		case !turn.prompts.hasPersona(target):
+			log.Printf("Task %s: ignoring handoff of %s to unknown persona %q", taskID, turn.intent, target)
+		case turn.prompts.isGuard(target):
+			log.Printf("Task %s: ignoring handoff of %s to the guard persona", taskID, turn.intent)
+		default:
+			return true
+		}
+		return false
+	}}
+}
+
+// handOff switches the turn to target after its persona said said: the session,
+// the task registry, the TRTC voice and the labels of later updates follow the new
+// persona. The handoff point is reported in a working status; offset is where the
+// new persona's text starts in the reply and chunk the index of its first artifact.
+func (p *streamingTaskProcessor) handOff(
+	taskID string,
+	turn *completionTurn,
+	target, said string,
+	offset, chunk int,
+	handle taskmanager.TaskHandle,
+) {
+	from := turn.intent
+	log.Printf("Task %s handed off from %s to %s after %d bytes", taskID, from, target, offset)
+	turn.intent = target
+	turn.handoffFrom = from
+	turn.handoffSaid = said
+	p.sessions.setPersona(turn.session, target)
+	p.tasks.setPersona(taskID, target)
+	go updateTRTCVoice(taskID, target)
+
+	marker := map[string]interface{}{
+		"handoff":        true,
+		"handoff_from":   from,
+		"handoff_to":     target,
+		"handoff_offset": offset,
+		"handoff_chunk":  chunk,
+	}
+	if labelled, ok := handle.(*personaHandle); ok {
+		labelled.handOff(target, marker)
+	}
+	handoffMessage := protocol.NewMessage(
+		protocol.MessageRoleAgent,
+		[]protocol.Part{protocol.NewTextPart(fmt.Sprintf("Handing off from %s to %s...", from, target))},
+	)
+	handoffMessage.Metadata = marker
+	if err := handle.UpdateStatus(protocol.TaskStateWorking, &handoffMessage); err != nil {
+		log.Printf("Error updating handoff status for task %s: %v", taskID, err)
+	}
+}
+
+// handOffReply hands a non-streamed reply over if it contains an accepted marker:
+// the new persona's reply is completed and appended to what was said before the
+// marker. Replies without one are returned as they are, minus dropped markers.
+func (p *streamingTaskProcessor) handOffReply(
+	ctx context.Context,
+	taskID string,
+	turn *completionTurn,
+	handle taskmanager.TaskHandle,
+	reply string,
+) (string, error) {
+	scanner := p.handoffScanner(taskID, turn)
+	if scanner == nil {
+		return reply, nil
+	}
+	said, target := scanner.write(reply)
+	if target == "" {
+		return said + scanner.flush(), nil
+	}
+	said = strings.TrimSpace(said)
+	offset := 0
+	if said != "" {
+		offset = len(said) + 1
+	}
+	p.handOff(taskID, turn, target, said, offset, 0, handle)
+	continued, err := p.processWithOpenAINonStreaming(ctx, turn)
+	if err != nil {
+		return "", fmt.Errorf("handoff to %s failed: %w", target, err)
+	}
+	if said == "" {
+		return continued, nil
+	}
+	return said + " " + continued, nil
+}
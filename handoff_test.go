@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+)
+
+// handoffTurn returns a turn of the default persona that may hand over, with
+// "Guard" as the guard persona.
+func handoffTurn(t *testing.T, text string) *completionTurn {
+	t.Helper()
+	store, err := newPromptStore("", "Guard", "", defaultLocale, nil)
+	if err != nil {
+		t.Fatalf("newPromptStore: %v", err)
+	}
+	prompts := store.snapshot()
+	return &completionTurn{text: text, prompts: prompts, intent: prompts.defaultPersona(), handoff: true}
+}
+
+// continuesHandoff reports whether req is the completion of the persona taking over.
+func continuesHandoff(req openai.ChatCompletionRequest) bool {
+	return strings.Contains(req.Messages[0].Content, "You are taking over this reply")
+}
+
+func TestPartialPrefixLength(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"Hello", 0},
+		{"Hello [", 1},
+		{"Hello [[hand", 6},
+		{"[[handoff", 9},
+		// The whole prefix is not a partial one.
+		{"[[handoff:", 0},
+		{"", 0},
+	}
+	for _, test := range tests {
+		if got := partialPrefixLength(test.text, handoffMarkerPrefix); got != test.want {
+			t.Errorf("partialPrefixLength(%q) = %d, want %d", test.text, got, test.want)
+		}
+	}
+}
+
+func TestHandoffScannerMarkerSplitAcrossDeltas(t *testing.T) {
+	s := &handoffScanner{accept: func(string) bool { return true }}
+	var released strings.Builder
+	target := ""
+	for _, delta := range []string{"Let me get my colleague. [[hand", "off:Xiao", "Shuai]] left over"} {
+		text, found := s.write(delta)
+		released.WriteString(text)
+		if found != "" {
+			target = found
+		}
+	}
+	if target != "XiaoShuai" {
+		t.Errorf("target = %q, want XiaoShuai", target)
+	}
+	if got := released.String() + s.flush(); got != "Let me get my colleague. " {
+		t.Errorf("released %q, want the text before the marker", got)
+	}
+}
+
+func TestHandoffScannerFlushesPartialPrefix(t *testing.T) {
+	s := &handoffScanner{accept: func(string) bool { return true }}
+	text, _ := s.write("Use [[")
+	if text != "Use " {
+		t.Errorf("write released %q, want the possible marker held back", text)
+	}
+	more, _ := s.write("links]] or [[hand")
+	if more != "[[links]] or " {
+		t.Errorf("write released %q once the prefix was ruled out", more)
+	}
+	if held := s.flush(); held != "[[hand" {
+		t.Errorf("flush = %q, want the held back text", held)
+	}
+	if held := s.flush(); held != "" {
+		t.Errorf("second flush = %q, want nothing", held)
+	}
+}
+
+func TestHandoffScannerDropsRejectedTargets(t *testing.T) {
+	p := testProcessor(t, nil)
+	turn := handoffTurn(t, "hi")
+	s := p.handoffScanner("task-1", turn)
+	tests := []struct {
+		name, marker string
+	}{
+		{"unknown persona", "[[handoff:Nobody]]"},
+		{"itself", "[[handoff:" + turn.intent + "]]"},
+		{"guard persona", "[[handoff:Guard]]"},
+	}
+	for _, test := range tests {
+		text, target := s.write("a " + test.marker + "b")
+		text += s.flush()
+		if target != "" || text != "a b" {
+			t.Errorf("%s: write = %q, %q, want the marker dropped", test.name, text, target)
+		}
+	}
+}
+
+func TestStreamedHandoffLabelsSwitchAndKeepsArtifactIndices(t *testing.T) {
+	client := streamServer(t, func(r *http.Request, send func(openai.ChatCompletionStreamResponse)) {
+		var req openai.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode stream request: %v", err)
+		}
+		if continuesHandoff(req) {
+			send(textDelta("XiaoShuai "))
+			send(textDelta("here."))
+			return
+		}
+		send(textDelta("One moment. [[hand"))
+		send(textDelta("off:XiaoShuai]] never shown"))
+	})
+	p := testProcessor(t, client)
+	turn := handoffTurn(t, "my router is broken")
+	recorded := &fakeHandle{}
+	handle := withPersona(recorded, turn.intent, nil)
+
+	if err := p.processWithOpenAIStreaming(context.Background(), "task-1", turn, handle); err != nil {
+		t.Fatalf("processWithOpenAIStreaming: %v", err)
+	}
+	if turn.intent != "XiaoShuai" || turn.handoffFrom != "XiaoMei" {
+		t.Errorf("turn persona = %s from %s, want XiaoShuai from XiaoMei", turn.intent, turn.handoffFrom)
+	}
+
+	var text strings.Builder
+	switchAt := -1
+	next := 0
+	for i, artifact := range recorded.artifacts {
+		for _, part := range artifact.Parts {
+			text.WriteString(part.(protocol.TextPart).Text)
+		}
+		// Every content chunk takes the next index; the final marker repeats the last one.
+		if artifact.Index != next && artifact.Index != next-1 {
+			t.Errorf("artifact %d has index %d, want %d", i, artifact.Index, next)
+		}
+		if artifact.Index == next {
+			next++
+		}
+		if artifact.Metadata["handoff"] != true {
+			continue
+		}
+		if switchAt >= 0 {
+			t.Errorf("artifact %d carries a second handoff marker", i)
+		}
+		switchAt = i
+		if got := artifact.Metadata["handoff_chunk"]; got != artifact.Index {
+			t.Errorf("handoff_chunk = %v, want the artifact's index %d", got, artifact.Index)
+		}
+		if artifact.Metadata["handoff_from"] != "XiaoMei" || artifact.Metadata["handoff_to"] != "XiaoShuai" ||
+			artifact.Metadata[personaMetadataKey] != "XiaoShuai" {
+			t.Errorf("handoff artifact metadata = %v", artifact.Metadata)
+		}
+		if got := artifact.Parts[0].(protocol.TextPart).Text; got != "XiaoShuai " {
+			t.Errorf("handoff artifact text = %q, want the new persona's first chunk", got)
+		}
+	}
+	if switchAt <= 0 {
+		t.Fatalf("no artifact after the first carries the handoff marker: %+v", recorded.artifacts)
+	}
+	if got := text.String(); got != "One moment. XiaoShuai here." {
+		t.Errorf("streamed text = %q", got)
+	}
+}
+
+func TestHandOffReplyCompletesWithNewPersona(t *testing.T) {
+	client := completionServer(t, func(req openai.ChatCompletionRequest) string {
+		if continuesHandoff(req) {
+			return "XiaoShuai here."
+		}
+		return "One moment. [[handoff:XiaoShuai]] never shown"
+	})
+	p := testProcessor(t, client)
+	turn := handoffTurn(t, "my router is broken")
+	handle := &fakeHandle{}
+
+	reply, err := p.processWithOpenAINonStreaming(context.Background(), turn)
+	if err != nil {
+		t.Fatalf("processWithOpenAINonStreaming: %v", err)
+	}
+	reply, err = p.handOffReply(context.Background(), "task-1", turn, handle, reply)
+	if err != nil {
+		t.Fatalf("handOffReply: %v", err)
+	}
+	if reply != "One moment. XiaoShuai here." {
+		t.Errorf("reply = %q", reply)
+	}
+	if turn.intent != "XiaoShuai" || turn.handoffFrom != "XiaoMei" || turn.handoffSaid != "One moment." {
+		t.Errorf("turn = %s from %s after %q", turn.intent, turn.handoffFrom, turn.handoffSaid)
+	}
+	if len(handle.states) != 1 || handle.states[0] != protocol.TaskStateWorking {
+		t.Errorf("states = %v, want one working status for the handoff", handle.states)
+	}
+}
@@ -0,0 +1,129 @@
+// Conversation history and its summarization when the context window overflows
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// contextLengthErrorCode is the OpenAI error code for a request larger than the model's context window.
+const contextLengthErrorCode = "context_length_exceeded"
+
+// historySummaryPrompt instructs the summary model when old exchanges are condensed.
+const historySummaryPrompt = `Summarize the conversation below into a compact note for an assistant that will continue it. Keep facts about the user, their requests and preferences, names, decisions and open questions. Write at most a few sentences and no preamble.`
+
+// historySummaryPrefix introduces the summary of earlier exchanges in the system messages.
+const historySummaryPrefix = "Summary of the earlier conversation: "
+
+// isContextLengthError reports whether err means the request exceeded the model's context window
+func isContextLengthError(err error) bool {
+	var apiErr *openai.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if code, ok := apiErr.Code.(string); ok && code == contextLengthErrorCode {
+		return true
+	}
+	// Some compatible backends only say so in the message.
+	return strings.Contains(strings.ToLower(apiErr.Message), "maximum context length")
+}
+
+// historyMessages returns the turn's stored history as chat messages, summary first
+func historyMessages(turn *completionTurn) []openai.ChatCompletionMessage {
+	var messages []openai.ChatCompletionMessage
+	if turn.summary != "" {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: historySummaryPrefix + turn.summary,
+		})
+	}
+	for _, exchange := range turn.history {
+		messages = append(messages,
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: exchange.user},
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: exchange.assistant},
+		)
+	}
+	return messages
+}
+
+// recordHistory stores the turn's exchange once a reply was produced
+func (p *streamingTaskProcessor) recordHistory(sessionID string, turn *completionTurn) {
+	if p.historyTurns <= 0 || turn.reply == "" {
+		return
+	}
+	p.sessions.appendHistory(sessionID, historyTurn{
+		user:      turn.text,
+		assistant: turn.reply,
+		persona:   turn.intent,
+		at:        time.Now(),
+	}, p.historyTurns)
+}
+
+// withHistoryCompaction runs call and, if it fails because the history no longer
+// fits the context window, summarizes the oldest half of the history and retries once.
+// Without AUTO_SUMMARIZE_HISTORY or history, the error is returned unchanged.
+func (p *streamingTaskProcessor) withHistoryCompaction(
+	ctx context.Context,
+	sessionID string,
+	turn *completionTurn,
+	call func() error,
+) error {
+	err := call()
+	if err == nil || !p.autoSummarize || len(turn.history) == 0 || !isContextLengthError(err) {
+		return err
+	}
+	log.Printf("Session %s exceeded the context window with %d history exchanges, summarizing the oldest",
+		sessionID, len(turn.history))
+	if summaryErr := p.summarizeOldestHistory(ctx, sessionID, turn); summaryErr != nil {
+		log.Printf("Session %s history summarization failed: %v", sessionID, summaryErr)
+		return err
+	}
+	return call()
+}
+
+// summarizeOldestHistory condenses the oldest half of the turn's history, together
+// with any earlier summary, into a new summary with the summary model. The stored
+// history is updated so later turns stay within budget.
+func (p *streamingTaskProcessor) summarizeOldestHistory(ctx context.Context, sessionID string, turn *completionTurn) error {
+	count := (len(turn.history) + 1) / 2
+	oldest := turn.history[:count]
+
+	var transcript strings.Builder
+	if turn.summary != "" {
+		fmt.Fprintf(&transcript, "Earlier summary: %s\n\n", turn.summary)
+	}
+	for _, exchange := range oldest {
+		fmt.Fprintf(&transcript, "User: %s\n%s: %s\n", exchange.user, exchange.persona, exchange.assistant)
+	}
+
+	start := time.Now()
+	resp, err := p.openaiClient.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: p.summaryModel,
+		Messages: []openai.ChatCompletionMessage{
+			{Role: openai.ChatMessageRoleSystem, Content: historySummaryPrompt},
+			{Role: openai.ChatMessageRoleUser, Content: transcript.String()},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("summary request failed: %w", err)
+	}
+	p.usage.addUsage(ctx, resp.Usage)
+	if len(resp.Choices) == 0 || strings.TrimSpace(resp.Choices[0].Message.Content) == "" {
+		return errors.New("summary request returned no text")
+	}
+	summary := strings.TrimSpace(resp.Choices[0].Message.Content)
+
+	if !p.sessions.replaceWithSummary(sessionID, oldest[count-1].at, summary) {
+		log.Printf("Session %s history changed during summarization, only this turn uses the summary", sessionID)
+	}
+	turn.summary = summary
+	turn.history = append([]historyTurn(nil), turn.history[count:]...)
+	log.Printf("Session %s summarized %d history exchanges with %s in %v", sessionID, count, p.summaryModel, time.Since(start))
+	return nil
+}
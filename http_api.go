@@ -0,0 +1,270 @@
+// HTTP endpoints served alongside the A2A protocol handler
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	sdkerrors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+)
+
+// HTTP server timeouts, matching the A2A server's defaults
+const (
+	serverReadTimeout  = 10 * time.Second
+	serverWriteTimeout = 10 * time.Second
+	serverIdleTimeout  = 60 * time.Second
+)
+
+// maxRequestBodyBytes caps the size of JSON bodies accepted by the auxiliary endpoints.
+const maxRequestBodyBytes = 1 << 20
+
+// classifyRequest is the body of POST /classify.
+type classifyRequest struct {
+	Text string `json:"text"`
+	// Previous optionally simulates a session that is already talking to this persona.
+	Previous string `json:"previous,omitempty"`
+	// Locale picks the prompt locale; it is detected from the text when empty.
+	Locale string `json:"locale,omitempty"`
+}
+
+// classifyResponse is the body returned by POST /classify.
+type classifyResponse struct {
+	Persona    string   `json:"persona"`
+	Matched    bool     `json:"matched"`
+	Confidence *float64 `json:"confidence"`
+}
+
+// handleClassify runs intent detection only (no completion, no TRTC update)
+// so persona routing can be tuned against a set of sample inputs.
+func (p *streamingTaskProcessor) handleClassify(w http.ResponseWriter, r *http.Request) {
+	var req classifyRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	text := strings.TrimSpace(req.Text)
+	if text == "" {
+		writeJSONError(w, http.StatusBadRequest, "text is required")
+		return
+	}
+
+	result, err := p.classifyIntent(r.Context(), p.prompts.snapshot().forLocale(requestLocale(req.Locale, text)), text, req.Previous, true)
+	if err != nil {
+		log.Printf("Classify request failed: %v", err)
+		writeJSONError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, classifyResponse{
+		Persona:    result.Persona,
+		Matched:    result.Matched,
+		Confidence: result.Confidence,
+	})
+}
+
+// completeRequest is the body of POST /complete.
+type completeRequest struct {
+	Text string `json:"text"`
+	// Locale picks the prompt locale; it is detected from the text when empty.
+	Locale string `json:"locale,omitempty"`
+}
+
+// completeResponse is the body returned by POST /complete.
+type completeResponse struct {
+	Persona string `json:"persona"`
+	Text    string `json:"text"`
+	// IntentFallback is set when intent detection failed and the default persona answered.
+	IntentFallback bool `json:"intent_fallback,omitempty"`
+	// InjectionSuspected is set when INJECTION_POLICY=flag and the text looked like a prompt injection.
+	InjectionSuspected bool `json:"injection_suspected,omitempty"`
+}
+
+// handleComplete runs intent detection and a non-streaming completion and returns
+// the reply as plain JSON, for integrations that do not want A2A tasks. It has no
+// TRTC side effects and keeps no session state.
+func (p *streamingTaskProcessor) handleComplete(w http.ResponseWriter, r *http.Request) {
+	var req completeRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	text := strings.TrimSpace(req.Text)
+	if text == "" {
+		writeJSONError(w, http.StatusBadRequest, "text is required")
+		return
+	}
+	// The reply can outlast the server's write timeout; it ends with the client's
+	// request or MAX_TASK_DURATION instead.
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		log.Printf("Complete: failed to lift the write deadline: %v", err)
+	}
+	ctx, cancel := withMaxTaskDuration(r.Context(), p.maxTaskDuration)
+	defer cancel()
+
+	resp, status, err := p.complete(ctx, text, req.Locale)
+	if err != nil {
+		writeJSONError(w, status, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// complete admits text against the quota and the LLM limiter, routes it and returns
+// the non-streaming reply. On failure it also returns the HTTP status for the error.
+func (p *streamingTaskProcessor) complete(ctx context.Context, text, locale string) (completeResponse, int, error) {
+	if err := p.usage.admit(ctx); err != nil {
+		return completeResponse{}, http.StatusTooManyRequests, err
+	}
+	release, err := p.limiter.acquire(ctx, p.taskPriority(ctx, nil))
+	if err != nil {
+		return completeResponse{}, http.StatusServiceUnavailable, err
+	}
+	defer release()
+
+	injectionSuspected, err := p.injection.scan(ctx, "complete", text)
+	if err != nil {
+		return completeResponse{}, http.StatusUnprocessableEntity, err
+	}
+
+	prompts := p.prompts.snapshot().forLocale(requestLocale(locale, text))
+	intent, intentFallback, err := p.detectIntent(ctx, text, routingSession{prompts: prompts})
+	if err != nil {
+		log.Printf("Complete request failed: %v", err)
+		return completeResponse{}, http.StatusBadGateway, err
+	}
+	var reply string
+	err = p.withEmptyRetry("complete", func() error {
+		var err error
+		reply, err = p.processWithOpenAINonStreaming(ctx, &completionTurn{
+			text:    text,
+			intent:  intent,
+			prompts: prompts,
+			seed:    p.seed,
+		})
+		return err
+	})
+	reply = p.postProcessors.apply(reply, true)
+	if errors.Is(err, errContentFlagged) {
+		return completeResponse{}, http.StatusUnprocessableEntity, err
+	}
+	if err != nil {
+		log.Printf("Complete request failed: %v", err)
+		return completeResponse{}, http.StatusBadGateway, err
+	}
+
+	return completeResponse{
+		Persona:            intent,
+		Text:               reply,
+		IntentFallback:     intentFallback,
+		InjectionSuspected: injectionSuspected,
+	}, http.StatusOK, nil
+}
+
+// TRTC control commands accepted by POST /trtc/push
+const (
+	trtcCommandPush      = "push"
+	trtcCommandInterrupt = "interrupt"
+)
+
+// trtcPushRequest is the body of POST /trtc/push.
+type trtcPushRequest struct {
+	TaskID string `json:"taskId"`
+	// Command is "push" (the default) or "interrupt".
+	Command string `json:"command,omitempty"`
+	// Text is required for "push" and ignored for "interrupt".
+	Text string `json:"text"`
+}
+
+// trtcErrorResponse describes a failed TRTC call.
+type trtcErrorResponse struct {
+	Error     string `json:"error"`
+	Code      string `json:"code,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+}
+
+// handleTRTCPush controls a live TRTC AI conversation out-of-band, independent
+// of any A2A task: "push" injects text for the AI to say, "interrupt" cuts off
+// its current speech.
+func handleTRTCPush(w http.ResponseWriter, r *http.Request) {
+	var req trtcPushRequest
+	if !decodeJSONBody(w, r, &req) {
+		return
+	}
+	if err := validateTRTCTaskID(req.TaskID); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var err error
+	switch req.Command {
+	case "", trtcCommandPush:
+		if strings.TrimSpace(req.Text) == "" {
+			writeJSONError(w, http.StatusBadRequest, "text is required")
+			return
+		}
+		err = ControlAIConversation(req.TaskID, req.Text)
+	case trtcCommandInterrupt:
+		err = InterruptAIConversation(req.TaskID)
+	default:
+		writeJSONError(w, http.StatusBadRequest,
+			fmt.Sprintf("unknown command %q, expected %q or %q", req.Command, trtcCommandPush, trtcCommandInterrupt))
+		return
+	}
+	if err != nil {
+		log.Printf("TRTC %s for task %s failed: %v", req.Command, req.TaskID, err)
+		writeTRTCError(w, err)
+		return
+	}
+
+	log.Printf("TRTC %s succeeded for task %s", req.Command, req.TaskID)
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// writeTRTCError reports a TRTC failure, including the SDK error code and request ID when available.
+// Pushing into a conversation that is not running is a 409 rather than an upstream failure,
+// and an unconfigured TRTC client is a 503.
+func writeTRTCError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errAIConversationInactive) {
+		writeJSON(w, http.StatusConflict, trtcErrorResponse{Error: err.Error()})
+		return
+	}
+	if errors.Is(err, errTRTCUnavailable) {
+		writeJSON(w, http.StatusServiceUnavailable, trtcErrorResponse{Error: err.Error()})
+		return
+	}
+	resp := trtcErrorResponse{Error: err.Error()}
+	var sdkErr *sdkerrors.TencentCloudSDKError
+	if errors.As(err, &sdkErr) {
+		resp.Code = sdkErr.GetCode()
+		resp.RequestID = sdkErr.GetRequestId()
+	}
+	writeJSON(w, http.StatusBadGateway, resp)
+}
+
+// decodeJSONBody decodes the request body into v, writing a 400 response and returning false on failure
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBodyBytes))
+	if err := decoder.Decode(v); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write JSON response: %v", err)
+	}
+}
+
+// writeJSONError writes a {"error": "..."} JSON response with the given status code
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,149 @@
+// Idempotent task submission: repeated requests with the same key reuse the first result
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+	"trpc.group/trpc-go/trpc-a2a-go/taskmanager"
+)
+
+// idempotencyMetadataKey is the message metadata field clients may use instead of the Idempotency-Key header.
+const idempotencyMetadataKey = "idempotency_key"
+
+// idempotencyKeyFor returns the client's idempotency key for a task, preferring the
+// Idempotency-Key header over message metadata. Keys are scoped to the API key so
+// different clients cannot read each other's results.
+func idempotencyKeyFor(ctx context.Context, message protocol.Message) string {
+	key := idempotencyKeyFromContext(ctx)
+	if key == "" {
+		key, _ = message.Metadata[idempotencyMetadataKey].(string)
+	}
+	if key == "" {
+		return ""
+	}
+	return apiKeyFromContext(ctx) + "\x00" + key
+}
+
+// idempotencyEntry tracks one idempotency key. done is closed once the owning task finishes.
+type idempotencyEntry struct {
+	done      chan struct{}
+	completed bool
+	artifacts []protocol.Artifact
+	final     *protocol.Message
+	expires   time.Time
+}
+
+// idempotencyCache remembers completed task results by idempotency key for ttl.
+// A nil *idempotencyCache disables idempotency handling.
+type idempotencyCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+}
+
+// newIdempotencyCache creates a cache keeping results for ttl, or nil if ttl <= 0
+func newIdempotencyCache(ttl time.Duration) *idempotencyCache {
+	if ttl <= 0 {
+		return nil
+	}
+	return &idempotencyCache{
+		ttl:     ttl,
+		entries: make(map[string]*idempotencyEntry),
+	}
+}
+
+// acquire returns the entry for key. If owner is true the caller must process the
+// task and then call finish. Otherwise the entry holds a completed result to replay.
+// Concurrent duplicates block until the owner finishes; if the owner did not
+// complete successfully, one of them takes over as the new owner.
+func (c *idempotencyCache) acquire(ctx context.Context, key string) (entry *idempotencyEntry, owner bool, err error) {
+	for {
+		c.mu.Lock()
+		c.pruneLocked()
+		entry, exists := c.entries[key]
+		if !exists {
+			entry = &idempotencyEntry{done: make(chan struct{})}
+			c.entries[key] = entry
+			c.mu.Unlock()
+			return entry, true, nil
+		}
+		c.mu.Unlock()
+
+		select {
+		case <-entry.done:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+		if entry.completed {
+			return entry, false, nil
+		}
+	}
+}
+
+// finish records the owner's result. Only completed tasks are cached; otherwise the
+// key is released so a retry processes the request again.
+func (c *idempotencyCache) finish(key string, entry *idempotencyEntry, recorder *recordingHandle) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if recorder.finalState == protocol.TaskStateCompleted {
+		entry.completed = true
+		entry.artifacts = recorder.artifacts
+		entry.final = recorder.finalMessage
+		entry.expires = time.Now().Add(c.ttl)
+	} else {
+		delete(c.entries, key)
+	}
+	close(entry.done)
+}
+
+// pruneLocked drops expired results. The caller must hold c.mu.
+func (c *idempotencyCache) pruneLocked() {
+	now := time.Now()
+	for key, entry := range c.entries {
+		if entry.completed && now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// replay re-emits a completed result on another task's handle
+func (e *idempotencyEntry) replay(handle taskmanager.TaskHandle) error {
+	for _, artifact := range e.artifacts {
+		if err := handle.AddArtifact(artifact); err != nil {
+			return err
+		}
+	}
+	return handle.UpdateStatus(protocol.TaskStateCompleted, e.final)
+}
+
+// recordingHandle wraps a TaskHandle and remembers the artifacts and final status it emitted.
+type recordingHandle struct {
+	taskmanager.TaskHandle
+
+	mu           sync.Mutex
+	artifacts    []protocol.Artifact
+	finalState   protocol.TaskState
+	finalMessage *protocol.Message
+}
+
+// UpdateStatus implements TaskHandle.
+func (h *recordingHandle) UpdateStatus(state protocol.TaskState, msg *protocol.Message) error {
+	h.mu.Lock()
+	h.finalState = state
+	h.finalMessage = msg
+	h.mu.Unlock()
+	return h.TaskHandle.UpdateStatus(state, msg)
+}
+
+// AddArtifact implements TaskHandle.
+func (h *recordingHandle) AddArtifact(artifact protocol.Artifact) error {
+	h.mu.Lock()
+	h.artifacts = append(h.artifacts, artifact)
+	h.mu.Unlock()
+	return h.TaskHandle.AddArtifact(artifact)
+}
@@ -0,0 +1,101 @@
+// Prompt-injection scan of user input before it reaches a persona
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+)
+
+// Injection policies selected by INJECTION_POLICY
+const (
+	injectionOff    = "off"
+	injectionLog    = "log"
+	injectionFlag   = "flag"
+	injectionRefuse = "refuse"
+)
+
+// injectionSuspectedMetadataKey marks updates of a task whose input looked like a
+// prompt-injection attempt under the flag policy.
+const injectionSuspectedMetadataKey = "injection_suspected"
+
+// errInjectionRefused fails a task under the refuse policy. Its message is shown to
+// the client, so it must not repeat the input or say which pattern matched.
+var errInjectionRefused = errors.New("the message was declined by the input safety check; please rephrase your request")
+
+// injectionDetector reports why text looks like a prompt-injection attempt, or ""
+// if it does not. Pattern matching is built in; a model-based detector can be
+// swapped in by providing another function.
+type injectionDetector func(ctx context.Context, text string) (reason string, err error)
+
+// injectionPatterns are common attempts to override a persona's instructions or
+// extract its system prompt, in English and Chinese.
+var injectionPatterns = []struct {
+	reason  string
+	pattern *regexp.Regexp
+}{
+	{"instruction override", regexp.MustCompile(`(?i)\b(ignore|disregard|forget|override)\b.{0,20}\b(previous|prior|above|earlier|preceding|all|your)\b.{0,20}\b(instructions?|prompts?|rules|directions|guidelines)\b`)},
+	{"system prompt extraction", regexp.MustCompile(`(?i)\b(reveal|show|print|repeat|output|display|leak|tell me)\b.{0,20}\b(system|initial|hidden|original|secret)\s+(prompt|instructions?|message)`)},
+	{"system prompt extraction", regexp.MustCompile(`(?i)\bwhat\s+(is|are|was|were)\s+your\s+(system\s+prompt|instructions|initial\s+prompt)`)},
+	{"instruction override", regexp.MustCompile(`(忽略|无视|忘记|忘掉).{0,10}(之前|以上|前面|上面|所有|全部).{0,4}(指令|指示|提示|规则|设定)`)},
+	{"system prompt extraction", regexp.MustCompile(`(告诉我|输出|显示|重复|透露|泄露|你的).{0,8}(系统提示|系统指令|初始指令|提示词)`)},
+}
+
+// patternInjectionDetector matches text against injectionPatterns
+func patternInjectionDetector(ctx context.Context, text string) (string, error) {
+	for _, p := range injectionPatterns {
+		if p.pattern.MatchString(text) {
+			return p.reason, nil
+		}
+	}
+	return "", nil
+}
+
+// injectionScanner applies an injectionDetector according to the injection policy.
+// A nil *injectionScanner lets all input through.
+type injectionScanner struct {
+	detect injectionDetector
+	policy string
+}
+
+// newInjectionScanner returns nil when policy is off, and an error for an unknown policy
+func newInjectionScanner(policy string, detect injectionDetector) (*injectionScanner, error) {
+	switch policy {
+	case "", injectionOff:
+		return nil, nil
+	case injectionLog, injectionFlag, injectionRefuse:
+		return &injectionScanner{detect: detect, policy: policy}, nil
+	default:
+		return nil, fmt.Errorf("unknown INJECTION_POLICY %q, expected %q, %q, %q or %q",
+			policy, injectionOff, injectionLog, injectionFlag, injectionRefuse)
+	}
+}
+
+// scan checks the user text of taskID. Every suspected injection is logged; flagged
+// is true under the flag policy, and the refuse policy returns errInjectionRefused.
+// A failed detector is logged and lets the input through, since refusing every
+// message while the detector is down would take the service with it.
+func (s *injectionScanner) scan(ctx context.Context, taskID, text string) (flagged bool, err error) {
+	if s == nil || text == "" {
+		return false, nil
+	}
+	reason, err := s.detect(ctx, text)
+	if err != nil {
+		log.Printf("Task %s injection scan failed, input allowed: %v", taskID, err)
+		return false, nil
+	}
+	if reason == "" {
+		return false, nil
+	}
+	log.Printf("Task %s input looks like a prompt-injection attempt (%s), policy %s", taskID, reason, s.policy)
+	switch s.policy {
+	case injectionRefuse:
+		return false, errInjectionRefused
+	case injectionFlag:
+		return true, nil
+	default:
+		return false, nil
+	}
+}
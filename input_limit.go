@@ -0,0 +1,56 @@
+// Handling of user input longer than MAX_INPUT_CHARS
+package main
+
+import (
+	"fmt"
+	"log"
+	"unicode/utf8"
+
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+	"trpc.group/trpc-go/trpc-a2a-go/taskmanager"
+)
+
+// Policies for over-long input, selected by INPUT_OVERFLOW_POLICY
+const (
+	inputOverflowFail         = "fail"
+	inputOverflowTruncateHead = "truncate_head"
+	inputOverflowTruncateTail = "truncate_tail"
+)
+
+// limitInput applies MAX_INPUT_CHARS to the task's text. Under the fail policy
+// over-long input is an error; the truncate policies drop the start (truncate_head)
+// or the end (truncate_tail) and tell the client with a working status carrying the
+// original and used lengths.
+func (p *streamingTaskProcessor) limitInput(taskID, text string, handle taskmanager.TaskHandle) (string, error) {
+	length := utf8.RuneCountInString(text)
+	if p.maxInputChars <= 0 || length <= p.maxInputChars {
+		return text, nil
+	}
+	var used, kept string
+	switch p.inputOverflowPolicy {
+	case inputOverflowTruncateHead:
+		used = string([]rune(text)[length-p.maxInputChars:])
+		kept = "last"
+	case inputOverflowTruncateTail:
+		used = truncateRunes(text, p.maxInputChars)
+		kept = "first"
+	default:
+		return "", fmt.Errorf("input is %d characters long; at most %d are accepted", length, p.maxInputChars)
+	}
+	log.Printf("Task %s: input of %d characters truncated to its %s %d (%s)", taskID, length, kept, p.maxInputChars, p.inputOverflowPolicy)
+	notice := protocol.NewMessage(
+		protocol.MessageRoleAgent,
+		[]protocol.Part{protocol.NewTextPart(fmt.Sprintf(
+			"Your message was too long and was shortened to its %s %d characters.", kept, p.maxInputChars))},
+	)
+	notice.Metadata = map[string]interface{}{
+		"input_truncated":       true,
+		"input_overflow_policy": p.inputOverflowPolicy,
+		"input_original_length": length,
+		"input_used_length":     p.maxInputChars,
+	}
+	if err := handle.UpdateStatus(protocol.TaskStateWorking, &notice); err != nil {
+		log.Printf("Error sending input truncation notice for task %s: %v", taskID, err)
+	}
+	return used, nil
+}
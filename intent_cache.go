@@ -0,0 +1,124 @@
+// Cache of intent classifications for repeated messages
+package main
+
+import (
+	"container/list"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// intentCache remembers the persona the model chose for a message, keyed by the
+// normalized text and locale, so identical messages skip the classifier. Only
+// classifications made without a session persona are cached: a session's persona
+// steers its classification, and a cached answer never overrides it. Entries expire
+// after ttl and the least recently used one is evicted beyond size entries.
+// A nil *intentCache caches nothing.
+type intentCache struct {
+	size int
+	ttl  time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// order holds the keys, most recently used first.
+	order  *list.List
+	hits   int
+	misses int
+}
+
+// intentCacheEntry is one cached classification.
+type intentCacheEntry struct {
+	key     string
+	persona string
+	// intent is the intent prompt that classified the message; a reloaded prompt
+	// may answer differently, so the entry only applies while it is unchanged.
+	intent  string
+	expires time.Time
+}
+
+// newIntentCache creates a cache of size entries, or nil if size <= 0
+func newIntentCache(size int, ttl time.Duration) *intentCache {
+	if size <= 0 {
+		return nil
+	}
+	return &intentCache{size: size, ttl: ttl, entries: make(map[string]*list.Element), order: list.New()}
+}
+
+// intentCacheKey normalizes text, ignoring case and runs of whitespace, and scopes it to the locale
+func intentCacheKey(prompts *promptSet, text string) string {
+	return prompts.locale + "\x00" + strings.ToLower(strings.Join(strings.Fields(text), " "))
+}
+
+// get returns the cached persona for text, if it is still valid for prompts
+func (c *intentCache) get(prompts *promptSet, text string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	key := intentCacheKey(prompts, text)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*intentCacheEntry)
+		if time.Now().Before(entry.expires) && entry.intent == prompts.intent && prompts.hasPersona(entry.persona) {
+			c.order.MoveToFront(element)
+			c.hits++
+			return entry.persona, true
+		}
+		c.order.Remove(element)
+		delete(c.entries, key)
+	}
+	c.misses++
+	return "", false
+}
+
+// put caches persona as the classification of text
+func (c *intentCache) put(prompts *promptSet, text, persona string) {
+	if c == nil {
+		return
+	}
+	key := intentCacheKey(prompts, text)
+	entry := &intentCacheEntry{key: key, persona: persona, intent: prompts.intent, expires: time.Now().Add(c.ttl)}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*intentCacheEntry).key)
+	}
+}
+
+// intentCacheStats is the cache's state reported by GET /admin/intent-cache.
+type intentCacheStats struct {
+	Enabled  bool    `json:"enabled"`
+	Entries  int     `json:"entries"`
+	Capacity int     `json:"capacity"`
+	Hits     int     `json:"hits"`
+	Misses   int     `json:"misses"`
+	HitRate  float64 `json:"hitRate"`
+}
+
+// stats returns the cache's size and hit rate since startup
+func (c *intentCache) stats() intentCacheStats {
+	if c == nil {
+		return intentCacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := intentCacheStats{Enabled: true, Entries: c.order.Len(), Capacity: c.size, Hits: c.hits, Misses: c.misses}
+	if lookups := c.hits + c.misses; lookups > 0 {
+		stats.HitRate = float64(c.hits) / float64(lookups)
+	}
+	return stats
+}
+
+// handleIntentCache serves GET /admin/intent-cache, the cache's size and hit rate
+func (c *intentCache) handleIntentCache(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, c.stats())
+}
@@ -0,0 +1,56 @@
+// Interrupting a TRTC response when the user speaks again
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+// errInterruptedByNewInput is the cancellation cause of a task cut off by a newer message in its session.
+var errInterruptedByNewInput = errors.New("interrupted by a newer message in the session")
+
+// interruptWaitTimeout bounds how long a new message waits for the response it interrupted to wind down.
+const interruptWaitTimeout = 2 * time.Second
+
+// interruptsOnNewInput reports whether a response by persona is cut off when new
+// input arrives: its PERSONA_INTERRUPT_ON_NEW_INPUT setting if any, otherwise
+// TRTC_INTERRUPT_ON_NEW_INPUT. A task still in intent detection has no persona and
+// follows the global setting.
+func (p *streamingTaskProcessor) interruptsOnNewInput(persona string) bool {
+	if interrupt, ok := p.personaInterruptOnNewInput[persona]; ok {
+		return interrupt
+	}
+	return p.interruptOnNewInput
+}
+
+// interruptForNewInput runs when a message arrives for session. It cancels the
+// session's TRTC tasks that are still responding with an interruptible persona,
+// interrupts their speech so the AI stops mid-sentence, and waits briefly for them
+// to finish, so a message reusing the task ID is not rejected as a duplicate.
+func (p *streamingTaskProcessor) interruptForNewInput(session string) {
+	if !p.interruptOnNewInput && len(p.personaInterruptOnNewInput) == 0 {
+		return
+	}
+	interrupted := p.tasks.cancelSession(session, errInterruptedByNewInput, func(taskID string, task *activeTask) bool {
+		return validateTRTCTaskID(taskID) == nil && p.interruptsOnNewInput(task.persona)
+	})
+	if len(interrupted) == 0 {
+		return
+	}
+	timeout := time.NewTimer(interruptWaitTimeout)
+	defer timeout.Stop()
+	for taskID, task := range interrupted {
+		log.Printf("Task %s interrupted: a new message arrived for session %s", taskID, session)
+		// The only interrupt for the task: the TRTC failure handler skips this cause.
+		if err := InterruptAIConversation(taskID); err != nil && !errors.Is(err, errAIConversationInactive) {
+			log.Printf("Task %s: TRTC interrupt for new input failed: %v", taskID, err)
+		}
+		select {
+		case <-task.done:
+		case <-timeout.C:
+			log.Printf("Task %s is still finishing after its interrupt, not waiting any longer", taskID)
+			return
+		}
+	}
+}
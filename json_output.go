@@ -0,0 +1,67 @@
+// JSON output mode for replies consumed by automation
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+	"trpc.group/trpc-go/trpc-a2a-go/taskmanager"
+)
+
+// responseFormatMetadataKey is the message metadata field a client sets to "json" to request JSON output.
+const responseFormatMetadataKey = "response_format"
+
+// jsonOutputInstruction is appended to the system prompt in JSON mode; OpenAI rejects
+// JSON mode requests whose messages do not mention JSON.
+const jsonOutputInstruction = "Respond only with a single valid JSON object."
+
+// errInvalidJSONOutput fails JSON mode tasks whose reply does not parse.
+var errInvalidJSONOutput = errors.New("the response is not valid JSON")
+
+// wantsJSON reports whether the turn's reply must be a JSON object, because the
+// request asks for it or the persona always answers in JSON (JSON_PERSONAS)
+func (p *streamingTaskProcessor) wantsJSON(intent string, metadata map[string]interface{}) bool {
+	if format, _ := metadata[responseFormatMetadataKey].(string); strings.EqualFold(format, "json") ||
+		strings.EqualFold(format, string(openai.ChatCompletionResponseFormatTypeJSONObject)) {
+		return true
+	}
+	return p.jsonPersonas[intent]
+}
+
+// applyJSONMode switches a completion request to JSON object output
+func applyJSONMode(req *openai.ChatCompletionRequest) {
+	req.ResponseFormat = &openai.ChatCompletionResponseFormat{
+		Type: openai.ChatCompletionResponseFormatTypeJSONObject,
+	}
+	req.Messages[0].Content += "\n\n" + jsonOutputInstruction
+}
+
+// validateJSONOutput checks a JSON mode reply. When it does not parse, the raw text
+// is attached as an artifact so the client can inspect it, and errInvalidJSONOutput is returned.
+func validateJSONOutput(taskID string, handle taskmanager.TaskHandle, reply string, index int) error {
+	if json.Valid([]byte(strings.TrimSpace(reply))) {
+		return nil
+	}
+	log.Printf("Task %s: JSON mode reply is not valid JSON (%d bytes)", taskID, len(reply))
+	artifact := protocol.Artifact{
+		Name:        stringPtr("Raw Response"),
+		Description: stringPtr("Reply that failed JSON validation"),
+		Index:       index,
+		Parts:       []protocol.Part{protocol.NewTextPart(reply)},
+		LastChunk:   boolPtr(true),
+		Metadata: map[string]interface{}{
+			"timestamp":    time.Now().UnixNano(),
+			"total_length": len(reply),
+			"invalid_json": true,
+		},
+	}
+	if err := handle.AddArtifact(artifact); err != nil {
+		log.Printf("Error adding raw response artifact for task %s: %v", taskID, err)
+	}
+	return errInvalidJSONOutput
+}
@@ -0,0 +1,201 @@
+// Concurrency limiting for OpenAI calls
+package main
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// errServerBusy is returned when no LLM slot could be acquired in time.
+var errServerBusy = errors.New("server busy: too many concurrent requests, please try again later")
+
+// Busy policies for when all LLM slots are taken
+const (
+	busyPolicyQueue  = "queue"
+	busyPolicyReject = "reject"
+)
+
+// llmLimiter bounds the number of simultaneous OpenAI calls. Under the queue policy
+// callers wait for a slot in priority order, higher first, and in arrival order
+// within a priority. A nil *llmLimiter imposes no limit.
+type llmLimiter struct {
+	limit        int
+	queueTimeout time.Duration
+	policy       string
+
+	mu      sync.Mutex
+	inUse   int
+	waiting slotQueue
+	arrived uint64
+}
+
+// newLLMLimiter creates a limiter with maxConcurrent slots, or nil if maxConcurrent <= 0
+func newLLMLimiter(maxConcurrent int, queueTimeout time.Duration, policy string) *llmLimiter {
+	if maxConcurrent <= 0 {
+		return nil
+	}
+	if policy != busyPolicyReject {
+		policy = busyPolicyQueue
+	}
+	return &llmLimiter{
+		limit:        maxConcurrent,
+		queueTimeout: queueTimeout,
+		policy:       policy,
+	}
+}
+
+// acquire takes a slot, waiting up to queueTimeout under the queue policy behind
+// every waiter of at least the same priority. On success the returned release func
+// must be called once the OpenAI work is done.
+func (l *llmLimiter) acquire(ctx context.Context, priority int) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	l.mu.Lock()
+	if l.inUse < l.limit && len(l.waiting) == 0 {
+		l.inUse++
+		l.mu.Unlock()
+		return l.release, nil
+	}
+	if l.policy == busyPolicyReject {
+		l.mu.Unlock()
+		return nil, errServerBusy
+	}
+	l.arrived++
+	w := &slotWaiter{priority: priority, arrival: l.arrived, ready: make(chan struct{})}
+	heap.Push(&l.waiting, w)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-w.ready:
+		return l.release, nil
+	case <-timer.C:
+		err = errServerBusy
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if w.index < 0 {
+		// The slot was handed over while giving up; pass it on.
+		l.handOver()
+	} else {
+		heap.Remove(&l.waiting, w.index)
+	}
+	return nil, err
+}
+
+// release frees a slot, handing it to the first waiter if there is one
+func (l *llmLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.handOver()
+}
+
+// handOver gives a held slot to the first waiter, or frees it. l.mu must be held.
+func (l *llmLimiter) handOver() {
+	if len(l.waiting) == 0 {
+		l.inUse--
+		return
+	}
+	w := heap.Pop(&l.waiting).(*slotWaiter)
+	close(w.ready)
+}
+
+// queueStats is the limiter's state reported by GET /admin/queue.
+type queueStats struct {
+	Capacity int `json:"capacity"`
+	InUse    int `json:"inUse"`
+	Queued   int `json:"queued"`
+	// QueuedByPriority counts the waiting callers of each priority.
+	QueuedByPriority map[string]int `json:"queuedByPriority"`
+}
+
+// stats returns the current slot usage and queue depth; a nil *llmLimiter has no capacity
+func (l *llmLimiter) stats() queueStats {
+	stats := queueStats{QueuedByPriority: map[string]int{}}
+	if l == nil {
+		return stats
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	stats.Capacity = l.limit
+	stats.InUse = l.inUse
+	stats.Queued = len(l.waiting)
+	for _, w := range l.waiting {
+		stats.QueuedByPriority[strconv.Itoa(w.priority)]++
+	}
+	return stats
+}
+
+// handleQueue serves GET /admin/queue, the LLM slot usage and queue depth
+func (l *llmLimiter) handleQueue(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, l.stats())
+}
+
+// slotWaiter is a caller queued for an LLM slot. ready is closed once it holds one.
+type slotWaiter struct {
+	priority int
+	arrival  uint64
+	ready    chan struct{}
+	// index is the waiter's position in the queue, or -1 once it left it.
+	index int
+}
+
+// slotQueue orders waiters by priority, higher first, then by arrival. It implements heap.Interface.
+type slotQueue []*slotWaiter
+
+func (q slotQueue) Len() int { return len(q) }
+
+func (q slotQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].arrival < q[j].arrival
+}
+
+func (q slotQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *slotQueue) Push(x interface{}) {
+	w := x.(*slotWaiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *slotQueue) Pop() interface{} {
+	old := *q
+	w := old[len(old)-1]
+	old[len(old)-1] = nil
+	w.index = -1
+	*q = old[:len(old)-1]
+	return w
+}
+
+// taskPriority returns the LLM queue priority of a request: its API key's
+// API_KEY_PRIORITIES entry, otherwise the whole number in the PRIORITY_METADATA_KEY
+// message metadata, otherwise 0
+func (p *streamingTaskProcessor) taskPriority(ctx context.Context, metadata map[string]interface{}) int {
+	if priority, ok := p.keyPriorities[apiKeyFromContext(ctx)]; ok {
+		return priority
+	}
+	if p.priorityMetadataKey == "" {
+		return 0
+	}
+	if value, ok := metadata[p.priorityMetadataKey].(float64); ok && value == float64(int(value)) {
+		return int(value)
+	}
+	return 0
+}
@@ -0,0 +1,187 @@
+// Locale-specific variants of the intent and persona prompts
+package main
+
+import (
+	"fmt"
+	"maps"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// localeMetadataKey is the message metadata key a client sets to pick the prompt
+// locale, e.g. "zh-CN". Without it the locale is guessed from the input's script.
+const localeMetadataKey = "locale"
+
+// defaultLocale is the locale of the top-level PROMPTS_DIR files and the built-in prompts.
+const defaultLocale = "en"
+
+// validLocale matches normalized locale tags such as "zh", "pt-br" or "zh-hant".
+var validLocale = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+
+// builtinIntentPreambles replace defaultIntentPreamble for locales that have no
+// intent_detection.txt of their own, when no custom intent prompt is configured.
+var builtinIntentPreambles = map[string]string{
+	"zh": `你是一个意图识别助手。你需要判断用户想和哪一个AI助手对话。`,
+}
+
+// normalizeLocale lower-cases a locale tag and uses "-" as its separator, so
+// "zh_CN" and "zh-cn" name the same locale
+func normalizeLocale(locale string) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimSpace(locale)), "_", "-")
+}
+
+// localeLanguage returns the language subtag of a normalized locale, e.g. "zh" for "zh-cn"
+func localeLanguage(locale string) string {
+	language, _, _ := strings.Cut(locale, "-")
+	return language
+}
+
+// scriptLocales are the languages guessed from the script of the input. Latin
+// script is shared by too many languages to guess from, so it is not listed.
+var scriptLocales = []struct {
+	locale string
+	script *unicode.RangeTable
+}{
+	{"ja", unicode.Hiragana},
+	{"ja", unicode.Katakana},
+	{"zh", unicode.Han},
+	{"ko", unicode.Hangul},
+	{"ru", unicode.Cyrillic},
+	{"ar", unicode.Arabic},
+	{"th", unicode.Thai},
+	{"hi", unicode.Devanagari},
+}
+
+// detectLocale guesses the language of text from the script most of its letters
+// are written in, or returns "" when that is Latin or there are no letters.
+// Japanese mixes kana with Han characters, so any kana makes it Japanese.
+func detectLocale(text string) string {
+	counts := make(map[string]int)
+	latin := 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		if unicode.Is(unicode.Latin, r) {
+			latin++
+			continue
+		}
+		for _, sl := range scriptLocales {
+			if unicode.Is(sl.script, r) {
+				counts[sl.locale]++
+				break
+			}
+		}
+	}
+	if counts["ja"] > 0 {
+		counts["ja"] += counts["zh"]
+		delete(counts, "zh")
+	}
+	best, bestCount := "", latin
+	for _, sl := range scriptLocales {
+		if counts[sl.locale] > bestCount {
+			best, bestCount = sl.locale, counts[sl.locale]
+		}
+	}
+	return best
+}
+
+// messageLocale returns the locale requested in metadata, or the one detected from text
+func messageLocale(metadata map[string]interface{}, text string) string {
+	locale, _ := metadata[localeMetadataKey].(string)
+	return requestLocale(locale, text)
+}
+
+// requestLocale returns locale if set, and the one detected from text otherwise
+func requestLocale(locale, text string) string {
+	if strings.TrimSpace(locale) != "" {
+		return locale
+	}
+	return detectLocale(text)
+}
+
+// forLocale returns the prompts for locale: the variant of the same locale, else of
+// the same language ("zh-tw" falls back to "zh", and "zh" to "zh-cn"), else ps itself.
+func (ps *promptSet) forLocale(locale string) *promptSet {
+	locale = normalizeLocale(locale)
+	if locale == "" || len(ps.locales) == 0 {
+		return ps
+	}
+	if variant, ok := ps.locales[locale]; ok {
+		return variant
+	}
+	language := localeLanguage(locale)
+	if variant, ok := ps.locales[language]; ok {
+		return variant
+	}
+	if localeLanguage(ps.locale) == language {
+		return ps
+	}
+	candidates := make([]string, 0, len(ps.locales))
+	for candidate := range ps.locales {
+		if localeLanguage(candidate) == language {
+			candidates = append(candidates, candidate)
+		}
+	}
+	if len(candidates) == 0 {
+		return ps
+	}
+	sort.Strings(candidates)
+	return ps.locales[candidates[0]]
+}
+
+// loadLocales adds a variant of ps for each of locales, read from dir/<locale> (if
+// dir is set) over ps's own prompts, so a locale needs only the files it translates.
+// The variants have the same personas as ps, in the same order; persona files for
+// other personas are ignored. A locale without its own intent_detection.txt builds its
+// intent prompt from ps's custom intent prompt, or from its built-in preamble if
+// there is one, with its own persona descriptions as the options.
+func (ps *promptSet) loadLocales(dir string, locales []string) error {
+	ps.locales = make(map[string]*promptSet)
+	for _, locale := range locales {
+		locale = normalizeLocale(locale)
+		if locale == ps.locale || ps.locales[locale] != nil {
+			continue
+		}
+		variant := &promptSet{
+			personaIDs:   ps.personaIDs,
+			personas:     maps.Clone(ps.personas),
+			descriptions: maps.Clone(ps.descriptions),
+			greetings:    maps.Clone(ps.greetings),
+			examples:     maps.Clone(ps.examples),
+			guard:        ps.guard,
+			locale:       locale,
+		}
+		intent := ""
+		if dir != "" {
+			var err error
+			if intent, err = readPersonaFiles(variant, filepath.Join(dir, locale)); err != nil {
+				return err
+			}
+		}
+		if intent == "" {
+			intent = ps.intentSource
+		}
+		if intent == "" {
+			intent = builtinIntentPreambles[localeLanguage(locale)]
+		}
+		if err := variant.compile(intent); err != nil {
+			return fmt.Errorf("locale %s: %w", locale, err)
+		}
+		ps.locales[locale] = variant
+	}
+	return nil
+}
+
+// localeNames returns the locales ps has variants for, sorted
+func (ps *promptSet) localeNames() []string {
+	names := make([]string, 0, len(ps.locales))
+	for locale := range ps.locales {
+		names = append(names, locale)
+	}
+	sort.Strings(names)
+	return names
+}
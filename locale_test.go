@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMessageLocale(t *testing.T) {
+	tests := []struct {
+		metadata map[string]interface{}
+		text     string
+		want     string
+	}{
+		{map[string]interface{}{localeMetadataKey: "zh-CN"}, "hello", "zh-CN"},
+		{nil, "你好，今天天气怎么样", "zh"},
+		{nil, "こんにちは、元気ですか", "ja"},
+		{map[string]interface{}{localeMetadataKey: " "}, "hello there", ""},
+	}
+	for _, test := range tests {
+		if got := messageLocale(test.metadata, test.text); got != test.want {
+			t.Errorf("messageLocale(%v, %q) = %q, want %q", test.metadata, test.text, got, test.want)
+		}
+	}
+}
+
+func TestPromptsForLocale(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"XiaoMei.txt":    "English XiaoMei",
+		"zh/XiaoMei.txt": "Chinese XiaoMei",
+		"ja/XiaoMei.txt": "Japanese XiaoMei",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store, err := newPromptStore(dir, "", "", defaultLocale, []string{"zh", "ja"})
+	if err != nil {
+		t.Fatalf("newPromptStore: %v", err)
+	}
+	prompts := store.snapshot()
+
+	tests := []struct {
+		locale string
+		want   string
+	}{
+		{"zh", "Chinese XiaoMei"},
+		{"zh-TW", "Chinese XiaoMei"},
+		{"ja-JP", "Japanese XiaoMei"},
+		// Locales without a variant fall back to the default prompts.
+		{"fr", "English XiaoMei"},
+		{"", "English XiaoMei"},
+	}
+	for _, test := range tests {
+		if got := prompts.forLocale(test.locale).renderPersona("XiaoMei", nil); got != test.want {
+			t.Errorf("forLocale(%q) XiaoMei prompt = %q, want %q", test.locale, got, test.want)
+		}
+	}
+}
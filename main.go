@@ -9,20 +9,24 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"github.com/joho/godotenv"
 	"io"
 	"log"
+	"math"
+	"net"
+	"net/http"
 	"os"
 	"os/signal"
-	"strconv"
 	"strings"
 	"syscall"
 	"time"
-	"trpc.group/trpc-go/trpc-a2a-go/server"
+	"unicode/utf8"
 
+	"github.com/joho/godotenv"
 	"github.com/sashabaranov/go-openai"
 	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+	"trpc.group/trpc-go/trpc-a2a-go/server"
 	"trpc.group/trpc-go/trpc-a2a-go/taskmanager"
 )
 
@@ -30,6 +34,129 @@ import (
 type streamingTaskProcessor struct {
 	openaiClient *openai.Client
 	openaiModel  string
+	// personaModels overrides openaiModel for the listed personas' completions.
+	personaModels map[string]string
+	// historyTurns is how many exchanges per session are remembered and sent with
+	// completions; 0 disables history. autoSummarize condenses the oldest exchanges
+	// with summaryModel when they overflow the context window.
+	historyTurns  int
+	autoSummarize bool
+	summaryModel  string
+	// usage accounts tokens per API key and enforces the daily quotas.
+	usage *usageStore
+	// jsonPersonas always reply in JSON mode.
+	jsonPersonas map[string]bool
+	// trtcFailure interrupts or apologises in the TRTC conversation of a failed task.
+	trtcFailure *trtcFailureHandler
+	// maxClientTimeout caps deadlines requested in message metadata; 0 leaves them uncapped.
+	maxClientTimeout time.Duration
+	// maxTaskDuration fails any task still running after it; 0 disables the limit.
+	maxTaskDuration time.Duration
+	// discloseName lets personas tell the user their name; personaDiscloseName overrides it per persona.
+	discloseName        bool
+	personaDiscloseName map[string]bool
+	// interruptOnNewInput cuts off a session's TRTC response when a new message arrives;
+	// personaInterruptOnNewInput overrides it per persona.
+	interruptOnNewInput        bool
+	personaInterruptOnNewInput map[string]bool
+	// selfDescription adds the persona's description to its system prompt.
+	selfDescription bool
+	// agentCard is the card before persona skills are added; skills is the
+	// ENABLED_SKILLS filter applied to it and to requests.
+	agentCard server.AgentCard
+	skills    skillFilter
+	// latencyMetrics collects inter-token gaps for /metrics with METRICS_ENABLED.
+	latencyMetrics *latencyHistogram
+	// personaHandoff lets a persona hand its reply to another one with a handoff marker.
+	personaHandoff bool
+	// clarifyingQuestions lets a persona pause the task in input-required with a question.
+	clarifyingQuestions bool
+	// modelAllowlist holds the models a request may pick with "model" metadata.
+	modelAllowlist map[string]bool
+	// tasks tracks the tasks being processed, to reject duplicate task IDs and cancel sessions.
+	tasks *taskRegistry
+	// taskManager stores the tasks, for looking up their session IDs.
+	taskManager *taskmanager.MemoryTaskManager
+	// router picks the persona for each message.
+	router Router
+	// intentTimeout bounds intent detection, after which the default persona answers; 0 means no separate bound.
+	intentTimeout time.Duration
+	// sampling holds the default sampling parameters for completions;
+	// personaSampling overrides them per persona. Intent detection uses neither.
+	sampling        samplingParams
+	personaSampling map[string]samplingParams
+	sessions        *sessionStore
+	// sessionIDs derives the session every session-keyed feature uses for a task.
+	sessionIDs *sessionIDStrategy
+	limiter    *llmLimiter
+	// keyPriorities and priorityMetadataKey set the LLM queue priority of a task,
+	// from API_KEY_PRIORITIES and PRIORITY_METADATA_KEY.
+	keyPriorities       map[string]int
+	priorityMetadataKey string
+	// intentCache answers repeated messages without calling the intent classifier; nil disables it.
+	intentCache *intentCache
+	// sessionLimiter bounds the tasks of one session processed at once; nil for no limit.
+	sessionLimiter *sessionLimiter
+	moderator      *moderator
+	// injection scans user input for prompt-injection attempts; nil disables the scan.
+	injection *injectionScanner
+	// recorder writes a replayable log of each task; nil when RECORD_TASKS is off.
+	recorder *taskRecorder
+	// trtcFanout is how many TRTC conversations a reply is pushed to at once.
+	trtcFanout int
+	// degraded answers with canned replies while OpenAI is unreachable; nil when DEGRADED_MODE is off.
+	degraded *degradedResponder
+	// transcriber turns audio input into text; nil when no STT provider is configured.
+	transcriber transcriber
+	// push notifies client webhooks when a task finishes; nil when push notifications are disabled.
+	push *pushNotifier
+	// speech controls the optional audio artifact of the final response.
+	speech      *speechSettings
+	prompts     *promptStore
+	idempotency *idempotencyCache
+	// promptMetadataKeys lists the message metadata keys copied into the system prompt.
+	promptMetadataKeys []string
+	// stopSequences end generation when the model emits any of them.
+	stopSequences []string
+	// keepAliveInterval is how long the OpenAI stream may stall before a keep-alive
+	// status update is sent to downstream SSE clients. Zero disables keep-alives.
+	keepAliveInterval time.Duration
+	// streamBufferSize and streamBufferPolicy bound the chunks waiting for a slow client.
+	streamBufferSize   int
+	streamBufferPolicy string
+	// artifactFailureLimit fails a streamed task after this many artifact updates in
+	// a row failed. Zero only logs them.
+	artifactFailureLimit int
+	// chunkBatchSize is the number of characters coalesced into each streamed chunk.
+	// Values of 1 or less emit every delta as its own chunk.
+	chunkBatchSize int
+	// forceStreaming and forceNonStreaming override the client's choice of response mode.
+	forceStreaming    bool
+	forceNonStreaming bool
+	// maxOutputChars stops streaming once the response reaches this many characters. Zero means no limit.
+	maxOutputChars int
+	// streamFallback retries a stream that broke after at most streamFallbackMaxChunks
+	// chunks without streaming.
+	streamFallback          bool
+	streamFallbackMaxChunks int
+	// maxInputChars caps the user's text, handled by inputOverflowPolicy. Zero means no limit.
+	maxInputChars       int
+	inputOverflowPolicy string
+	// maxTokens caps the tokens of each reply; zero leaves the API default.
+	maxTokens int
+	// seed is sent with every completion, including intent detection, unless a
+	// request sets its own; nil sends none.
+	seed *int
+	// postProcessors transform replies before they reach the client and TRTC.
+	postProcessors postProcessors
+	// promptCache keeps the prompt prefix identical across requests, with ENABLE_PROMPT_CACHE.
+	promptCache bool
+	// emptyOutputRetries is how often a completion without any output is repeated.
+	emptyOutputRetries int
+	// batchConcurrency and batchMaxItems bound the texts of a POST /batch request
+	// completed at once and in total; a zero batchMaxItems leaves batches unbounded.
+	batchConcurrency int
+	batchMaxItems    int
 }
 
 // Process implements the core streaming logic.
@@ -38,31 +165,213 @@ func (p *streamingTaskProcessor) Process(
 		taskID string,
 		message protocol.Message,
 		handle taskmanager.TaskHandle,
-) error {
+) (err error) {
 	log.Printf("Processing streaming task %s...", taskID)
+	ctx, cancelTask := context.WithCancelCause(ctx)
+	defer cancelTask(nil)
+	// Recorded below the other wrappers, so the log shows what clients were sent.
+	taskLog := p.recorder.start(taskID)
+	handle = taskLog.wrap(handle)
+	session := p.taskSession(ctx, taskID, message.Metadata)
+	task := p.tasks.begin(taskID, session, handle, cancelTask)
+	if task == nil {
+		return rejectDuplicateTask(taskID)
+	}
+	defer p.tasks.end(taskID)
+	handle = p.tasks.track(taskID, handle)
+	taskLog.input(message)
+	defer func() { taskLog.finish(err) }()
+	cancellation := withCancellation(handle, ctx)
+	handle = cancellation
+	ctx, cancelWatchdog := withMaxTaskDuration(ctx, p.maxTaskDuration)
+	defer cancelWatchdog()
+	// Runs after the final status is set, whichever way the task ends.
+	defer p.push.notify(taskID)
+	// The canceled status is already set; returning nil keeps the task manager from
+	// marking the task failed. Runs after the TRTC failure handler has seen the error.
+	defer func() {
+		if err != nil && cancellation.canceled() {
+			log.Printf("Task %s stopped: %v", taskID, context.Cause(cancellation.ctx))
+			err = nil
+		}
+	}()
+	defer func() {
+		// Checked once this task has ended, since until then no other task can begin with its ID.
+		superseded := func() bool {
+			<-task.done
+			return p.tasks.superseded(taskID, task)
+		}
+		p.trtcFailure.handle(taskID, err, context.Cause(cancellation.ctx), superseded)
+	}()
 	log.Printf("Task %s received message: %s", taskID, message)
 
 	text := extractText(message)
+	var audio *protocol.FilePart
 	if text == "" {
-		errMsg := "input message must contain text"
-		log.Printf("Task %s failed: %s", taskID, errMsg)
+		audio = extractAudioPart(message)
+	}
+	if text == "" && audio == nil {
+		return failTask(handle, taskID, "failed", errors.New("input message must contain text or audio"))
+	}
+
+	ctx, cancelDeadline := withTaskDeadline(ctx, message.Metadata, p.maxClientTimeout)
+	defer cancelDeadline()
+
+	model, err := p.requestedModel(message.Metadata)
+	if err != nil {
+		return failTask(handle, taskID, "rejected", err)
+	}
+	seed, err := p.requestedSeed(message.Metadata)
+	if err != nil {
+		return failTask(handle, taskID, "rejected", err)
+	}
+	sampling, err := requestedSampling(message.Metadata)
+	if err != nil {
+		return failTask(handle, taskID, "rejected", err)
+	}
+
+	if key := idempotencyKeyFor(ctx, message); key != "" && p.idempotency != nil {
+		entry, owner, err := p.idempotency.acquire(ctx, key)
+		if err != nil {
+			return err
+		}
+		if !owner {
+			log.Printf("Task %s is a duplicate submission, replaying cached result", taskID)
+			return entry.replay(handle)
+		}
+		recorder := &recordingHandle{TaskHandle: handle}
+		handle = recorder
+		defer p.idempotency.finish(key, entry, recorder)
+	}
+
+	releaseSession, err := p.sessionLimiter.acquire(ctx, session)
+	if err != nil {
+		return failTask(handle, taskID, "could not start, its session is busy", err)
+	}
+	defer releaseSession()
+
+	if err := p.usage.admit(ctx); err != nil {
+		return failTask(handle, taskID, "rejected", err)
+	}
+
+	release, err := p.limiter.acquire(ctx, p.taskPriority(ctx, message.Metadata))
+	if err != nil {
+		return failTask(handle, taskID, "could not acquire an LLM slot", err)
+	}
+	defer release()
+
+	if audio != nil {
+		text, err = p.transcribeAudio(ctx, taskID, audio, handle)
+		if err != nil {
+			log.Printf("Task %s audio transcription failed: %v", taskID, err)
+			failedMessage := protocol.NewMessage(
+				protocol.MessageRoleAgent,
+				[]protocol.Part{protocol.NewTextPart(fmt.Sprintf("Failed to transcribe audio input: %v", err))},
+			)
+			_ = handle.UpdateStatus(protocol.TaskStateFailed, &failedMessage)
+			return err
+		}
+	}
+
+	if text, err = p.limitInput(taskID, text, handle); err != nil {
+		return failTask(handle, taskID, "rejected", err)
+	}
+
+	injectionSuspected, err := p.injection.scan(ctx, taskID, text)
+	if err != nil {
+		return failTask(handle, taskID, "refused", err)
+	}
 
+	prompts := p.prompts.snapshot().forLocale(messageLocale(message.Metadata, text))
+	skillPersona, err := p.requestedSkill(prompts, message.Metadata)
+	if err != nil {
+		return failTask(handle, taskID, "rejected", err)
+	}
+	previous := p.sessions.lastPersona(session)
+	answering := p.sessions.takeQuestion(session)
+	sendPhase(taskID, handle, phaseIntentDetection)
+	intent, intentFallback, err := p.detectIntent(ctx, text, routingSession{
+		id:        taskID,
+		previous:  previous,
+		prompts:   prompts,
+		answering: answering != "",
+		skill:     skillPersona,
+	})
+	if err != nil {
+		log.Printf("Task %s intent detection failed: %v", taskID, err)
 		failedMessage := protocol.NewMessage(
 			protocol.MessageRoleAgent,
-			[]protocol.Part{protocol.NewTextPart(errMsg)},
+			[]protocol.Part{protocol.NewTextPart(processingFailureText(deadlineError(ctx, err)))},
 		)
 		_ = handle.UpdateStatus(protocol.TaskStateFailed, &failedMessage)
-		return fmt.Errorf(errMsg)
+		return err
 	}
-
-	isStreaming := handle.IsStreamingRequest()
+	if err := p.skills.checkPersona(prompts, intent); err != nil {
+		return failTask(handle, taskID, "rejected", err)
+	}
+	firstTurn := p.sessions.setPersona(session, intent)
+	p.tasks.setPersona(taskID, intent)
+	taskLog.persona(intent, intentFallback)
+	log.Printf("Task %s will be processed by %s", taskID, intent)
+	labels := make(map[string]interface{})
+	if len(p.prompts.snapshot().locales) > 0 {
+		log.Printf("Task %s uses the %s prompts", taskID, prompts.locale)
+		labels[localeMetadataKey] = prompts.locale
+	}
+	if intentFallback {
+		labels[intentFallbackMetadataKey] = true
+	}
+	if injectionSuspected {
+		labels[injectionSuspectedMetadataKey] = true
+	}
+	handle = withPersona(handle, intent, labels)
+
+	rooms := trtcRooms(taskID, message.Metadata)
+	if greeting := prompts.greeting(intent); firstTurn && greeting != "" {
+		// The greeting must be spoken in the persona's voice and before the reply,
+		// so on a greeting turn the voice switch runs inline.
+		updateTRTCVoice(taskID, intent)
+		p.sendGreeting(taskID, intent, greeting, rooms, handle)
+	} else {
+		// The voice switch runs in the background so a slow TRTC API never delays the completion.
+		go updateTRTCVoice(taskID, intent)
+	}
+	sendPhase(taskID, handle, phaseGeneration)
+
+	turn := &completionTurn{
+		text:     text,
+		intent:   intent,
+		prompts:  prompts,
+		metadata: message.Metadata,
+		model:    model,
+		rooms:    rooms,
+		seed:     seed,
+		sampling: sampling,
+		session:  session,
+
+		jsonOutput: p.wantsJSON(intent, message.Metadata),
+	}
+	// JSON replies cannot be stitched together, and the guard persona only refuses.
+	turn.handoff = p.personaHandoff && !turn.jsonOutput && !prompts.isGuard(intent)
+	turn.clarify = p.clarifyingQuestions && !turn.jsonOutput && !prompts.isGuard(intent)
+	if intent == previous {
+		turn.answering = answering
+	}
+	if p.historyTurns > 0 {
+		turn.summary, turn.history = p.sessions.history(session)
+	}
+	isStreaming, reason := p.useStreaming(handle)
 
 	if !isStreaming {
-		log.Printf("Task %s using non-streaming mode", taskID)
-		return p.processNonStreaming(ctx, taskID, text, handle)
+		log.Printf("Task %s using non-streaming mode (%s)", taskID, reason)
+		err = p.processNonStreaming(ctx, taskID, turn, handle)
+		if err == nil {
+			p.recordHistory(session, turn)
+		}
+		return err
 	}
 
-	log.Printf("Task %s using streaming mode", taskID)
+	log.Printf("Task %s using streaming mode (%s)", taskID, reason)
 
 	initialMessage := protocol.NewMessage(
 		protocol.MessageRoleAgent,
@@ -73,152 +382,492 @@ func (p *streamingTaskProcessor) Process(
 		return err
 	}
 
-	if err := p.processWithOpenAIStreaming(ctx, taskID, text, handle); err != nil {
+	err = p.withHistoryCompaction(ctx, session, turn, func() error {
+		return p.withEmptyRetry(taskID, func() error {
+			return p.processWithOpenAIStreaming(ctx, taskID, turn, handle)
+		})
+	})
+	if broken := p.brokenStreamFallback(ctx, turn, err); broken != nil {
+		log.Printf("Task %s: OpenAI stream broke after %d chunks (%v), retrying without streaming", taskID, broken.chunks, err)
+		turn.streamFallback = broken
+		err = p.processNonStreaming(ctx, taskID, turn, handle)
+		if err == nil {
+			p.recordHistory(session, turn)
+		}
+		return err
+	}
+	if err != nil {
+		if p.degraded.applies(ctx, turn, err) {
+			return p.respondDegraded(taskID, turn, handle, err)
+		}
+		err = deadlineError(ctx, err)
 		log.Printf("Error processing with OpenAI for task %s: %v", taskID, err)
 		failedMessage := protocol.NewMessage(
 			protocol.MessageRoleAgent,
-			[]protocol.Part{protocol.NewTextPart(fmt.Sprintf("Failed to process with OpenAI: %v", err))},
+			[]protocol.Part{protocol.NewTextPart(processingFailureText(err))},
 		)
 		_ = handle.UpdateStatus(protocol.TaskStateFailed, &failedMessage)
 		return err
 	}
 
+	p.recordHistory(session, turn)
 	log.Printf("Task %s streaming completed successfully.", taskID)
 	return nil
 }
 
+// failTask logs that taskID failed for reason and marks it failed with err as the
+// status message, returning err
+func failTask(handle taskmanager.TaskHandle, taskID, reason string, err error) error {
+	log.Printf("Task %s %s: %v", taskID, reason, err)
+	failedMessage := protocol.NewMessage(
+		protocol.MessageRoleAgent,
+		[]protocol.Part{protocol.NewTextPart(err.Error())},
+	)
+	_ = handle.UpdateStatus(protocol.TaskStateFailed, &failedMessage)
+	return err
+}
+
+// completionTurn is everything needed to generate the persona's reply to one user message.
+type completionTurn struct {
+	text    string
+	intent  string
+	prompts *promptSet
+	// metadata is the client's message metadata; only allowlisted keys reach the model.
+	metadata map[string]interface{}
+	// model is the model the request asked for, overriding the persona's; "" for none.
+	model string
+	// jsonOutput requests a JSON object reply, validated before the task completes.
+	jsonOutput bool
+	// summary and history are the session's earlier exchanges, sent between the
+	// few-shot examples and the user's message when HISTORY_MAX_TURNS is set.
+	summary string
+	history []historyTurn
+	// reply is set to the final reply text once the completion succeeds.
+	reply string
+	// emitted is set once part of a streamed reply has reached the client.
+	emitted bool
+	// rooms are further TRTC conversations that hear the reply, from trtc_rooms metadata.
+	rooms []string
+	// handoff lets the persona hand the reply to another one, with PERSONA_HANDOFF.
+	handoff bool
+	// handoffFrom is the persona that handed the reply over, and handoffSaid what it
+	// had said; both are set once a handoff has happened.
+	handoffFrom string
+	handoffSaid string
+	// clarify lets the persona ask a clarifying question, with CLARIFYING_QUESTIONS;
+	// inputRequired is set once its reply is one. answering is the question the
+	// user's message answers, or "".
+	clarify       bool
+	inputRequired bool
+	answering     string
+	// seed is sent with the completion; nil sends none.
+	seed *int
+	// sampling holds the parameters the request's metadata sets, overriding the persona's.
+	sampling samplingParams
+	// session is the task's session, whose history the turn continues.
+	session string
+	// fingerprint is the system_fingerprint of the last non-streaming completion.
+	fingerprint string
+	// finishReason is the finish_reason of the last completion.
+	finishReason openai.FinishReason
+	// streamFallback is the broken stream a non-streaming reply replaces, or nil.
+	streamFallback *streamBrokenError
+}
+
+// useStreaming decides whether to stream the reply, honouring FORCE_STREAMING and
+// FORCE_NON_STREAMING over the client's request, and says why for the logs
+func (p *streamingTaskProcessor) useStreaming(handle taskmanager.TaskHandle) (bool, string) {
+	switch {
+	case p.forceNonStreaming:
+		return false, "forced by FORCE_NON_STREAMING"
+	case p.forceStreaming:
+		return true, "forced by FORCE_STREAMING"
+	case handle.IsStreamingRequest():
+		return true, "requested by client"
+	default:
+		return false, "requested by client"
+	}
+}
+
+// buildCompletionRequest builds the chat completion request for the persona's reply
+// to the turn's text. Callers set Stream themselves.
+func (p *streamingTaskProcessor) buildCompletionRequest(turn *completionTurn) openai.ChatCompletionRequest {
+	systemPrompt := p.getAssistantPrompt(turn.prompts, turn.intent, turn.metadata)
+	// The per-request additions follow the persona prompt, or with ENABLE_PROMPT_CACHE
+	// get their own system message after the few-shot examples, so the prompt prefix
+	// stays identical across requests and can be served from the backend's cache.
+	var additions []string
+	if clientContext := metadataPromptContext(turn.metadata, p.promptMetadataKeys); clientContext != "" {
+		additions = append(additions, clientContext)
+	}
+	if handoff := handoffPrompt(turn); handoff != "" {
+		additions = append(additions, handoff)
+	}
+	if clarify := clarifyPrompt(turn); clarify != "" {
+		additions = append(additions, clarify)
+	}
+	if !p.promptCache && len(additions) > 0 {
+		systemPrompt += "\n\n" + strings.Join(additions, "\n\n")
+	}
+	// Order: system prompt, the persona's few-shot examples, the session history, then the user's message.
+	messages := []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: systemPrompt,
+		},
+	}
+	for _, example := range turn.prompts.fewShots(turn.intent) {
+		messages = append(messages,
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: example.User},
+			openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: example.Assistant},
+		)
+	}
+	if p.promptCache && len(additions) > 0 {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: strings.Join(additions, "\n\n"),
+		})
+	}
+	messages = append(messages, historyMessages(turn)...)
+	messages = append(messages, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: turn.text,
+	})
+	req := openai.ChatCompletionRequest{
+		Model:     p.turnModel(turn),
+		Messages:  messages,
+		Stop:      p.stopSequences,
+		MaxTokens: p.maxTokens,
+		Seed:      turn.seed,
+	}
+	p.samplingFor(turn.intent, turn.sampling).apply(&req)
+	if turn.jsonOutput {
+		applyJSONMode(&req)
+	}
+	return req
+}
+
 // processWithOpenAIStreaming sends the text to OpenAI API with streaming enabled
 // and processes the streaming response
 func (p *streamingTaskProcessor) processWithOpenAIStreaming(
 		ctx context.Context,
 		taskID string,
-		text string,
+		turn *completionTurn,
 		handle taskmanager.TaskHandle,
 ) error {
-	intent, err := p.detectIntent(ctx, text, taskID)
-	if err != nil {
-		log.Printf("Task %s intent detection failed: %v", taskID, err)
-		return fmt.Errorf("intent detection failed: %w", err)
-	}
-
-	log.Printf("Task %s will be processed by %s", taskID, intent)
-
-	req := openai.ChatCompletionRequest{
-		Model: p.openaiModel,
-		Messages: []openai.ChatCompletionMessage{
-			{
-				Role:    openai.ChatMessageRoleSystem,
-				Content: p.getAssistantPrompt(intent),
-			},
-			{
-				Role:    openai.ChatMessageRoleUser,
-				Content: text,
-			},
-		},
-		Stream: true,
-	}
+	req := p.buildCompletionRequest(turn)
+	req.Stream = true
+	turn.finishReason = ""
 
+	ctx, served := withServedBy(ctx)
 	stream, err := p.openaiClient.CreateChatCompletionStream(ctx, req)
 	if err != nil {
-		return fmt.Errorf("failed to create OpenAI streaming request: %w", err)
+		return served.annotate(fmt.Errorf("failed to create OpenAI streaming request: %w", err))
 	}
-	defer stream.Close()
+	// A handoff replaces the stream, its reader and the emitter; the latest are closed.
+	defer func() { stream.Close() }()
 
-	chunkIndex := 0
 	var fullResponse strings.Builder
+	outputChars := 0
+	truncated := false
 	startTime := time.Now()
 	firstTokenReceived := false
+	var timeToFirstToken time.Duration
+	var latency tokenLatency
+
+	done := make(chan struct{})
+	defer func() { close(done) }()
+	results := receiveStream(stream, done)
+	handoffs := p.handoffScanner(taskID, turn)
+	questions := p.clarifyScanner(turn)
+	toolCalls := newToolCallStream()
+	// segmentStart is where the current persona's part of fullResponse starts.
+	segmentStart := 0
+
+	var keepAlive <-chan time.Time
+	if p.keepAliveInterval > 0 {
+		ticker := time.NewTicker(p.keepAliveInterval)
+		defer ticker.Stop()
+		keepAlive = ticker.C
+	}
+	lastActivity := time.Now()
+
+	// With moderation on, text is only released a sentence at a time once it has passed.
+	sentences := &sentenceModerator{moderator: p.moderator}
+	post := &sentencePostProcessor{pipeline: p.postProcessorsFor(turn)}
+
+	emitter := newChunkEmitter(taskID, handle, req.Model, p.streamBufferSize, p.streamBufferPolicy,
+		progressEstimator{maxChars: p.maxOutputChars, maxTokens: req.MaxTokens}, p.artifactFailureLimit)
+	defer func() { emitter.close() }()
+	broadcast := newTRTCBroadcast(taskID, turn.rooms, p.trtcFanout)
+	defer broadcast.close()
+
+	// Deltas are buffered in pending and emitted as one status update and artifact
+	// once chunkBatchSize characters have accumulated, reducing SSE event volume.
+	var pending strings.Builder
+	canceled := func() error {
+		p.addPartialArtifact(taskID, handle, fullResponse.String(), emitter.close(), req.Model)
+		if err := deadlineError(ctx, ctx.Err()); errors.Is(err, errDeadlineExceeded) || errors.Is(err, errTaskDurationExceeded) {
+			// The caller fails the task with the deadline message.
+			log.Printf("Task %s reached its time limit during OpenAI streaming: %v", taskID, err)
+			return err
+		}
+		log.Printf("Task %s canceled during OpenAI streaming: %v", taskID, ctx.Err())
+		_ = handle.UpdateStatus(protocol.TaskStateCanceled, nil)
+		return ctx.Err()
+	}
+	// stopped ends the stream after emitChunk failed: the task is canceled, or its
+	// artifacts repeatedly could not be delivered.
+	stopped := func(err error) error {
+		if errors.Is(err, errArtifactEmission) {
+			return err
+		}
+		return canceled()
+	}
+	// emitChunk fails if the task is canceled while waiting for buffer room, or once
+	// STREAM_ARTIFACT_FAILURE_LIMIT artifacts in a row have failed.
+	emitChunk := func() error {
+		if pending.Len() == 0 {
+			return nil
+		}
+		content := pending.String()
+		pending.Reset()
+		return emitter.send(ctx, streamChunk{
+			content:     content,
+			totalLength: fullResponse.Len(),
+			outputChars: outputChars,
+		})
+	}
 
 	for {
-		if err := ctx.Err(); err != nil {
-			log.Printf("Task %s canceled during OpenAI streaming: %v", taskID, err)
-			_ = handle.UpdateStatus(protocol.TaskStateCanceled, nil)
-			return err
+		var result streamResult
+		select {
+		case <-ctx.Done():
+			return canceled()
+		case <-emitter.failed:
+			return emitter.err()
+		case <-keepAlive:
+			if time.Since(lastActivity) >= p.keepAliveInterval {
+				// Prefer flushing buffered text over an empty keep-alive.
+				if pending.Len() > 0 {
+					if err := emitChunk(); err != nil {
+						return stopped(err)
+					}
+				} else {
+					sendKeepAlive(taskID, handle)
+				}
+				lastActivity = time.Now()
+			}
+			continue
+		case result = <-results:
 		}
 
-		response, err := stream.Recv()
+		response, err := result.response, result.err
 		if err != nil {
 			if err == io.EOF {
 				break
 			}
-			return fmt.Errorf("failed to receive OpenAI streaming response: %w", err)
+			return &streamBrokenError{
+				err:    served.annotate(fmt.Errorf("failed to receive OpenAI streaming response: %w", err)),
+				chunks: emitter.close(),
+			}
 		}
 
+		if len(response.Choices) == 0 {
+			continue
+		}
+		if reason := response.Choices[0].FinishReason; reason != "" {
+			turn.finishReason = reason
+		}
+		if deltas := response.Choices[0].Delta.ToolCalls; len(deltas) > 0 {
+			lastActivity = time.Now()
+			turn.emitted = true
+			for _, chunk := range toolCalls.write(deltas) {
+				if err := emitter.send(ctx, chunk); err != nil {
+					return stopped(err)
+				}
+			}
+		}
 		content := response.Choices[0].Delta.Content
 		if content == "" {
 			continue
 		}
+		lastActivity = time.Now()
+		latency.observe(lastActivity)
 
 		if !firstTokenReceived {
-			elapsed := time.Since(startTime)
-			log.Printf("Task %s: Time to first token: %v", taskID, elapsed)
+			timeToFirstToken = time.Since(startTime)
+			log.Printf("Task %s: Time to first token: %v", taskID, timeToFirstToken)
 			firstTokenReceived = true
+			turn.emitted = true
 		}
 
-		fullResponse.WriteString(content)
-
-		log.Printf("Task %s: Sending chunk %d, content length: %d",
-			taskID, chunkIndex+1, len(content))
-
-		statusMsg := protocol.NewMessage(
-			protocol.MessageRoleAgent,
-			[]protocol.Part{protocol.NewTextPart(content)},
-		)
-
-		if err := handle.UpdateStatus(protocol.TaskStateWorking, &statusMsg); err != nil {
-			log.Printf("Error updating progress status for task %s: %v", taskID, err)
+		content, target := handoffs.write(questions.write(content))
+		if p.maxOutputChars > 0 && outputChars+utf8.RuneCountInString(content) >= p.maxOutputChars {
+			content = truncateRunes(content, p.maxOutputChars-outputChars)
+			truncated = true
+			log.Printf("Task %s: Output reached MAX_OUTPUT_CHARS (%d), stopping stream", taskID, p.maxOutputChars)
 		}
-
-		chunkArtifact := protocol.Artifact{
-			Name:        stringPtr(fmt.Sprintf("Chunk %d", chunkIndex+1)),
-			Description: stringPtr("Streaming chunk from OpenAI"),
-			Index:       chunkIndex,
-			Parts:       []protocol.Part{protocol.NewTextPart(content)},
-			Append:      boolPtr(chunkIndex > 0),
-			Metadata: map[string]interface{}{
-				"timestamp":    time.Now().UnixNano(),
-				"chunk_size":   len(content),
-				"chunk_index":  chunkIndex,
-				"total_length": fullResponse.Len(),
-				"model":        p.openaiModel,
-				"is_streaming": true,
-			},
+		outputChars += utf8.RuneCountInString(content)
+		released, err := sentences.write(ctx, content)
+		if err != nil {
+			return err
 		}
+		released = post.write(released)
+		fullResponse.WriteString(released)
+		pending.WriteString(released)
+		broadcast.write(released)
 
-		if err := handle.AddArtifact(chunkArtifact); err != nil {
-			log.Printf("Error adding artifact for chunk %d of task %s: %v", chunkIndex+1, taskID, err)
+		if truncated {
+			break
+		}
+		if target != "" {
+			// The current persona's text is emitted under its name before the new persona starts.
+			rest, err := sentences.flush(ctx)
+			if err != nil {
+				return err
+			}
+			rest = post.write(rest) + post.flush(false)
+			fullResponse.WriteString(rest)
+			pending.WriteString(rest)
+			broadcast.write(rest)
+			if err := emitChunk(); err != nil {
+				return stopped(err)
+			}
+			chunks := emitter.close()
+			close(done)
+			stream.Close()
+			said := fullResponse.String()
+			p.usage.addTokens(ctx, estimateTokens(req.Messages, said[segmentStart:]))
+			segmentStart = len(said)
+			p.handOff(taskID, turn, target, strings.TrimSpace(said), len(said), chunks, handle)
+
+			req = p.buildCompletionRequest(turn)
+			req.Stream = true
+			done = make(chan struct{})
+			emitter = emitter.resume(req.Model)
+			next, err := p.openaiClient.CreateChatCompletionStream(ctx, req)
+			if err != nil {
+				return served.annotate(fmt.Errorf("failed to create OpenAI streaming request for handoff to %s: %w", target, err))
+			}
+			stream = next
+			results = receiveStream(stream, done)
+			continue
+		}
+		if utf8.RuneCountInString(pending.String()) >= p.chunkBatchSize {
+			if err := emitChunk(); err != nil {
+				return stopped(err)
+			}
+		}
+	}
+	rest := ""
+	if !truncated {
+		// Text held back as the possible start of a clarifying question or handoff
+		// marker was not one.
+		held, _ := handoffs.write(questions.flush())
+		held += handoffs.flush()
+		outputChars += utf8.RuneCountInString(held)
+		if rest, err = sentences.write(ctx, held); err != nil {
+			return err
 		}
-
-		chunkIndex++
+	}
+	flushed, err := sentences.flush(ctx)
+	if err != nil {
+		return err
+	}
+	rest += flushed
+	rest = post.write(rest) + post.flush(true)
+	fullResponse.WriteString(rest)
+	pending.WriteString(rest)
+	broadcast.write(rest)
+	if err := emitChunk(); err != nil {
+		return stopped(err)
+	}
+	// chunkIndex is the index of the next artifact, after the text chunks and tool calls.
+	chunkIndex := emitter.close()
+	if err := emitter.err(); err != nil {
+		return err
+	}
+	if chunkIndex == 0 && toolCalls.count() == 0 {
+		// Nothing reached the client, so the whole completion can be retried.
+		log.Printf("Task %s: OpenAI stream ended without content", taskID)
+		p.usage.addTokens(ctx, estimateTokens(req.Messages, ""))
+		if turn.finishReason == openai.FinishReasonContentFilter {
+			return served.annotate(errContentFiltered)
+		}
+		return served.annotate(errEmptyCompletion)
+	}
+	if len(latency.gaps) > 0 {
+		log.Printf("Task %s: Inter-token latency p50 %v, p95 %v over %d gaps",
+			taskID, latency.percentile(0.50), latency.percentile(0.95), len(latency.gaps))
+		p.latencyMetrics.observe(latency.gaps)
+	}
+	if emitter.dropped > 0 {
+		log.Printf("Task %s: %d chunks dropped because the client consumed the stream too slowly", taskID, emitter.dropped)
+	}
+	if turn.jsonOutput {
+		if err := validateJSONOutput(taskID, handle, fullResponse.String(), chunkIndex); err != nil {
+			return err
+		}
+		addStructuredArtifact(taskID, handle, fullResponse.String(), chunkIndex)
 	}
 
-	if chunkIndex > 0 {
+	turn.inputRequired = questions.asked()
+	if emitter.emitted > 0 {
 		lastChunkArtifact := protocol.Artifact{
-			Name:        stringPtr(fmt.Sprintf("Chunk %d", chunkIndex)),
+			Name:        stringPtr(fmt.Sprintf("Chunk %d", emitter.emitted)),
 			Description: stringPtr("Final chunk from OpenAI"),
-			Index:       chunkIndex - 1,
+			Index:       emitter.lastChunk,
 			Parts:       []protocol.Part{},
 			LastChunk:   boolPtr(true),
 			Metadata: map[string]interface{}{
-				"timestamp":     time.Now().UnixNano(),
-				"total_chunks":  chunkIndex,
-				"total_length":  fullResponse.Len(),
-				"model":         p.openaiModel,
-				"is_streaming":  true,
-				"is_last_chunk": true,
+				"timestamp":      time.Now().UnixNano(),
+				"total_chunks":   emitter.emitted,
+				"total_length":   fullResponse.Len(),
+				"model":          req.Model,
+				"is_streaming":   true,
+				"is_last_chunk":  true,
+				"truncated":      truncated,
+				"dropped_chunks": emitter.dropped,
+				"base_url":       served.get(),
+
+				"time_to_first_token_ms": durationMillis(timeToFirstToken),
 			},
 		}
+		for key, value := range latency.metadata() {
+			lastChunkArtifact.Metadata[key] = value
+		}
+		// The bundled client does not parse system_fingerprint from stream chunks.
+		seedMetadata(lastChunkArtifact.Metadata, turn.seed, "")
+		finishMetadata(lastChunkArtifact.Metadata, turn.finishReason)
+		served.requestIDMetadata(lastChunkArtifact.Metadata)
+		if turn.inputRequired {
+			lastChunkArtifact.Metadata[inputRequiredMetadataKey] = true
+			// Input-required is not a final state, so SSE streams end with this marker;
+			// the status goes first for clients to see it.
+			turn.reply = fullResponse.String()
+			if err := p.requestInput(taskID, turn, handle); err != nil {
+				return err
+			}
+		}
 		if err := handle.AddArtifact(lastChunkArtifact); err != nil {
 			log.Printf("Error adding final chunk marker for task %s: %v", taskID, err)
 		}
 	}
 
-	completeMessage := protocol.NewMessage(
-		protocol.MessageRoleAgent,
-		[]protocol.Part{
-			protocol.NewTextPart(
-				fmt.Sprintf("Processing complete. Received %d chunks.", chunkIndex))},
-	)
+	p.addSpeechArtifact(ctx, taskID, turn, fullResponse.String(), chunkIndex, handle)
+	turn.reply = fullResponse.String()
+	p.usage.addTokens(ctx, estimateTokens(req.Messages, turn.reply[segmentStart:]))
+	if turn.inputRequired {
+		return nil
+	}
+
+	completeText := fmt.Sprintf("Processing complete. Received %d chunks.", emitter.emitted)
+	if truncated {
+		completeText = fmt.Sprintf("Processing complete. Received %d chunks; output truncated at %d characters.",
+			emitter.emitted, p.maxOutputChars)
+	}
+	completeMessage := finishMessage(completeText, turn.finishReason)
 	if err := handle.UpdateStatus(protocol.TaskStateCompleted, &completeMessage); err != nil {
 		log.Printf("Error updating final status for task %s: %v", taskID, err)
 		return fmt.Errorf("failed to update final task status: %w", err)
@@ -227,49 +876,136 @@ func (p *streamingTaskProcessor) processWithOpenAIStreaming(
 	return nil
 }
 
+// addPartialArtifact preserves the text generated before a stream was canceled
+// so front-ends can still show it. Nothing is emitted if no text was generated.
+func (p *streamingTaskProcessor) addPartialArtifact(
+	taskID string,
+	handle taskmanager.TaskHandle,
+	partial string,
+	chunkCount int,
+	model string,
+) {
+	if partial == "" {
+		return
+	}
+	artifact := protocol.Artifact{
+		Name:        stringPtr("Partial Response"),
+		Description: stringPtr("Text generated before the task was canceled"),
+		Index:       chunkCount,
+		Parts:       []protocol.Part{protocol.NewTextPart(partial)},
+		LastChunk:   boolPtr(true),
+		Metadata: map[string]interface{}{
+			"timestamp":    time.Now().UnixNano(),
+			"total_chunks": chunkCount,
+			"total_length": len(partial),
+			"model":        model,
+			"is_streaming": true,
+			"canceled":     true,
+		},
+	}
+	if err := handle.AddArtifact(artifact); err != nil {
+		log.Printf("Error adding partial artifact for canceled task %s: %v", taskID, err)
+	}
+}
+
+// streamResult is a single outcome of stream.Recv().
+type streamResult struct {
+	response openai.ChatCompletionStreamResponse
+	err      error
+}
+
+// receiveStream reads from the OpenAI stream in a goroutine so the caller can
+// select on it alongside timers and cancellation. The goroutine exits after the
+// first error (including io.EOF) or once done is closed.
+func receiveStream(stream *openai.ChatCompletionStream, done <-chan struct{}) <-chan streamResult {
+	results := make(chan streamResult)
+	go func() {
+		for {
+			response, err := stream.Recv()
+			select {
+			case results <- streamResult{response: response, err: err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return results
+}
+
+// Processing phases reported in status metadata
+const (
+	phaseIntentDetection = "intent_detection"
+	phaseGeneration      = "generation"
+)
+
+// sendStatusMetadata emits a working status that carries only metadata. It has no
+// parts, so clients that treat working-status text as streamed content ignore it.
+func sendStatusMetadata(handle taskmanager.TaskHandle, metadata map[string]interface{}) error {
+	statusMessage := protocol.NewMessage(protocol.MessageRoleAgent, []protocol.Part{})
+	statusMessage.Metadata = metadata
+	statusMessage.Metadata["timestamp"] = time.Now().UnixNano()
+	return handle.UpdateStatus(protocol.TaskStateWorking, &statusMessage)
+}
+
+// sendPhase tells clients which processing phase the task has entered
+func sendPhase(taskID string, handle taskmanager.TaskHandle, phase string) {
+	if err := sendStatusMetadata(handle, map[string]interface{}{"phase": phase}); err != nil {
+		log.Printf("Error sending %s phase update for task %s: %v", phase, taskID, err)
+	}
+}
+
+// sendKeepAlive emits a content-free working status so idle SSE connections stay open
+func sendKeepAlive(taskID string, handle taskmanager.TaskHandle) {
+	if err := sendStatusMetadata(handle, map[string]interface{}{"keepalive": true}); err != nil {
+		log.Printf("Error sending keep-alive for task %s: %v", taskID, err)
+		return
+	}
+	log.Printf("Task %s: OpenAI stream idle, sent keep-alive", taskID)
+}
+
 // processWithOpenAINonStreaming sends the text to OpenAI API without streaming
 // and returns the complete response
 func (p *streamingTaskProcessor) processWithOpenAINonStreaming(
 		ctx context.Context,
-		text string,
-		taskID string,
+		turn *completionTurn,
 ) (string, error) {
-	intent, err := p.detectIntent(ctx, text, taskID)
-	if err != nil {
-		return "", fmt.Errorf("intent detection failed: %w", err)
-	}
-
-	req := openai.ChatCompletionRequest{
-		Model: p.openaiModel,
-		Messages: []openai.ChatCompletionMessage{
-			{
-				Role:    openai.ChatMessageRoleSystem,
-				Content: p.getAssistantPrompt(intent),
-			},
-			{
-				Role:    openai.ChatMessageRoleUser,
-				Content: text,
-			},
-		},
-	}
+	req := p.buildCompletionRequest(turn)
 
 	resp, err := p.openaiClient.CreateChatCompletion(ctx, req)
 	if err != nil {
-		return "", fmt.Errorf("failed to create OpenAI request: %w", err)
+		return "", servedByOf(ctx).annotate(fmt.Errorf("failed to create OpenAI request: %w", err))
 	}
+	p.usage.addUsage(ctx, resp.Usage)
+	turn.fingerprint = resp.SystemFingerprint
 
 	if len(resp.Choices) == 0 {
 		return "", fmt.Errorf("no choices in OpenAI response")
 	}
+	turn.finishReason = resp.Choices[0].FinishReason
 
-	return resp.Choices[0].Message.Content, nil
+	// OpenAI omits the matched stop sequence from the output, but some compatible
+	// backends echo it back; strip it so the artifact never ends with the marker.
+	content := resp.Choices[0].Message.Content
+	for _, stop := range p.stopSequences {
+		content = strings.TrimSuffix(content, stop)
+	}
+	if strings.TrimSpace(content) == "" {
+		if turn.finishReason == openai.FinishReasonContentFilter {
+			return "", servedByOf(ctx).annotate(errContentFiltered)
+		}
+		return "", servedByOf(ctx).annotate(errEmptyCompletion)
+	}
+	return p.moderator.check(ctx, content)
 }
 
 // processNonStreaming handles processing for non-streaming requests
 func (p *streamingTaskProcessor) processNonStreaming(
 		ctx context.Context,
 		taskID string,
-		text string,
+		turn *completionTurn,
 		handle taskmanager.TaskHandle,
 ) error {
 	initialMessage := protocol.NewMessage(
@@ -281,41 +1017,84 @@ func (p *streamingTaskProcessor) processNonStreaming(
 		return err
 	}
 
-	processedText, err := p.processWithOpenAINonStreaming(ctx, text, taskID)
+	ctx, served := withServedBy(ctx)
+	ctx, cache := withPromptCacheStats(ctx)
+	var processedText string
+	err := p.withHistoryCompaction(ctx, turn.session, turn, func() error {
+		return p.withEmptyRetry(taskID, func() error {
+			var err error
+			processedText, err = p.processWithOpenAINonStreaming(ctx, turn)
+			if err == nil {
+				processedText = clarifyReply(turn, processedText)
+				processedText, err = p.handOffReply(ctx, taskID, turn, handle, processedText)
+			}
+			return err
+		})
+	})
 	if err != nil {
+		if p.degraded.applies(ctx, turn, err) {
+			return p.respondDegraded(taskID, turn, handle, err)
+		}
+		err = deadlineError(ctx, err)
 		log.Printf("Error processing with OpenAI for task %s: %v", taskID, err)
 		failedMessage := protocol.NewMessage(
 			protocol.MessageRoleAgent,
-			[]protocol.Part{protocol.NewTextPart(fmt.Sprintf("Failed to process with OpenAI: %v", err))},
+			[]protocol.Part{protocol.NewTextPart(processingFailureText(err))},
 		)
 		_ = handle.UpdateStatus(protocol.TaskStateFailed, &failedMessage)
 		return err
 	}
+	processedText = p.postProcessorsFor(turn).apply(processedText, true)
+	if turn.jsonOutput {
+		if err := validateJSONOutput(taskID, handle, processedText, 0); err != nil {
+			failedMessage := protocol.NewMessage(
+				protocol.MessageRoleAgent,
+				[]protocol.Part{protocol.NewTextPart(err.Error())},
+			)
+			_ = handle.UpdateStatus(protocol.TaskStateFailed, &failedMessage)
+			return err
+		}
+	}
 
 	artifact := protocol.Artifact{
 		Name:        stringPtr("Processed Text"),
 		Description: stringPtr("Complete processed text from OpenAI"),
 		Index:       0,
-		Parts:       []protocol.Part{protocol.NewTextPart(processedText)},
+		Parts:       []protocol.Part{newContentPart(processedText, turn.contentType())},
 		LastChunk:   boolPtr(true),
 		Metadata: map[string]interface{}{
 			"timestamp":    time.Now().UnixNano(),
 			"total_length": len(processedText),
-			"model":        p.openaiModel,
+			"model":        p.turnModel(turn),
 			"is_streaming": false,
+			"base_url":     served.get(),
+			"content_type": turn.contentType(),
 		},
 	}
+	seedMetadata(artifact.Metadata, turn.seed, turn.fingerprint)
+	finishMetadata(artifact.Metadata, turn.finishReason)
+	cache.metadata(artifact.Metadata)
+	served.requestIDMetadata(artifact.Metadata)
+	if turn.inputRequired {
+		artifact.Metadata[inputRequiredMetadataKey] = true
+	}
+	streamFallbackMetadata(artifact.Metadata, turn)
 
 	if err := handle.AddArtifact(artifact); err != nil {
 		log.Printf("Error adding artifact for task %s: %v", taskID, err)
 	}
+	p.addSpeechArtifact(ctx, taskID, turn, processedText, 1, handle)
+	turn.reply = processedText
+	if len(turn.rooms) > 0 {
+		go func() {
+			logPushFailures(taskID, "reply", len(turn.rooms), pushToConversations(turn.rooms, processedText, p.trtcFanout))
+		}()
+	}
 
-	completeMessage := protocol.NewMessage(
-		protocol.MessageRoleAgent,
-		[]protocol.Part{
-			protocol.NewTextPart(
-				fmt.Sprintf("Processing complete. OpenAI response received."))},
-	)
+	if turn.inputRequired {
+		return p.requestInput(taskID, turn, handle)
+	}
+	completeMessage := finishMessage("Processing complete. OpenAI response received.", turn.finishReason)
 	if err := handle.UpdateStatus(protocol.TaskStateCompleted, &completeMessage); err != nil {
 		log.Printf("Error updating final status for task %s: %v", taskID, err)
 		return fmt.Errorf("failed to update final task status: %w", err)
@@ -325,31 +1104,75 @@ func (p *streamingTaskProcessor) processNonStreaming(
 	return nil
 }
 
-// extractText extracts the first text part from a message.
+// processingFailureText is the failed-status text for a generation error.
+// Moderation failures get their own fixed message, which never echoes the flagged text.
+func processingFailureText(err error) string {
+	if errors.Is(err, errContentFlagged) || errors.Is(err, errDeadlineExceeded) || errors.Is(err, errInvalidJSONOutput) ||
+		errors.Is(err, errEmptyCompletion) || errors.Is(err, errTaskDurationExceeded) || errors.Is(err, errContentFiltered) ||
+		errors.Is(err, errArtifactEmission) {
+		return err.Error()
+	}
+	return fmt.Sprintf("Failed to process with OpenAI: %v", err)
+}
+
+// textPartSeparator joins the text parts of a multi-part message.
+const textPartSeparator = "\n"
+
+// extractText joins all text parts of a message, so multi-part messages reach OpenAI in full.
+// Surrounding whitespace is trimmed, so a message whose text is all whitespace has none.
 func extractText(message protocol.Message) string {
+	return strings.TrimSpace(strings.Join(extractTextParts(message), textPartSeparator))
+}
+
+// extractTextParts returns the text of each text part of a message, in order,
+// skipping parts that are empty or only whitespace.
+func extractTextParts(message protocol.Message) []string {
+	var texts []string
 	for _, part := range message.Parts {
-		if p, ok := part.(protocol.TextPart); ok {
-			return p.Text
+		if p, ok := part.(protocol.TextPart); ok && strings.TrimSpace(p.Text) != "" {
+			texts = append(texts, p.Text)
 		}
 	}
-	return ""
+	return texts
 }
 
-// Helper functions for environment variables
-func getEnvOrDefault(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+// getEnvList returns the comma-separated values of key with surrounding whitespace and empty entries removed
+func getEnvList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
 	}
-	return defaultValue
+	return values
 }
 
-func getEnvIntOrDefault(key string, defaultValue int) int {
-	if value := os.Getenv(key); value != "" {
-		if intValue, err := strconv.Atoi(value); err == nil {
-			return intValue
+// getEnvMap parses key as comma-separated name=value pairs
+func getEnvMap(key string) map[string]string {
+	values := make(map[string]string)
+	for _, pair := range getEnvList(key) {
+		name, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			log.Printf("Warning: ignoring malformed %s entry %q, expected name=value", key, pair)
+			continue
 		}
+		values[strings.TrimSpace(name)] = strings.TrimSpace(value)
 	}
-	return defaultValue
+	return values
+}
+
+// truncateRunes returns at most n runes of s
+func truncateRunes(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
 }
 
 // Helper functions to create pointers
@@ -361,100 +1184,354 @@ func boolPtr(b bool) *bool {
 	return &b
 }
 
-// detectIntent determines which AI assistant the user wants to talk to
-func (p *streamingTaskProcessor) detectIntent(ctx context.Context, text, taskID string) (string, error) {
+// intentResult is the outcome of classifying a user message.
+type intentResult struct {
+	Persona string
+	// Matched is false when the classifier's reply was not a known persona and Persona is a fallback.
+	Matched bool
+	// Confidence is the model's probability for its reply, when log probabilities were requested and returned.
+	Confidence *float64
+}
+
+// classifyIntent asks the model which AI assistant the user wants to talk to, without side effects.
+// previous is the persona chosen on the session's previous turn ("" on the first turn);
+// when set, classification is sticky and only switches if the user clearly asks for the other assistant.
+// withLogProbs requests token log probabilities so a confidence can be reported.
+func (p *streamingTaskProcessor) classifyIntent(
+	ctx context.Context,
+	prompts *promptSet,
+	text string,
+	previous string,
+	withLogProbs bool,
+) (intentResult, error) {
+	if !prompts.hasPersona(previous) || prompts.isGuard(previous) {
+		// The persona may have been removed by a prompt reload since the previous turn,
+		// and a declined message should not keep the next one on the guard persona.
+		previous = ""
+	}
+	systemPrompt := prompts.intent
+	if previous != "" {
+		systemPrompt += fmt.Sprintf(`
+The user is already talking to %s in this conversation. Keep replying with "%s" unless the message clearly asks to talk to a different assistant.`,
+			previous, previous)
+	}
+
 	req := openai.ChatCompletionRequest{
 		Model: p.openaiModel,
 		Messages: []openai.ChatCompletionMessage{
 			{
-				Role: openai.ChatMessageRoleSystem,
-				Content: `You are an intent detection assistant. You need to determine which AI assistant the user wants to talk to.
-Options are:
-1. XiaoMei(小美): Female assistant, lively and cute personality, can solve female-related issues.
-2. XiaoShuai(小帅): Male assistant, sunny and cheerful personality, can solve male-related issues.
-Please only reply with "XiaoMei" or "XiaoShuai"`,
+				Role:    openai.ChatMessageRoleSystem,
+				Content: systemPrompt,
 			},
 			{
 				Role:    openai.ChatMessageRoleUser,
 				Content: text,
 			},
 		},
+		LogProbs: withLogProbs,
+		Seed:     p.seed,
 	}
 
 	resp, err := p.openaiClient.CreateChatCompletion(ctx, req)
 	if err != nil {
-		return "", fmt.Errorf("intent detection failed: %w", err)
+		return intentResult{}, fmt.Errorf("intent detection failed: %w", err)
+	}
+	p.usage.addUsage(ctx, resp.Usage)
+	if len(resp.Choices) == 0 {
+		return intentResult{}, fmt.Errorf("intent detection failed: no choices in OpenAI response")
 	}
 
-	intent := strings.TrimSpace(resp.Choices[0].Message.Content)
-	if intent != "XiaoMei" && intent != "XiaoShuai" {
-		log.Printf("Could not clearly identify intent, defaulting to XiaoMei")
-		intent = "XiaoMei"
+	choice := resp.Choices[0]
+	// Models sometimes quote the name or end it with a full stop.
+	result := intentResult{Persona: strings.Trim(choice.Message.Content, " \t\n\"'.。"), Matched: true}
+	if !prompts.hasPersona(result.Persona) {
+		result.Matched = false
+		if previous != "" {
+			log.Printf("Could not clearly identify intent, keeping previous persona %s", previous)
+			result.Persona = previous
+		} else {
+			result.Persona = prompts.defaultPersona()
+			log.Printf("Could not clearly identify intent, defaulting to %s", result.Persona)
+		}
 	} else {
-		log.Printf("Intent detection result: User wants to talk to %s", intent)
+		log.Printf("Intent detection result: User wants to talk to %s", result.Persona)
 	}
 
-	// Call TRTC API to update TTS voice based on detected intent
-	if intent == "XiaoMei" {
-		log.Printf("Starting TTS update for XiaoMei, taskid: %s", taskID)
-		if len(taskID) > 64 {
-			if err := UpdateAIConversationXiaoMei(taskID); err != nil {
-				log.Printf("Failed to update TTS for XiaoMei: %v", err)
-			} else {
-				log.Printf("Successfully updated TTS for XiaoMei")
-			}
-		} else {
-			log.Printf("Invalid taskID length for XiaoMei: %s", taskID)
+	if choice.LogProbs != nil && len(choice.LogProbs.Content) > 0 {
+		// The reply is the persona name, so its probability is the product of its token probabilities.
+		var sum float64
+		for _, token := range choice.LogProbs.Content {
+			sum += token.LogProb
 		}
+		confidence := 0.0
+		if result.Matched {
+			confidence = math.Exp(sum)
+		}
+		result.Confidence = &confidence
+	}
+
+	return result, nil
+}
+
+// detectIntent determines which AI assistant the user wants to talk to with the
+// configured Router. It has no side effects; see Process for the TRTC voice update
+// that follows. If routing fails or takes longer than intentTimeout, the default
+// persona is returned with fallback set, so the reply is still generated. An error
+// is only returned when ctx itself is done.
+func (p *streamingTaskProcessor) detectIntent(
+	ctx context.Context,
+	text string,
+	session routingSession,
+) (persona string, fallback bool, err error) {
+	detectCtx := ctx
+	if p.intentTimeout > 0 {
+		var cancel context.CancelFunc
+		detectCtx, cancel = context.WithTimeout(ctx, p.intentTimeout)
+		defer cancel()
+	}
+	if session.skill != "" {
+		return session.skill, false, nil
+	}
+	if persona := session.current(); session.answering && persona != "" {
+		// The answer to a clarifying question goes back to the persona that asked it.
+		return persona, false, nil
+	}
+	persona, err = p.router.Route(detectCtx, text, session)
+	if err == nil {
+		return persona, false, nil
+	}
+	if ctx.Err() != nil {
+		// The task was canceled or hit its own deadline; there is nothing left to answer.
+		return "", false, err
+	}
+	persona = session.prompts.defaultPersona()
+	if detectCtx.Err() != nil {
+		log.Printf("Intent detection timed out after %v, falling back to %s", p.intentTimeout, persona)
 	} else {
-		log.Printf("Starting TTS update for XiaoShuai, taskid: %s", taskID)
-		if len(taskID) > 64 {
-			if err := UpdateAIConversationXiaoShuai(taskID); err != nil {
-				log.Printf("Failed to update TTS for XiaoShuai: %v", err)
-			} else {
-				log.Printf("Successfully updated TTS for XiaoShuai")
-			}
-		} else {
-			log.Printf("Invalid taskID length for XiaoShuai: %s", taskID)
+		log.Printf("Intent detection failed, falling back to %s: %v", persona, err)
+	}
+	return persona, true, nil
+}
+
+// updateTRTCVoice switches the TRTC conversation's TTS voice to match the persona, logging the outcome.
+// Failures are not fatal: the text response is still produced with whatever voice is active.
+func updateTRTCVoice(taskID, persona string) {
+	log.Printf("Starting TTS update for %s, taskid: %s", persona, taskID)
+	if err := UpdateAIConversationForPersona(taskID, persona); err != nil {
+		if errors.Is(err, errNotTRTCTask) || errors.Is(err, errTRTCUnavailable) {
+			logSkippedTRTCCall("TTS update for "+persona, taskID, err)
+			return
 		}
+		log.Printf("Failed to update TTS for %s: %v", persona, err)
+		return
+	}
+	log.Printf("Successfully updated TTS for %s", persona)
+}
+
+// sendGreeting emits the persona's first-turn greeting as a working status and,
+// for TRTC conversations and the task's TRTC rooms, speaks it through TTS before
+// the reply is generated
+func (p *streamingTaskProcessor) sendGreeting(taskID, persona, greeting string, rooms []string, handle taskmanager.TaskHandle) {
+	greetingMessage := protocol.NewMessage(
+		protocol.MessageRoleAgent,
+		[]protocol.Part{protocol.NewTextPart(greeting)},
+	)
+	greetingMessage.Metadata = map[string]interface{}{
+		"greeting": true,
+		"persona":  persona,
+	}
+	if err := handle.UpdateStatus(protocol.TaskStateWorking, &greetingMessage); err != nil {
+		log.Printf("Error sending greeting for task %s: %v", taskID, err)
 	}
 
-	return intent, nil
+	conversations := trtcConversations(taskID, rooms)
+	if len(conversations) == 0 {
+		return
+	}
+	failures := pushToConversations(conversations, greeting, p.trtcFanout)
+	logPushFailures(taskID, "greeting", len(conversations), failures)
+	if len(failures) < len(conversations) {
+		log.Printf("Pushed %s greeting to %d TRTC conversation(s) for task %s", persona, len(conversations)-len(failures), taskID)
+	}
 }
 
-// getAssistantPrompt returns the system prompt for the specified assistant
-func (p *streamingTaskProcessor) getAssistantPrompt(intent string) string {
-	prompts := map[string]string{
-		"XiaoMei":   "You are an AI assistant named XiaoMei(小美). Keep the conversation casual, lively, and concise",
-		"XiaoShuai": "You are an AI assistant named XiaoShuai(小帅). Keep the conversation casual, humorous, and concise",
+// modelFor returns the model used to generate the persona's replies: its
+// PERSONA_MODELS override if one is set, otherwise the global model
+func (p *streamingTaskProcessor) modelFor(intent string) string {
+	if model := p.personaModels[intent]; model != "" {
+		return model
 	}
-	return prompts[intent]
+	return p.openaiModel
+}
+
+// nameWithholdingInstruction is appended to the system prompt of personas that must not disclose their name.
+const nameWithholdingInstruction = "Do not reveal, repeat or sign with your name. If asked who you are, say you are an AI assistant without giving a name."
+
+// selfDescriptionInstruction introduces the persona's description when PERSONA_SELF_DESCRIPTION is enabled.
+const selfDescriptionInstruction = "You are the following assistant; stay consistent with this description in tone and self-references:\n%s"
+
+// getAssistantPrompt returns the system prompt for the specified assistant, with any
+// {{.Variable}} placeholders filled from vars (the request's message metadata).
+// With PERSONA_SELF_DESCRIPTION the persona's description follows, except for the
+// guard and for personas whose description is only their ID. Personas that may not
+// disclose their name are told to keep it to themselves.
+func (p *streamingTaskProcessor) getAssistantPrompt(prompts *promptSet, intent string, vars map[string]interface{}) string {
+	prompt := prompts.renderPersona(intent, vars)
+	description := prompts.description(intent)
+	if p.selfDescription && description != "" && description != intent && !prompts.isGuard(intent) {
+		prompt += "\n\n" + fmt.Sprintf(selfDescriptionInstruction, description)
+	}
+	if !p.disclosesName(intent) {
+		prompt += "\n\n" + nameWithholdingInstruction
+	}
+	return prompt
+}
+
+// disclosesName reports whether the persona may tell the user its name: its
+// PERSONA_DISCLOSE_NAME setting if any, otherwise DISCLOSE_NAME
+func (p *streamingTaskProcessor) disclosesName(intent string) bool {
+	if disclose, ok := p.personaDiscloseName[intent]; ok {
+		return disclose
+	}
+	return p.discloseName
 }
 
 func main() {
 	// Load environment variables from .env file
-	if err := godotenv.Load(); err != nil {
-		log.Printf("Warning: Could not load .env file: %v", err)
+	envErr := godotenv.Load()
+
+	// Redact secrets before anything is logged.
+	var secrets []string
+	for _, name := range append(secretEnvVars, getEnvList("LOG_REDACT_ENV")...) {
+		secrets = append(secrets, os.Getenv(name))
+	}
+	logRedactor := newRedactingWriter(os.Stderr, secrets...)
+	log.SetOutput(logRedactor)
+	if envErr != nil {
+		log.Printf("Warning: Could not load .env file: %v", envErr)
 	}
 
-	// Get configuration from environment variables
-	host := getEnvOrDefault("SERVER_HOST", "localhost")
-	port := getEnvIntOrDefault("SERVER_PORT", 8080)
-	openaiModel := getEnvOrDefault("OPENAI_MODEL", "gpt-3.5-turbo")
-	baseURL := getEnvOrDefault("OPENAI_BASE_URL", "https://api.openai.com/v1")
-	openaiKey := os.Getenv("OPENAI_API_KEY")
+	cfg, err := LoadConfig()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	// Secrets from CONFIG_FILE are not in the environment the redactor was built from.
+	logRedactor.add(cfg.secrets()...)
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		log.Printf("Loaded configuration from %s", path)
+	}
+	if cfg.Profile != "" {
+		log.Printf("Using the %s configuration profile", cfg.Profile)
+	}
+	log.Printf("Effective configuration:\n%s", cfg.describe())
+	setTRTCSettings(cfg.TRTC)
 
-	if openaiKey == "" {
-		log.Fatal("OPENAI_API_KEY environment variable is required")
+	var apiAuth *apiKeyAuth
+	if cfg.Auth.Disabled {
+		log.Printf("Warning: API key authentication disabled by AUTH_DISABLED")
+	} else {
+		apiAuth, err = newAPIKeyAuth(strings.Join(cfg.Auth.APIKeys, ","), cfg.Auth.APIKeysFile)
+		if err != nil {
+			log.Fatalf("Failed to load API keys: %v", err)
+		}
+		if apiAuth == nil {
+			log.Printf("Warning: no API_KEYS or API_KEYS_FILE configured, the server accepts unauthenticated requests")
+		} else {
+			for _, key := range apiAuth.keys {
+				logRedactor.add(string(key))
+			}
+		}
+	}
+	if len(cfg.OpenAI.StopSequences) > 4 {
+		log.Printf("Warning: OpenAI accepts at most 4 stop sequences, got %d", len(cfg.OpenAI.StopSequences))
 	}
 
-	address := fmt.Sprintf("%s:%d", host, port)
-	serverURL := fmt.Sprintf("http://%s/", address)
+	useTLS := cfg.Server.TLSCertFile != ""
+	address := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
+	scheme := "http"
+	if useTLS {
+		scheme = "https"
+	}
+	// The agent card must advertise the externally reachable URL, which differs
+	// from the bind address behind a proxy or TLS terminator.
+	serverURL := cfg.Server.PublicURL
+	if serverURL == "" {
+		serverURL = fmt.Sprintf("%s://%s/", scheme, address)
+	}
 
-	config := openai.DefaultConfig(openaiKey)
-	config.BaseURL = baseURL
+	// OPENAI_BASE_URLS lists gateways to fail over between; the client is built for the
+	// first and the failover transport redirects requests when it is unreachable.
+	if len(cfg.OpenAI.BaseURLs) > 0 && cfg.OpenAI.BaseURL != "" {
+		log.Printf("Warning: OPENAI_BASE_URL is ignored because OPENAI_BASE_URLS is set")
+	}
+	endpoints := newBaseURLFailover(cfg.OpenAI.baseURLs(), withPromptCache(cfg.OpenAI.PromptCache,
+		withOpenAIProject(cfg.OpenAI.ProjectID, newOpenAITransport(cfg.HTTP))))
+	config := openai.DefaultConfig(cfg.OpenAI.APIKey)
+	config.BaseURL = endpoints.urls[0]
+	config.OrgID = cfg.OpenAI.OrgID
+	config.HTTPClient = &http.Client{Transport: endpoints}
 	openaiClient := openai.NewClientWithConfig(config)
 
+	var probe *endpointProbe
+	if cfg.OpenAI.StartupProbe {
+		probe = newEndpointProbe(endpoints, cfg.OpenAI.APIKey, cfg.OpenAI.scopeHeaders())
+		probe.start()
+	}
+
+	outputModerator, err := newModerator(cfg.OpenAI.ModerationMode, openAIModerationFilter(openaiClient))
+	if err != nil {
+		log.Fatalf("Invalid moderation settings: %v", err)
+	}
+	inputScanner, err := newInjectionScanner(cfg.Limits.InjectionPolicy, patternInjectionDetector)
+	if err != nil {
+		log.Fatalf("Invalid injection scan settings: %v", err)
+	}
+	replyProcessors, err := newPostProcessors(cfg.Streaming.PostProcessors, cfg.Streaming.Replacements, cfg.Streaming.Disclaimer)
+	if err != nil {
+		log.Fatalf("Invalid post-processing settings: %v", err)
+	}
+	recorder, err := newTaskRecorder(cfg.Server.RecordTasks, cfg.Server.TaskLogDir)
+	if err != nil {
+		log.Fatalf("Failed to set up task recording: %v", err)
+	}
+	speechTranscriber, err := newTranscriber(cfg.Speech.STTProvider, openaiClient, cfg.Speech.STTModel)
+	if err != nil {
+		log.Fatalf("Invalid speech-to-text settings: %v", err)
+	}
+	synthesizer, err := newSpeechSynthesizer(cfg.Speech.Provider, openaiClient, cfg.Speech.Model)
+	if err != nil {
+		log.Fatalf("Invalid speech synthesis settings: %v", err)
+	}
+	trtcFailure, err := newTRTCFailureHandler(cfg.TRTC.FailureAction, cfg.TRTC.FailureApology)
+	if err != nil {
+		log.Fatalf("Invalid TRTC failure settings: %v", err)
+	}
+	jsonPersonas := make(map[string]bool)
+	for _, persona := range cfg.Personas.JSON {
+		jsonPersonas[persona] = true
+	}
+	speechPersonas := make(map[string]bool)
+	for _, persona := range cfg.Speech.Personas {
+		speechPersonas[persona] = true
+	}
+	speech := &speechSettings{
+		synthesizer:  synthesizer,
+		personas:     speechPersonas,
+		voices:       cfg.Speech.PersonaVoices,
+		defaultVoice: cfg.Speech.Voice,
+	}
+	sampling, personaSampling := cfg.sampling()
+	modelAllowlist := make(map[string]bool)
+	for _, model := range cfg.OpenAI.ModelAllowlist {
+		modelAllowlist[model] = true
+	}
+
+	features := agentFeatures{
+		streaming:         !cfg.Streaming.ForceNonStreaming,
+		pushNotifications: cfg.Push.Enabled,
+		audioInput:        speechTranscriber != nil,
+		audioOutput:       synthesizer != nil,
+	}
 	description := "A2A streaming example server that processes text using OpenAI API"
 	agentCard := server.AgentCard{
 		Name:        "OpenAI Text Processor",
@@ -464,12 +1541,9 @@ func main() {
 		Provider: &server.AgentProvider{
 			Name: "A2A-Go Examples",
 		},
-		Capabilities: server.AgentCapabilities{
-			Streaming:              true,
-			StateTransitionHistory: true,
-		},
-		DefaultInputModes:  []string{string(protocol.PartTypeText)},
-		DefaultOutputModes: []string{string(protocol.PartTypeText)},
+		Capabilities:       buildCapabilities(features),
+		DefaultInputModes:  features.inputModes(),
+		DefaultOutputModes: features.outputModes(),
 		Skills: []server.AgentSkill{
 			{
 				ID:          "openai_processor",
@@ -481,36 +1555,197 @@ func main() {
 					"Write a short poem about artificial intelligence",
 					"What are the main features of Go programming language?",
 				},
-				InputModes:  []string{string(protocol.PartTypeText)},
-				OutputModes: []string{string(protocol.PartTypeText)},
+				InputModes:  features.inputModes(),
+				OutputModes: features.outputModes(),
 			},
 		},
 	}
 
+	prompts, err := newPromptStore(cfg.Personas.PromptsDir, cfg.Personas.Guard, cfg.Personas.IntentPrompt,
+		cfg.Personas.DefaultLocale, cfg.Personas.Locales)
+	if err != nil {
+		log.Fatalf("Failed to load prompts: %v", err)
+	}
+	if locales := prompts.snapshot().localeNames(); len(locales) > 0 {
+		log.Printf("Prompt locales: %s (default), %s", cfg.Personas.DefaultLocale, strings.Join(locales, ", "))
+	}
+	stopWatching := make(chan struct{})
+	defer close(stopWatching)
+	if cfg.Personas.HotReload {
+		if cfg.Personas.PromptsDir == "" {
+			log.Printf("Warning: PROMPTS_HOT_RELOAD ignored because PROMPTS_DIR is not set")
+		} else if err := prompts.watch(stopWatching); err != nil {
+			log.Fatalf("Failed to start prompt hot reload: %v", err)
+		} else {
+			log.Printf("Watching %s for prompt changes", cfg.Personas.PromptsDir)
+		}
+	}
+
 	processor := &streamingTaskProcessor{
 		openaiClient: openaiClient,
-		openaiModel:  openaiModel,
+		openaiModel:  cfg.OpenAI.Model,
+		sessions:     newSessionStore(),
+		tasks:        newTaskRegistry(),
+		sessionIDs:   newSessionIDStrategy(cfg.Session),
+		agentCard:    agentCard,
+		skills:       newSkillFilter(cfg.Server.EnabledSkills),
+
+		latencyMetrics: newLatencyHistogram(cfg.Server.Metrics),
+
+		personaModels:       cfg.Personas.Models,
+		modelAllowlist:      modelAllowlist,
+		discloseName:        cfg.Personas.DiscloseName,
+		personaDiscloseName: cfg.Personas.DiscloseNames,
+		selfDescription:     cfg.Personas.SelfDescription,
+		personaHandoff:      cfg.Personas.Handoff,
+		clarifyingQuestions: cfg.Personas.Clarify,
+
+		interruptOnNewInput:        cfg.TRTC.InterruptOnInput,
+		personaInterruptOnNewInput: cfg.Personas.InterruptOnInput,
+
+		sampling:         sampling,
+		maxTaskDuration:  cfg.Limits.MaxTaskDuration,
+		maxClientTimeout: cfg.Limits.MaxClientTimeout,
+		intentTimeout:    cfg.Limits.IntentTimeout,
+		jsonPersonas:     jsonPersonas,
+		usage: newUsageStore(cfg.Limits.DailyTokenQuota,
+			cfg.Limits.DailyRequestQuota, cfg.Limits.QuotaUnlimitedKeys),
+		historyTurns:        cfg.History.MaxTurns,
+		autoSummarize:       cfg.History.AutoSummarize,
+		summaryModel:        cfg.History.SummaryModel,
+		trtcFailure:         trtcFailure,
+		personaSampling:     personaSampling,
+		limiter:             newLLMLimiter(cfg.Limits.MaxConcurrentLLMCalls, cfg.Limits.LLMQueueTimeout, cfg.Limits.LLMBusyPolicy),
+		intentCache:         newIntentCache(cfg.Personas.IntentCacheSize, cfg.Personas.IntentCacheTTL),
+		keyPriorities:       cfg.Limits.APIKeyPriorities,
+		priorityMetadataKey: cfg.Limits.PriorityMetadataKey,
+		sessionLimiter: newSessionLimiter(cfg.Limits.SessionConcurrency, cfg.Limits.SessionQueueTimeout,
+			cfg.Limits.SessionBusyPolicy),
+		moderator:      outputModerator,
+		postProcessors: replyProcessors,
+		injection:      inputScanner,
+		recorder:       recorder,
+		trtcFanout:     cfg.TRTC.FanoutLimit,
+		degraded: newDegradedResponder(cfg.OpenAI.DegradedMode, cfg.OpenAI.DegradedResponse,
+			cfg.Personas.DegradedResponses),
+		batchConcurrency: cfg.Limits.BatchConcurrency,
+		batchMaxItems:    cfg.Limits.BatchMaxItems,
+		transcriber:      speechTranscriber,
+		speech:           speech,
+		prompts:          prompts,
+		idempotency:      newIdempotencyCache(cfg.Limits.IdempotencyTTL),
+
+		promptMetadataKeys:      cfg.Personas.MetadataKeys,
+		stopSequences:           cfg.OpenAI.StopSequences,
+		keepAliveInterval:       cfg.Streaming.KeepAliveInterval,
+		chunkBatchSize:          cfg.Streaming.ChunkBatchSize,
+		streamBufferSize:        cfg.Streaming.BufferSize,
+		streamBufferPolicy:      cfg.Streaming.BufferPolicy,
+		artifactFailureLimit:    cfg.Streaming.EmitFailureLimit,
+		streamFallback:          cfg.Streaming.Fallback,
+		streamFallbackMaxChunks: cfg.Streaming.FallbackMaxChunks,
+		maxOutputChars:          cfg.Streaming.MaxOutputChars,
+		maxInputChars:           cfg.Limits.MaxInputChars,
+		inputOverflowPolicy:     cfg.Limits.InputOverflowPolicy,
+		maxTokens:               cfg.OpenAI.MaxTokens,
+		seed:                    cfg.OpenAI.Seed,
+		promptCache:             cfg.OpenAI.PromptCache,
+		emptyOutputRetries:      cfg.OpenAI.EmptyOutputRetries,
+		forceStreaming:          cfg.Streaming.ForceStreaming,
+		forceNonStreaming:       cfg.Streaming.ForceNonStreaming,
+	}
+
+	if processor.router, err = newRouter(cfg.Personas.Router, cfg.Personas.RouterKeywords, processor); err != nil {
+		log.Fatalf("Invalid routing settings: %v", err)
 	}
 
 	taskManager, err := taskmanager.NewMemoryTaskManager(processor)
 	if err != nil {
 		log.Fatalf("Failed to create task manager: %v", err)
 	}
+	if cfg.Push.Enabled {
+		processor.push = newPushNotifier(taskManager, cfg.Push.SigningSecret, cfg.Push.MaxRetries)
+	}
+	processor.taskManager = taskManager
+
+	cors := newCORSConfig(
+		strings.Join(cfg.Server.CORSAllowedOrigins, ","),
+		cfg.Server.CORSAllowedMethods,
+		cfg.Server.CORSAllowedHeaders,
+	)
 
-	srv, err := server.NewA2AServer(agentCard, taskManager)
+	// CORS is handled by our own middleware; the A2A server's built-in CORS allows every origin.
+	guardedTaskManager := &duplicateTaskGuard{TaskManager: taskManager, processor: processor}
+	srv, err := server.NewA2AServer(agentCard, guardedTaskManager, server.WithCORSEnabled(false))
 	if err != nil {
 		log.Fatalf("Failed to create A2A server: %v", err)
 	}
 
+	a2aHandler := srv.Handler()
+	signatures := newSignatureVerifier(cfg.Auth.SigningSecret, cfg.Auth.SignatureTolerance)
+	mux := http.NewServeMux()
+	mux.Handle("POST /classify", apiAuth.wrap(signatures.wrap(http.HandlerFunc(processor.handleClassify))))
+	mux.Handle("POST /complete", apiAuth.wrap(signatures.wrap(http.HandlerFunc(processor.handleComplete))))
+	mux.Handle("POST /batch", apiAuth.wrap(signatures.wrap(http.HandlerFunc(processor.handleBatch))))
+	mux.HandleFunc("GET /readyz", readinessHandler(probe, endpoints, cfg.OpenAI.DegradedMode))
+	if cfg.Server.Metrics {
+		mux.Handle("GET /metrics", apiAuth.wrap(http.HandlerFunc(processor.latencyMetrics.handleMetrics)))
+	}
+	mux.HandleFunc("POST /trtc/push", requireBearerToken(cfg.Auth.AdminToken, handleTRTCPush))
+	mux.HandleFunc("POST /admin/sessions/{id}/cancel", requireBearerToken(cfg.Auth.AdminToken, processor.handleCancelSession))
+	mux.HandleFunc("GET /admin/sessions/{id}/transcript", requireBearerToken(cfg.Auth.AdminToken, processor.handleSessionTranscript))
+	mux.HandleFunc("POST /admin/validate-config", requireBearerToken(cfg.Auth.AdminToken, handleValidateConfig))
+	mux.HandleFunc("GET /admin/intent-cache", requireBearerToken(cfg.Auth.AdminToken, processor.intentCache.handleIntentCache))
+	mux.HandleFunc("GET /admin/queue", requireBearerToken(cfg.Auth.AdminToken, processor.limiter.handleQueue))
+	mux.HandleFunc("GET /admin/tasks", requireBearerToken(cfg.Auth.AdminToken, processor.tasks.handleActiveTasks))
+	mux.HandleFunc("GET /admin/tasks/{id}", requireBearerToken(cfg.Auth.AdminToken, processor.tasks.handleActiveTask))
+	mux.HandleFunc("GET /admin/tasks/{id}/log", requireBearerToken(cfg.Auth.AdminToken, recorder.handleTaskLog))
+	if cfg.Server.WebSocket {
+		mux.Handle("GET /ws", apiAuth.wrap(processor.sessionIDs.wrap(newWebSocketTransport(guardedTaskManager, cors))))
+		log.Printf("WebSocket transport enabled at /ws")
+	}
+	// The agent card stays public so clients can discover the server before authenticating.
+	mux.Handle(protocol.AgentCardPath, agentCardHandler(agentCard, prompts, processor.skills))
+	mux.Handle("/", apiAuth.wrap(signatures.wrap(processor.sessionIDs.wrap(withIdempotencyKey(a2aHandler)))))
+
+	handler := cors.wrap(mux)
+	if cfg.Server.Compression {
+		handler = withCompression(handler)
+	}
+	if cfg.Server.AccessLog {
+		handler = withAccessLog(handler)
+	}
+	httpServer := &http.Server{
+		Addr:         address,
+		Handler:      handler,
+		ReadTimeout:  serverReadTimeout,
+		WriteTimeout: serverWriteTimeout,
+		IdleTimeout:  serverIdleTimeout,
+	}
+
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
 
+	// Bind before serving so the warmup only starts once the server is reachable.
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		log.Fatalf("Server error: %v", err)
+	}
 	go func() {
-		log.Printf("Starting streaming server on %s...", address)
-		if err := srv.Start(address); err != nil {
+		log.Printf("Starting streaming server on %s (%s), advertised as %s...", address, scheme, serverURL)
+		var err error
+		if useTLS {
+			err = httpServer.ServeTLS(listener, cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile)
+		} else {
+			err = httpServer.Serve(listener)
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatalf("Server error: %v", err)
 		}
 	}()
+	if cfg.Server.WarmupOnStart {
+		go processor.warmup()
+	}
 
 	sig := <-sigChan
 	log.Printf("Received signal %v, shutting down server...", sig)
@@ -518,7 +1753,7 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	if err := srv.Stop(ctx); err != nil {
+	if err := httpServer.Shutdown(ctx); err != nil {
 		log.Fatalf("Error during server shutdown: %v", err)
 	}
 
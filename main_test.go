@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+	"trpc.group/trpc-go/trpc-a2a-go/taskmanager"
+)
+
+// fakeHandle records the updates of a task.
+type fakeHandle struct {
+	mu        sync.Mutex
+	states    []protocol.TaskState
+	artifacts []protocol.Artifact
+}
+
+var _ taskmanager.TaskHandle = (*fakeHandle)(nil)
+
+func (h *fakeHandle) UpdateStatus(state protocol.TaskState, msg *protocol.Message) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.states = append(h.states, state)
+	return nil
+}
+
+func (h *fakeHandle) AddArtifact(artifact protocol.Artifact) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.artifacts = append(h.artifacts, artifact)
+	return nil
+}
+
+func (h *fakeHandle) IsStreamingRequest() bool { return true }
+
+func (h *fakeHandle) artifactCount() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.artifacts)
+}
+
+// streamServer serves chat completion streams by calling write with a function
+// that sends one chunk; the stream ends with [DONE] when write returns.
+func streamServer(t *testing.T, write func(r *http.Request, send func(openai.ChatCompletionStreamResponse))) *openai.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		flusher := w.(http.Flusher)
+		write(r, func(chunk openai.ChatCompletionStreamResponse) {
+			data, _ := json.Marshal(chunk)
+			fmt.Fprintf(w, "data: %s\n\n", data)
+			flusher.Flush()
+		})
+		fmt.Fprint(w, "data: [DONE]\n\n")
+		flusher.Flush()
+	}))
+	t.Cleanup(srv.Close)
+	config := openai.DefaultConfig("test")
+	config.BaseURL = srv.URL
+	return openai.NewClientWithConfig(config)
+}
+
+// textDelta is a stream chunk carrying content.
+func textDelta(content string) openai.ChatCompletionStreamResponse {
+	return openai.ChatCompletionStreamResponse{Choices: []openai.ChatCompletionStreamChoice{
+		{Delta: openai.ChatCompletionStreamChoiceDelta{Content: content}},
+	}}
+}
+
+// testProcessor returns a processor with the default settings using client.
+func testProcessor(t *testing.T, client *openai.Client) *streamingTaskProcessor {
+	t.Helper()
+	return &streamingTaskProcessor{
+		openaiClient:     client,
+		sessions:         newSessionStore(),
+		tasks:            newTaskRegistry(),
+		chunkBatchSize:   1,
+		streamBufferSize: 16,
+	}
+}
+
+// testTurn returns a turn for text answered by the default persona.
+func testTurn(t *testing.T, text string) *completionTurn {
+	t.Helper()
+	store, err := newPromptStore("", "", "", defaultLocale, nil)
+	if err != nil {
+		t.Fatalf("newPromptStore: %v", err)
+	}
+	prompts := store.snapshot()
+	return &completionTurn{text: text, prompts: prompts, intent: prompts.defaultPersona()}
+}
+
+// runStream streams turn with p and returns the recorded handle.
+func runStream(t *testing.T, ctx context.Context, p *streamingTaskProcessor, turn *completionTurn) (*fakeHandle, error) {
+	t.Helper()
+	handle := &fakeHandle{}
+	err := p.processWithOpenAIStreaming(ctx, "task-1", turn, handle)
+	return handle, err
+}
+
+// completionServer answers chat completions with the content reply returns for the
+// request, finished by finish_reason stop.
+func completionServer(t *testing.T, reply func(req openai.ChatCompletionRequest) string) *openai.Client {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode completion request: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{
+			{
+				Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: reply(req)},
+				FinishReason: openai.FinishReasonStop,
+			},
+		}})
+	}))
+	t.Cleanup(srv.Close)
+	config := openai.DefaultConfig("test")
+	config.BaseURL = srv.URL
+	return openai.NewClientWithConfig(config)
+}
+
+func TestExtractText(t *testing.T) {
+	tests := []struct {
+		name  string
+		parts []protocol.Part
+		want  string
+	}{
+		{"single part", []protocol.Part{protocol.NewTextPart("hello")}, "hello"},
+		{"multiple parts", []protocol.Part{
+			protocol.NewTextPart("first"),
+			protocol.FilePart{Type: protocol.PartTypeFile},
+			protocol.NewTextPart("second"),
+		}, "first\nsecond"},
+		{"no text part", []protocol.Part{protocol.FilePart{Type: protocol.PartTypeFile}}, ""},
+	}
+	for _, test := range tests {
+		message := protocol.NewMessage(protocol.MessageRoleUser, test.parts)
+		if got := extractText(message); got != test.want {
+			t.Errorf("%s: extractText = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestProcessTreatsBlankTextAsNoText(t *testing.T) {
+	calls := 0
+	client := completionServer(t, func(openai.ChatCompletionRequest) string {
+		calls++
+		return "XiaoMei"
+	})
+	for _, text := range []string{"", " \t\n ", "　"} {
+		p := testProcessor(t, client)
+		handle := &fakeHandle{}
+		message := protocol.NewMessage(protocol.MessageRoleUser,
+			[]protocol.Part{protocol.NewTextPart(text), protocol.NewTextPart("  ")})
+		err := p.Process(context.Background(), "task-1", message, handle)
+		if err == nil || err.Error() != "input message must contain text or audio" {
+			t.Errorf("Process(%q) error = %v", text, err)
+		}
+		if len(handle.states) != 1 || handle.states[0] != protocol.TaskStateFailed {
+			t.Errorf("Process(%q) states = %v, want [failed]", text, handle.states)
+		}
+	}
+	if calls != 0 {
+		t.Errorf("blank input made %d OpenAI calls", calls)
+	}
+
+	valid := protocol.NewMessage(protocol.MessageRoleUser,
+		[]protocol.Part{protocol.NewTextPart("  "), protocol.NewTextPart("  hello\n")})
+	if got := extractText(valid); got != "hello" {
+		t.Errorf("extractText = %q, want %q", got, "hello")
+	}
+}
+
+func TestAssistantPromptNameDisclosure(t *testing.T) {
+	prompts := testTurn(t, "").prompts
+	tests := []struct {
+		name     string
+		global   bool
+		personas map[string]bool
+		withheld map[string]bool
+	}{
+		{"disclosed by default", true, nil, map[string]bool{"XiaoMei": false, "XiaoShuai": false}},
+		{"withheld globally", false, nil, map[string]bool{"XiaoMei": true, "XiaoShuai": true}},
+		{"persona withholds", true, map[string]bool{"XiaoShuai": false}, map[string]bool{"XiaoMei": false, "XiaoShuai": true}},
+		{"persona overrides global", false, map[string]bool{"XiaoMei": true}, map[string]bool{"XiaoMei": false, "XiaoShuai": true}},
+	}
+	for _, test := range tests {
+		p := testProcessor(t, nil)
+		p.discloseName = test.global
+		p.personaDiscloseName = test.personas
+		for persona, withheld := range test.withheld {
+			prompt := p.getAssistantPrompt(prompts, persona, nil)
+			if got := strings.HasSuffix(prompt, nameWithholdingInstruction); got != withheld {
+				t.Errorf("%s: %s prompt withholds the name = %v, want %v", test.name, persona, got, withheld)
+			}
+		}
+	}
+}
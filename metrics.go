@@ -0,0 +1,77 @@
+// Prometheus metrics endpoint for the inter-token latency of streamed replies
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// interTokenLatencyMetric is the name of the histogram served at /metrics.
+const interTokenLatencyMetric = "a2a_inter_token_latency_seconds"
+
+// interTokenLatencyBuckets are the histogram's bucket upper bounds in seconds.
+var interTokenLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+// latencyHistogram is a Prometheus histogram of the gaps between content deltas of
+// every streamed reply, enabled with METRICS_ENABLED. A nil *latencyHistogram
+// records nothing.
+type latencyHistogram struct {
+	mu sync.Mutex
+	// counts holds the observations per bucket, the last one for gaps above every bound.
+	counts []uint64
+	sum    float64
+	total  uint64
+}
+
+// newLatencyHistogram returns nil unless enabled
+func newLatencyHistogram(enabled bool) *latencyHistogram {
+	if !enabled {
+		return nil
+	}
+	return &latencyHistogram{counts: make([]uint64, len(interTokenLatencyBuckets)+1)}
+}
+
+// observe adds the gaps measured over one stream
+func (h *latencyHistogram) observe(gaps []time.Duration) {
+	if h == nil || len(gaps) == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, gap := range gaps {
+		seconds := gap.Seconds()
+		bucket := len(interTokenLatencyBuckets)
+		for i, bound := range interTokenLatencyBuckets {
+			if seconds <= bound {
+				bucket = i
+				break
+			}
+		}
+		h.counts[bucket]++
+		h.sum += seconds
+		h.total++
+	}
+}
+
+// handleMetrics serves the histogram in the Prometheus text exposition format
+func (h *latencyHistogram) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	h.mu.Lock()
+	counts := append([]uint64(nil), h.counts...)
+	sum, total := h.sum, h.total
+	h.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprintf(w, "# HELP %s Gap between successive content deltas of streamed replies.\n", interTokenLatencyMetric)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", interTokenLatencyMetric)
+	var cumulative uint64
+	for i, bound := range interTokenLatencyBuckets {
+		cumulative += counts[i]
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", interTokenLatencyMetric, strconv.FormatFloat(bound, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", interTokenLatencyMetric, total)
+	fmt.Fprintf(w, "%s_sum %s\n", interTokenLatencyMetric, strconv.FormatFloat(sum, 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count %d\n", interTokenLatencyMetric, total)
+}
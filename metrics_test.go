@@ -0,0 +1,44 @@
+package main
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLatencyHistogramExposition(t *testing.T) {
+	h := newLatencyHistogram(true)
+	h.observe([]time.Duration{3 * time.Millisecond, 40 * time.Millisecond, 50 * time.Millisecond, 7 * time.Second})
+	h.observe(nil)
+
+	rec := httptest.NewRecorder()
+	h.handleMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	for _, line := range []string{
+		"# TYPE a2a_inter_token_latency_seconds histogram",
+		`a2a_inter_token_latency_seconds_bucket{le="0.005"} 1`,
+		`a2a_inter_token_latency_seconds_bucket{le="0.025"} 1`,
+		`a2a_inter_token_latency_seconds_bucket{le="0.05"} 3`,
+		`a2a_inter_token_latency_seconds_bucket{le="5"} 3`,
+		`a2a_inter_token_latency_seconds_bucket{le="+Inf"} 4`,
+		"a2a_inter_token_latency_seconds_sum 7.093",
+		"a2a_inter_token_latency_seconds_count 4",
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("metrics are missing %q:\n%s", line, body)
+		}
+	}
+	if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", got)
+	}
+}
+
+func TestDisabledLatencyHistogramRecordsNothing(t *testing.T) {
+	h := newLatencyHistogram(false)
+	if h != nil {
+		t.Fatal("histogram created without METRICS_ENABLED")
+	}
+	h.observe([]time.Duration{time.Millisecond})
+}
@@ -0,0 +1,351 @@
+// HTTP middleware for the server's endpoints
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// contextKey namespaces values this server stores in request contexts.
+type contextKey string
+
+// apiKeyContextKey holds the API key that authenticated the request.
+const apiKeyContextKey contextKey = "apiKey"
+
+// idempotencyKeyContextKey holds the request's Idempotency-Key header.
+const idempotencyKeyContextKey contextKey = "idempotencyKey"
+
+// apiKeyHeader is the alternative to "Authorization: Bearer <key>" for API key auth.
+const apiKeyHeader = "X-API-Key"
+
+// apiKeyAuth enforces that requests carry one of a fixed set of API keys.
+// A nil *apiKeyAuth lets every request through.
+type apiKeyAuth struct {
+	keys [][]byte
+}
+
+// newAPIKeyAuth builds API key auth from a comma-separated key list and/or a file
+// with one key per line (blank lines and # comments are ignored).
+// It returns nil when no keys are configured.
+func newAPIKeyAuth(keyList, keysFile string) (*apiKeyAuth, error) {
+	var keys [][]byte
+	for _, key := range strings.Split(keyList, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, []byte(key))
+		}
+	}
+
+	if keysFile != "" {
+		file, err := os.Open(keysFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open API keys file: %w", err)
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			key := strings.TrimSpace(scanner.Text())
+			if key == "" || strings.HasPrefix(key, "#") {
+				continue
+			}
+			keys = append(keys, []byte(key))
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read API keys file: %w", err)
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	return &apiKeyAuth{keys: keys}, nil
+}
+
+// valid reports whether key matches one of the configured keys
+func (a *apiKeyAuth) valid(key string) bool {
+	if key == "" {
+		return false
+	}
+	matched := 0
+	for _, configured := range a.keys {
+		matched |= subtle.ConstantTimeCompare([]byte(key), configured)
+	}
+	return matched == 1
+}
+
+// wrap rejects requests without a valid API key with 401 before they reach next.
+// CORS preflight requests carry no credentials and are passed through.
+func (a *apiKeyAuth) wrap(next http.Handler) http.Handler {
+	if a == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := bearerToken(r)
+		if key == "" {
+			key = strings.TrimSpace(r.Header.Get(apiKeyHeader))
+		}
+		if !a.valid(key) {
+			log.Printf("Rejected unauthenticated %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "missing or invalid API key")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyContextKey, key)))
+	})
+}
+
+// apiKeyFromContext returns the API key that authenticated the request, or "" if auth is disabled
+func apiKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(apiKeyContextKey).(string)
+	return key
+}
+
+// withIdempotencyKey makes the Idempotency-Key request header available to the task processor
+func withIdempotencyKey(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if key := strings.TrimSpace(r.Header.Get("Idempotency-Key")); key != "" {
+			r = r.WithContext(context.WithValue(r.Context(), idempotencyKeyContextKey, key))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// idempotencyKeyFromContext returns the request's Idempotency-Key header, or ""
+func idempotencyKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKeyContextKey).(string)
+	return key
+}
+
+// bearerToken returns the token from an "Authorization: Bearer <token>" header, or ""
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > len("Bearer ") && strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return strings.TrimSpace(header[len("Bearer "):])
+	}
+	return ""
+}
+
+// requireBearerToken rejects requests whose bearer token does not match token.
+// An empty token means the endpoint is not configured, so every request is refused.
+func requireBearerToken(token string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			writeJSONError(w, http.StatusForbidden, "endpoint disabled: no admin token configured")
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// corsConfig controls which browser origins may call the server.
+type corsConfig struct {
+	allowedOrigins map[string]bool
+	allowAll       bool
+	allowedMethods string
+	allowedHeaders string
+}
+
+// newCORSConfig parses comma-separated CORS settings. With no origins configured it
+// returns nil, leaving the server same-origin only; "*" must be listed explicitly.
+func newCORSConfig(origins, methods, headers string) *corsConfig {
+	cfg := &corsConfig{
+		allowedOrigins: make(map[string]bool),
+		allowedMethods: methods,
+		allowedHeaders: headers,
+	}
+	for _, origin := range strings.Split(origins, ",") {
+		origin = strings.TrimSpace(origin)
+		switch origin {
+		case "":
+		case "*":
+			cfg.allowAll = true
+		default:
+			cfg.allowedOrigins[strings.TrimSuffix(origin, "/")] = true
+		}
+	}
+	if !cfg.allowAll && len(cfg.allowedOrigins) == 0 {
+		return nil
+	}
+	return cfg
+}
+
+// allowsOrigin reports whether a browser page from origin may call the server
+func (c *corsConfig) allowsOrigin(origin string) bool {
+	if c == nil {
+		return false
+	}
+	return c.allowAll || c.allowedOrigins[origin]
+}
+
+// wrap adds CORS headers for allowed origins and answers preflight requests.
+// Headers are set before next runs, so they also apply to SSE streams.
+func (c *corsConfig) wrap(next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		allowed := c.allowsOrigin(origin)
+		if allowed {
+			if c.allowAll {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			w.Header().Set("Access-Control-Allow-Methods", c.allowedMethods)
+			w.Header().Set("Access-Control-Allow-Headers", c.allowedHeaders)
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if !allowed {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// accessLogPeekBytes is how much of a request body is inspected for a JSON-RPC task ID.
+const accessLogPeekBytes = 64 << 10
+
+// accessLogWriter records the status and size of a response. It passes Flush and
+// Hijack through so SSE streams and WebSocket upgrades keep working.
+type accessLogWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+// WriteHeader implements http.ResponseWriter
+func (w *accessLogWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements http.ResponseWriter
+func (w *accessLogWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher
+func (w *accessLogWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker
+func (w *accessLogWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer does not support hijacking")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *accessLogWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// withAccessLog logs one structured line per request once it finishes, with its
+// method, path, status, duration and response size. SSE streams and WebSocket
+// connections are logged when they close, so duration is the stream's lifetime.
+// JSON-RPC requests also log the RPC method and task ID from the body.
+func withAccessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rpcMethod, taskID := peekJSONRPC(r)
+		lw := &accessLogWriter{ResponseWriter: w}
+		next.ServeHTTP(lw, r)
+
+		status := lw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		line := fmt.Sprintf("access method=%s path=%q status=%d duration_ms=%d bytes=%d remote=%s",
+			r.Method, r.URL.Path, status, time.Since(start).Milliseconds(), lw.bytes, r.RemoteAddr)
+		if strings.HasPrefix(lw.Header().Get("Content-Type"), "text/event-stream") {
+			line += " stream=sse"
+		} else if status == http.StatusSwitchingProtocols {
+			line += " stream=websocket"
+		}
+		if rpcMethod != "" {
+			line += fmt.Sprintf(" rpc_method=%q", rpcMethod)
+		}
+		if taskID != "" {
+			line += fmt.Sprintf(" task_id=%q", taskID)
+		}
+		log.Print(line)
+	})
+}
+
+// peekJSONRPC returns the method and params.id of a JSON-RPC request body, or ""
+// for other requests. The body is restored so the handler reads it in full.
+func peekJSONRPC(r *http.Request) (method, taskID string) {
+	if r.Method != http.MethodPost || r.Body == nil ||
+		!strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return "", ""
+	}
+	peeked, err := io.ReadAll(io.LimitReader(r.Body, accessLogPeekBytes))
+	r.Body = readCloser{io.MultiReader(bytes.NewReader(peeked), r.Body), r.Body}
+	if err != nil {
+		return "", ""
+	}
+	var request struct {
+		Method string `json:"method"`
+		Params struct {
+			ID string `json:"id"`
+		} `json:"params"`
+	}
+	// A body larger than the peek is cut off and fails to parse; it is logged without a task ID.
+	if json.Unmarshal(peeked, &request) != nil {
+		return "", ""
+	}
+	return request.Method, request.Params.ID
+}
+
+// readCloser reads from one reader and closes another.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,50 @@
+// Per-request model selection through message metadata
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// modelMetadataKey is the message metadata field a client sets to pick the model for one request.
+const modelMetadataKey = "model"
+
+// errModelNotAllowed rejects a requested model that is not in MODEL_ALLOWLIST.
+var errModelNotAllowed = errors.New("model not allowed")
+
+// requestedModel returns the model the message metadata asks for, or "" when it
+// asks for none. Only models in MODEL_ALLOWLIST may be requested, so clients cannot
+// run arbitrary or expensive models; anything else is rejected before OpenAI is called.
+func (p *streamingTaskProcessor) requestedModel(metadata map[string]interface{}) (string, error) {
+	raw, ok := metadata[modelMetadataKey]
+	if !ok {
+		return "", nil
+	}
+	model, _ := raw.(string)
+	if model = strings.TrimSpace(model); model == "" {
+		return "", fmt.Errorf("%w: the %q metadata field must be a model name", errModelNotAllowed, modelMetadataKey)
+	}
+	if p.modelAllowlist[model] {
+		return model, nil
+	}
+	if len(p.modelAllowlist) == 0 {
+		return "", fmt.Errorf("%w: %q was requested but this server does not accept per-request models", errModelNotAllowed, model)
+	}
+	allowed := make([]string, 0, len(p.modelAllowlist))
+	for name := range p.modelAllowlist {
+		allowed = append(allowed, name)
+	}
+	sort.Strings(allowed)
+	return "", fmt.Errorf("%w: %q, expected one of %s", errModelNotAllowed, model, strings.Join(allowed, ", "))
+}
+
+// turnModel returns the model that generates the turn's reply: the requested
+// model if any, otherwise the persona's model
+func (p *streamingTaskProcessor) turnModel(turn *completionTurn) string {
+	if turn.model != "" {
+		return turn.model
+	}
+	return p.modelFor(turn.intent)
+}
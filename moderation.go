@@ -0,0 +1,131 @@
+// Output moderation applied before generated text reaches the client
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Moderation modes selected by MODERATION_MODE
+const (
+	moderationOff    = "off"
+	moderationRedact = "redact"
+	moderationFail   = "fail"
+)
+
+// moderationRedaction replaces flagged text in redact mode.
+const moderationRedaction = "[content removed]"
+
+// errContentFlagged fails a task in fail mode. Its message is shown to the client,
+// so it must not repeat the flagged content.
+var errContentFlagged = errors.New("the response was withheld by content moderation")
+
+// contentFilter reports whether text must not be shown to the user.
+type contentFilter func(ctx context.Context, text string) (flagged bool, err error)
+
+// openAIModerationFilter checks text with OpenAI's moderation endpoint
+func openAIModerationFilter(client *openai.Client) contentFilter {
+	return func(ctx context.Context, text string) (bool, error) {
+		resp, err := client.Moderations(ctx, openai.ModerationRequest{Input: text})
+		if err != nil {
+			return false, fmt.Errorf("moderation request failed: %w", err)
+		}
+		for _, result := range resp.Results {
+			if result.Flagged {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+}
+
+// moderator applies a contentFilter according to the moderation mode.
+// A nil *moderator lets all text through.
+type moderator struct {
+	filter contentFilter
+	mode   string
+}
+
+// newModerator returns nil when mode is off, and an error for an unknown mode
+func newModerator(mode string, filter contentFilter) (*moderator, error) {
+	switch mode {
+	case "", moderationOff:
+		return nil, nil
+	case moderationRedact, moderationFail:
+		return &moderator{filter: filter, mode: mode}, nil
+	default:
+		return nil, fmt.Errorf("unknown MODERATION_MODE %q, expected %q, %q or %q",
+			mode, moderationOff, moderationRedact, moderationFail)
+	}
+}
+
+// check returns text unchanged if it passes, the redaction marker if it is flagged in
+// redact mode, and errContentFlagged if it is flagged in fail mode. A failed
+// moderation call is an error: unmoderated text is never released.
+func (m *moderator) check(ctx context.Context, text string) (string, error) {
+	if m == nil || strings.TrimSpace(text) == "" {
+		return text, nil
+	}
+	flagged, err := m.filter(ctx, text)
+	if err != nil {
+		return "", err
+	}
+	if !flagged {
+		return text, nil
+	}
+	if m.mode == moderationFail {
+		return "", errContentFlagged
+	}
+	return moderationRedaction, nil
+}
+
+// sentenceModerator moderates a stream one sentence at a time, so each check sees
+// enough context to judge and flagged text is withheld before it is emitted.
+type sentenceModerator struct {
+	moderator *moderator
+	buf       strings.Builder
+}
+
+// write buffers delta and returns the moderated text of any sentences it completed
+func (s *sentenceModerator) write(ctx context.Context, delta string) (string, error) {
+	if s.moderator == nil {
+		return delta, nil
+	}
+	s.buf.WriteString(delta)
+	buffered := s.buf.String()
+	end := lastSentenceEnd(buffered)
+	if end < 0 {
+		return "", nil
+	}
+	s.buf.Reset()
+	s.buf.WriteString(buffered[end:])
+	return s.moderator.check(ctx, buffered[:end])
+}
+
+// flush moderates and returns whatever is left once the stream has ended
+func (s *sentenceModerator) flush(ctx context.Context) (string, error) {
+	if s.moderator == nil {
+		return "", nil
+	}
+	rest := s.buf.String()
+	s.buf.Reset()
+	return s.moderator.check(ctx, rest)
+}
+
+// sentenceTerminators end a sentence in English or Chinese text.
+const sentenceTerminators = ".!?。！？\n"
+
+// lastSentenceEnd returns the byte offset just past the last sentence terminator in s, or -1
+func lastSentenceEnd(s string) int {
+	i := strings.LastIndexAny(s, sentenceTerminators)
+	if i < 0 {
+		return -1
+	}
+	_, size := utf8.DecodeRuneInString(s[i:])
+	return i + size
+}
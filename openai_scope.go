@@ -0,0 +1,45 @@
+// OpenAI organization and project scoping headers
+package main
+
+import "net/http"
+
+// Headers scoping OpenAI requests for billing attribution
+const (
+	openAIOrganizationHeader = "OpenAI-Organization"
+	openAIProjectHeader      = "OpenAI-Project"
+)
+
+// scopeHeaders returns the organization and project headers to send, leaving out
+// unset IDs so gateways that reject unknown headers keep working.
+func (c *OpenAIConfig) scopeHeaders() http.Header {
+	headers := make(http.Header)
+	if c.OrgID != "" {
+		headers.Set(openAIOrganizationHeader, c.OrgID)
+	}
+	if c.ProjectID != "" {
+		headers.Set(openAIProjectHeader, c.ProjectID)
+	}
+	return headers
+}
+
+// projectTransport adds the OpenAI-Project header, which go-openai does not
+// support; the organization is set through its ClientConfig.OrgID instead.
+type projectTransport struct {
+	project string
+	next    http.RoundTripper
+}
+
+// withOpenAIProject wraps next to send project with every request, or returns next unchanged without one
+func withOpenAIProject(project string, next http.RoundTripper) http.RoundTripper {
+	if project == "" {
+		return next
+	}
+	return &projectTransport{project: project, next: next}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *projectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(openAIProjectHeader, t.project)
+	return t.next.RoundTrip(req)
+}
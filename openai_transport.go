@@ -0,0 +1,25 @@
+// Tuned HTTP transport for OpenAI requests
+package main
+
+import (
+	"net"
+	"net/http"
+)
+
+// newOpenAITransport returns the transport for OpenAI requests: Go's default
+// transport with the connection pool and timeouts of c. Go keeps only two idle
+// connections per host by default, so concurrent tasks against one OpenAI host
+// would keep opening new ones. There is deliberately no overall request timeout,
+// which would cut long streams off; ResponseHeaderTimeout only bounds the wait for
+// the response to start, and for non-streaming completions that includes generation.
+func newOpenAITransport(c HTTPConfig) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: c.DialTimeout, KeepAlive: c.KeepAlive}).DialContext
+	transport.MaxIdleConns = c.MaxIdleConns
+	transport.MaxIdleConnsPerHost = c.MaxIdleConnsPerHost
+	transport.MaxConnsPerHost = c.MaxConnsPerHost
+	transport.IdleConnTimeout = c.IdleConnTimeout
+	transport.TLSHandshakeTimeout = c.TLSHandshakeTimeout
+	transport.ResponseHeaderTimeout = c.ResponseHeaderTimeout
+	return transport
+}
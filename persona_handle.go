@@ -0,0 +1,95 @@
+// Persona labelling of task updates
+package main
+
+import (
+	"sync"
+
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+	"trpc.group/trpc-go/trpc-a2a-go/taskmanager"
+)
+
+// personaMetadataKey names the persona that answered in status and artifact metadata.
+const personaMetadataKey = "persona"
+
+// intentFallbackMetadataKey marks updates of a task whose persona is the default
+// because intent detection failed or timed out.
+const intentFallbackMetadataKey = "intent_fallback"
+
+// personaHandle labels a task's updates with the persona chosen by intent detection,
+// so clients and the TRTC layer can tell who answered without reading logs. Every
+// status message carries the persona, as does the first artifact, which is the
+// first content chunk in streaming mode and the whole reply otherwise.
+// They also carry any labels, such as intent_fallback: true when intent detection
+// fell back to the default persona. After a handoff the updates carry the new
+// persona, and its first artifact also the handoff marker.
+type personaHandle struct {
+	taskmanager.TaskHandle
+	persona string
+	labels  map[string]interface{}
+
+	mu            sync.Mutex
+	labelArtifact bool
+	// marker is added to the first artifact after a handoff.
+	marker map[string]interface{}
+}
+
+// withPersona wraps handle so its updates carry persona and labels
+func withPersona(handle taskmanager.TaskHandle, persona string, labels map[string]interface{}) *personaHandle {
+	return &personaHandle{
+		TaskHandle:    handle,
+		persona:       persona,
+		labels:        labels,
+		labelArtifact: true,
+	}
+}
+
+// UpdateStatus implements taskmanager.TaskHandle
+func (h *personaHandle) UpdateStatus(state protocol.TaskState, msg *protocol.Message) error {
+	if msg != nil {
+		labelled := *msg
+		labelled.Metadata = h.label(msg.Metadata)
+		msg = &labelled
+	}
+	return h.TaskHandle.UpdateStatus(state, msg)
+}
+
+// AddArtifact implements taskmanager.TaskHandle
+func (h *personaHandle) AddArtifact(artifact protocol.Artifact) error {
+	h.mu.Lock()
+	first, marker := h.labelArtifact, h.marker
+	h.labelArtifact, h.marker = false, nil
+	h.mu.Unlock()
+	if first {
+		artifact.Metadata = h.label(artifact.Metadata)
+		for key, value := range marker {
+			artifact.Metadata[key] = value
+		}
+	}
+	return h.TaskHandle.AddArtifact(artifact)
+}
+
+// handOff labels later updates with persona, and the next artifact, the first of
+// the new persona, also with marker
+func (h *personaHandle) handOff(persona string, marker map[string]interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.persona = persona
+	h.labelArtifact = true
+	h.marker = marker
+}
+
+// label returns a copy of metadata with the persona and labels added
+func (h *personaHandle) label(metadata map[string]interface{}) map[string]interface{} {
+	h.mu.Lock()
+	persona := h.persona
+	h.mu.Unlock()
+	labelled := make(map[string]interface{}, len(metadata)+len(h.labels)+1)
+	for key, value := range metadata {
+		labelled[key] = value
+	}
+	for key, value := range h.labels {
+		labelled[key] = value
+	}
+	labelled[personaMetadataKey] = persona
+	return labelled
+}
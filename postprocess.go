@@ -0,0 +1,186 @@
+// Post-processing of replies before they reach the client and TRTC
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Post-processors selectable in POST_PROCESSORS
+const (
+	postProcessStripMarkdown = "strip_markdown"
+	postProcessReplace       = "replace"
+	postProcessDisclaimer    = "disclaimer"
+)
+
+// ReplaceRule replaces matches of Pattern, a Go regular expression, with
+// Replacement, which may refer to groups as $1.
+type ReplaceRule struct {
+	Pattern     string `yaml:"pattern" toml:"pattern"`
+	Replacement string `yaml:"replacement" toml:"replacement"`
+}
+
+// parseReplaceRules parses pattern=>replacement rules separated by semicolons
+func parseReplaceRules(value string) ([]ReplaceRule, error) {
+	var rules []ReplaceRule
+	for _, entry := range strings.Split(value, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		pattern, replacement, ok := strings.Cut(entry, "=>")
+		if !ok {
+			return nil, fmt.Errorf("malformed rule %q, expected pattern=>replacement", entry)
+		}
+		rules = append(rules, ReplaceRule{Pattern: strings.TrimSpace(pattern), Replacement: strings.TrimSpace(replacement)})
+	}
+	return rules, nil
+}
+
+// postProcessor transforms reply text. text rewrites each complete sentence, or the
+// whole reply when it is not streamed; suffix is appended once the reply has ended.
+// Either may be unset.
+type postProcessor struct {
+	text   func(string) string
+	suffix string
+}
+
+// postProcessors is the ordered POST_PROCESSORS pipeline; each processor sees the
+// output of the ones before it, including their suffixes. An empty pipeline leaves
+// text unchanged.
+type postProcessors []postProcessor
+
+// newPostProcessors builds the pipeline named by names in order. replace needs
+// replacement rules and disclaimer a disclaimer text.
+func newPostProcessors(names []string, rules []ReplaceRule, disclaimer string) (postProcessors, error) {
+	var pipeline postProcessors
+	for _, name := range names {
+		switch name {
+		case postProcessStripMarkdown:
+			pipeline = append(pipeline, postProcessor{text: stripMarkdown})
+		case postProcessReplace:
+			replace, err := replaceProcessor(rules)
+			if err != nil {
+				return nil, err
+			}
+			pipeline = append(pipeline, postProcessor{text: replace})
+		case postProcessDisclaimer:
+			if strings.TrimSpace(disclaimer) == "" {
+				return nil, fmt.Errorf("POST_PROCESSORS %s needs RESPONSE_DISCLAIMER", postProcessDisclaimer)
+			}
+			pipeline = append(pipeline, postProcessor{suffix: "\n\n" + disclaimer})
+		default:
+			return nil, fmt.Errorf("unknown POST_PROCESSORS entry %q, expected %q, %q or %q",
+				name, postProcessStripMarkdown, postProcessReplace, postProcessDisclaimer)
+		}
+	}
+	return pipeline, nil
+}
+
+// replaceProcessor returns a transformation applying rules in order
+func replaceProcessor(rules []ReplaceRule) (func(string) string, error) {
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("POST_PROCESSORS %s needs POST_PROCESS_REPLACEMENTS", postProcessReplace)
+	}
+	patterns := make([]*regexp.Regexp, len(rules))
+	for i, rule := range rules {
+		if rule.Pattern == "" {
+			return nil, fmt.Errorf("POST_PROCESS_REPLACEMENTS rule %d has no pattern", i+1)
+		}
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("POST_PROCESS_REPLACEMENTS rule %q: %w", rule.Pattern, err)
+		}
+		patterns[i] = pattern
+	}
+	return func(text string) string {
+		for i, pattern := range patterns {
+			text = pattern.ReplaceAllString(text, rules[i].Replacement)
+		}
+		return text
+	}, nil
+}
+
+// apply runs text through the pipeline; with final set, the reply has ended and
+// the suffixes are added
+func (pp postProcessors) apply(text string, final bool) string {
+	for _, processor := range pp {
+		if processor.text != nil && text != "" {
+			text = processor.text(text)
+		}
+		if final {
+			text += processor.suffix
+		}
+	}
+	return text
+}
+
+// markdownRules turn Markdown into the plain text it renders as, so speech
+// synthesis does not read out its markup. They work a line or sentence at a time.
+var markdownRules = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	{regexp.MustCompile("(?m)^[ \t]*(```|~~~).*$\n?"), ""},
+	{regexp.MustCompile(`(?m)^[ \t]*#{1,6}[ \t]+`), ""},
+	{regexp.MustCompile(`(?m)^[ \t]*>[ \t]?`), ""},
+	{regexp.MustCompile(`(?m)^([ \t]*)[-*+][ \t]+`), "$1"},
+	{regexp.MustCompile(`(?m)^[ \t]*([-*_][ \t]*){3,}$`), ""},
+	{regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`), "$1"},
+	{regexp.MustCompile(`\[([^\]]+)\]\([^)]*\)`), "$1"},
+	{regexp.MustCompile("`([^`]*)`"), "$1"},
+	{regexp.MustCompile(`\*\*([^*]+)\*\*`), "$1"},
+	{regexp.MustCompile(`__([^_]+)__`), "$1"},
+	{regexp.MustCompile(`~~([^~]+)~~`), "$1"},
+	{regexp.MustCompile(`\*([^*\s][^*\n]*)\*`), "$1"},
+	// Emphasis split across sentences leaves unpaired markers behind.
+	{regexp.MustCompile(`\*{2,}|~~`), ""},
+}
+
+// stripMarkdown removes Markdown markup from text, keeping the words
+func stripMarkdown(text string) string {
+	for _, rule := range markdownRules {
+		text = rule.pattern.ReplaceAllString(text, rule.replacement)
+	}
+	return text
+}
+
+// sentencePostProcessor applies the pipeline to a stream one sentence at a time, so
+// patterns see whole sentences rather than arbitrary deltas.
+type sentencePostProcessor struct {
+	pipeline postProcessors
+	buf      strings.Builder
+}
+
+// write buffers delta and returns the processed text of any sentences it completed
+func (s *sentencePostProcessor) write(delta string) string {
+	if len(s.pipeline) == 0 {
+		return delta
+	}
+	s.buf.WriteString(delta)
+	buffered := s.buf.String()
+	end := lastSentenceEnd(buffered)
+	if end < 0 {
+		return ""
+	}
+	s.buf.Reset()
+	s.buf.WriteString(buffered[end:])
+	return s.pipeline.apply(buffered[:end], false)
+}
+
+// flush returns the processed text still buffered; with final set, the reply has
+// ended and the suffixes follow it
+func (s *sentencePostProcessor) flush(final bool) string {
+	rest := s.buf.String()
+	s.buf.Reset()
+	return s.pipeline.apply(rest, final)
+}
+
+// postProcessorsFor returns the pipeline for the turn's reply: none for JSON replies,
+// which must reach the client as the model wrote them
+func (p *streamingTaskProcessor) postProcessorsFor(turn *completionTurn) postProcessors {
+	if turn.jsonOutput {
+		return nil
+	}
+	return p.postProcessors
+}
@@ -0,0 +1,37 @@
+// Best-effort progress estimates for streamed replies
+package main
+
+// Status metadata fields carrying a streamed reply's progress
+const (
+	progressMetadataKey              = "progress"
+	progressIndeterminateMetadataKey = "progress_indeterminate"
+)
+
+// maxStreamingProgress keeps estimates below 100 until the task actually completes.
+const maxStreamingProgress = 99
+
+// progressEstimator estimates how far a streamed reply has got from its length caps:
+// MAX_OUTPUT_CHARS in characters and OPENAI_MAX_TOKENS in tokens, approximated from
+// characters. The reply may stop well before either cap, so the estimate only says
+// how much of the budget has been used. Zero caps are unset.
+type progressEstimator struct {
+	maxChars  int
+	maxTokens int
+}
+
+// metadata returns the progress fields for a reply of chars characters: an estimated
+// percentage when a cap is known, the nearer cap winning, otherwise an indeterminate flag
+func (e progressEstimator) metadata(chars int) map[string]interface{} {
+	if e.maxChars <= 0 && e.maxTokens <= 0 {
+		return map[string]interface{}{progressIndeterminateMetadataKey: true}
+	}
+	percent := 0
+	if e.maxChars > 0 {
+		percent = chars * 100 / e.maxChars
+	}
+	if e.maxTokens > 0 {
+		tokens := (chars + charsPerToken - 1) / charsPerToken
+		percent = max(percent, tokens*100/e.maxTokens)
+	}
+	return map[string]interface{}{progressMetadataKey: min(percent, maxStreamingProgress)}
+}
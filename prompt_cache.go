@@ -0,0 +1,144 @@
+// Prompt caching hints for the static persona prompt
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// promptCacheKeyField is the request field naming the cached prompt prefix, which
+// go-openai does not support. Backends route requests with the same key to the same
+// cache; ones that do not know the field ignore it.
+const promptCacheKeyField = "prompt_cache_key"
+
+// promptCacheTransport adds a prompt_cache_key to chat completion requests, derived
+// from their first message (the static persona prompt with ENABLE_PROMPT_CACHE), and
+// records the cached prompt tokens reported by non-streaming responses.
+type promptCacheTransport struct {
+	next http.RoundTripper
+}
+
+// withPromptCache wraps next to send cache hints, or returns next unchanged when disabled
+func withPromptCache(enabled bool, next http.RoundTripper) http.RoundTripper {
+	if !enabled {
+		return next
+	}
+	return &promptCacheTransport{next: next}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *promptCacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodPost || !strings.HasSuffix(req.URL.Path, "/chat/completions") || req.Body == nil {
+		return t.next.RoundTrip(req)
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	body = addPromptCacheKey(body)
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+
+	resp, err := t.next.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusOK || strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return resp, err
+	}
+	stats, ok := req.Context().Value(promptCacheContextKey{}).(*promptCacheStats)
+	if !ok {
+		return resp, nil
+	}
+	payload, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(payload))
+	stats.record(payload)
+	return resp, nil
+}
+
+// addPromptCacheKey returns the request body with a prompt_cache_key hashed from its
+// first message, or unchanged if it already has one or cannot be parsed
+func addPromptCacheKey(body []byte) []byte {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return body
+	}
+	var messages []json.RawMessage
+	if _, ok := fields[promptCacheKeyField]; ok || json.Unmarshal(fields["messages"], &messages) != nil || len(messages) == 0 {
+		return body
+	}
+	sum := sha256.Sum256(messages[0])
+	key, _ := json.Marshal("prompt-" + hex.EncodeToString(sum[:8]))
+	fields[promptCacheKeyField] = key
+	rewritten, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return rewritten
+}
+
+// promptCacheContextKey carries a *promptCacheStats through the OpenAI client to the cache transport.
+type promptCacheContextKey struct{}
+
+// promptCacheStats records the prompt caching reported for the requests made with a context.
+type promptCacheStats struct {
+	mu       sync.Mutex
+	reported bool
+	cached   int
+	prompt   int
+}
+
+// withPromptCacheStats returns a context whose OpenAI responses record their prompt cache usage
+func withPromptCacheStats(ctx context.Context) (context.Context, *promptCacheStats) {
+	stats := &promptCacheStats{}
+	return context.WithValue(ctx, promptCacheContextKey{}, stats), stats
+}
+
+// record takes the cached prompt tokens from a completion response body, if it reports them
+func (s *promptCacheStats) record(payload []byte) {
+	var response struct {
+		Usage struct {
+			PromptTokens        int `json:"prompt_tokens"`
+			PromptTokensDetails *struct {
+				CachedTokens int `json:"cached_tokens"`
+			} `json:"prompt_tokens_details"`
+		} `json:"usage"`
+	}
+	if json.Unmarshal(payload, &response) != nil || response.Usage.PromptTokensDetails == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reported = true
+	s.cached = response.Usage.PromptTokensDetails.CachedTokens
+	s.prompt = response.Usage.PromptTokens
+}
+
+// metadata adds prompt_cache ("hit" or "miss") and the cached token counts to
+// metadata, if the backend reported them; a nil *promptCacheStats adds nothing
+func (s *promptCacheStats) metadata(metadata map[string]interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.reported {
+		return
+	}
+	metadata["prompt_cache"] = "miss"
+	if s.cached > 0 {
+		metadata["prompt_cache"] = "hit"
+	}
+	metadata["cached_prompt_tokens"] = s.cached
+	metadata["prompt_tokens"] = s.prompt
+}
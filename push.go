@@ -0,0 +1,166 @@
+// Webhook push notifications sent when a task finishes
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+	"trpc.group/trpc-go/trpc-a2a-go/taskmanager"
+)
+
+// Push notification request headers
+const (
+	pushTokenHeader     = "X-A2A-Notification-Token"
+	pushTimestampHeader = "X-A2A-Timestamp"
+	pushSignatureHeader = "X-A2A-Signature"
+)
+
+// Reasons verifySignature rejects a request
+var (
+	errSignatureMissing   = errors.New("missing " + pushTimestampHeader + " or " + pushSignatureHeader + " header")
+	errSignatureTimestamp = errors.New("signature timestamp outside the allowed window")
+	errSignatureMismatch  = errors.New("signature does not match")
+)
+
+// Push delivery settings
+const (
+	pushRequestTimeout = 10 * time.Second
+	pushRetryBackoff   = time.Second
+)
+
+// pushNotifier POSTs a task's final state and artifacts to the webhook the client
+// registered with tasks/pushNotification/set. A nil *pushNotifier sends nothing.
+type pushNotifier struct {
+	taskManager *taskmanager.MemoryTaskManager
+	client      *http.Client
+	// secret signs each notification with HMAC-SHA256; empty disables signing.
+	secret     []byte
+	maxRetries int
+}
+
+// newPushNotifier creates a notifier reading webhook configs from tm
+func newPushNotifier(tm *taskmanager.MemoryTaskManager, secret string, maxRetries int) *pushNotifier {
+	return &pushNotifier{
+		taskManager: tm,
+		client:      &http.Client{Timeout: pushRequestTimeout},
+		secret:      []byte(secret),
+		maxRetries:  maxRetries,
+	}
+}
+
+// notify sends the task's current state to its webhook, if one is configured.
+// Delivery happens in the background so a slow webhook never holds up the task.
+func (n *pushNotifier) notify(taskID string) {
+	if n == nil {
+		return
+	}
+	n.taskManager.PushNotificationsMutex.RLock()
+	config, ok := n.taskManager.PushNotifications[taskID]
+	n.taskManager.PushNotificationsMutex.RUnlock()
+	if !ok {
+		return
+	}
+
+	task, err := n.taskManager.OnGetTask(context.Background(), protocol.TaskQueryParams{ID: taskID})
+	if err != nil {
+		log.Printf("Push notification for task %s skipped: %v", taskID, err)
+		return
+	}
+	payload, err := json.Marshal(task)
+	if err != nil {
+		log.Printf("Push notification for task %s skipped: failed to encode task: %v", taskID, err)
+		return
+	}
+	go n.deliver(taskID, config, payload)
+}
+
+// deliver POSTs payload to the webhook, retrying network errors and 5xx responses with exponential backoff
+func (n *pushNotifier) deliver(taskID string, config protocol.PushNotificationConfig, payload []byte) {
+	for attempt := 0; ; attempt++ {
+		retry, err := n.post(config, payload)
+		if err == nil {
+			log.Printf("Push notification for task %s delivered to %s", taskID, config.URL)
+			return
+		}
+		if !retry || attempt >= n.maxRetries {
+			log.Printf("Push notification for task %s to %s failed: %v", taskID, config.URL, err)
+			return
+		}
+		log.Printf("Push notification for task %s failed (attempt %d/%d), retrying: %v",
+			taskID, attempt+1, n.maxRetries+1, err)
+		time.Sleep(pushRetryBackoff << attempt)
+	}
+}
+
+// post sends one notification and reports whether a failure is worth retrying
+func (n *pushNotifier) post(config protocol.PushNotificationConfig, payload []byte) (retry bool, err error) {
+	if !strings.HasPrefix(config.URL, "http://") && !strings.HasPrefix(config.URL, "https://") {
+		return false, fmt.Errorf("webhook URL must be http or https")
+	}
+	req, err := http.NewRequest(http.MethodPost, config.URL, bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if config.Token != "" {
+		req.Header.Set(pushTokenHeader, config.Token)
+	}
+	if len(n.secret) > 0 {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(pushTimestampHeader, timestamp)
+		req.Header.Set(pushSignatureHeader, signPayload(n.secret, timestamp, payload))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests,
+			fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return false, nil
+}
+
+// signPayload returns the "sha256=<hex>" HMAC of "<timestamp>.<payload>". Receivers
+// recompute it with the shared secret and reject stale timestamps to stop replays.
+func signPayload(secret []byte, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignature checks a signature made by signPayload. The timestamp, in Unix
+// seconds, must be within tolerance of now, so a captured request cannot be
+// replayed later; comparison is constant-time.
+func verifySignature(secret []byte, timestamp string, payload []byte, signature string, now time.Time, tolerance time.Duration) error {
+	if timestamp == "" || signature == "" {
+		return errSignatureMissing
+	}
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("%w: %q is not a Unix timestamp", errSignatureTimestamp, timestamp)
+	}
+	if skew := now.Sub(time.Unix(seconds, 0)).Abs(); skew > tolerance {
+		return fmt.Errorf("%w: %v off, at most %v allowed", errSignatureTimestamp, skew.Round(time.Second), tolerance)
+	}
+	if !hmac.Equal([]byte(signature), []byte(signPayload(secret, timestamp, payload))) {
+		return errSignatureMismatch
+	}
+	return nil
+}
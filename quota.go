@@ -0,0 +1,110 @@
+// Per-client token accounting and daily quotas
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// charsPerToken approximates how many characters of text make one token.
+const charsPerToken = 4
+
+// errQuotaExceeded fails requests from a client that used up its daily quota.
+var errQuotaExceeded = errors.New("quota exceeded: the daily limit for this API key has been reached and resets at midnight UTC")
+
+// clientUsage is one client's consumption on the current UTC day.
+type clientUsage struct {
+	requests int
+	tokens   int
+}
+
+// usageStore accounts OpenAI tokens and requests per API key for the current UTC
+// day and enforces the daily quotas. All counters reset at midnight UTC. A zero
+// quota is unlimited, as are the keys in unlimited.
+type usageStore struct {
+	tokenQuota   int
+	requestQuota int
+	unlimited    map[string]bool
+
+	mu    sync.Mutex
+	day   string
+	usage map[string]*clientUsage
+}
+
+// newUsageStore creates a usage store with the given daily quotas
+func newUsageStore(tokenQuota, requestQuota int, unlimitedKeys []string) *usageStore {
+	unlimited := make(map[string]bool)
+	for _, key := range unlimitedKeys {
+		unlimited[key] = true
+	}
+	return &usageStore{
+		tokenQuota:   tokenQuota,
+		requestQuota: requestQuota,
+		unlimited:    unlimited,
+		usage:        make(map[string]*clientUsage),
+	}
+}
+
+// clientLocked returns the usage of key for today, resetting all counters on a new UTC day.
+// The caller must hold s.mu.
+func (s *usageStore) clientLocked(key string) *clientUsage {
+	if day := time.Now().UTC().Format(time.DateOnly); day != s.day {
+		s.day = day
+		s.usage = make(map[string]*clientUsage)
+	}
+	usage, ok := s.usage[key]
+	if !ok {
+		usage = &clientUsage{}
+		s.usage[key] = usage
+	}
+	return usage
+}
+
+// admit counts a new request from the API key in ctx, or returns errQuotaExceeded
+// if the key has used up its daily tokens or requests. It runs before any OpenAI call.
+func (s *usageStore) admit(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	key := apiKeyFromContext(ctx)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	usage := s.clientLocked(key)
+	if !s.unlimited[key] &&
+		((s.tokenQuota > 0 && usage.tokens >= s.tokenQuota) ||
+			(s.requestQuota > 0 && usage.requests >= s.requestQuota)) {
+		return errQuotaExceeded
+	}
+	usage.requests++
+	return nil
+}
+
+// addTokens charges tokens to the API key in ctx
+func (s *usageStore) addTokens(ctx context.Context, tokens int) {
+	if s == nil || tokens <= 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clientLocked(apiKeyFromContext(ctx)).tokens += tokens
+}
+
+// addUsage charges the tokens an OpenAI response reports
+func (s *usageStore) addUsage(ctx context.Context, usage openai.Usage) {
+	s.addTokens(ctx, usage.TotalTokens)
+}
+
+// estimateTokens approximates the token count of a streamed exchange, whose usage
+// OpenAI does not report, at one token per four characters of prompt and reply
+func estimateTokens(messages []openai.ChatCompletionMessage, reply string) int {
+	chars := utf8.RuneCountInString(reply)
+	for _, message := range messages {
+		chars += utf8.RuneCountInString(message.Content)
+	}
+	return (chars + charsPerToken - 1) / charsPerToken
+}
@@ -0,0 +1,80 @@
+// Redaction of secrets from log output
+package main
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// redactedPlaceholder replaces secret values in log output.
+const redactedPlaceholder = "[REDACTED]"
+
+// minRedactedSecretLength skips values too short to redact without mangling ordinary log text.
+const minRedactedSecretLength = 6
+
+// secretEnvVars are the environment variables whose values never appear in logs.
+// LOG_REDACT_ENV adds more.
+var secretEnvVars = []string{
+	"OPENAI_API_KEY",
+	"TRTC_SECRET_ID",
+	"TRTC_SECRET_KEY",
+	"TTS_SECRET_ID",
+	"TTS_SECRET_KEY",
+	"ADMIN_TOKEN",
+	"PUSH_SIGNING_SECRET",
+	"INBOUND_SIGNING_SECRET",
+}
+
+// secretFieldPattern matches credential fields in JSON, such as the TRTC TTS config,
+// so they are redacted even when the value is not a known secret.
+var secretFieldPattern = regexp.MustCompile(`("(?:SecretId|SecretKey|ApiKey|api_key|Authorization)"\s*:\s*)"[^"]*"`)
+
+// redactingWriter removes known secret values and credential fields from everything
+// written through it. The standard logger writes one entry per Write call, so a
+// secret is never split across writes.
+type redactingWriter struct {
+	out io.Writer
+
+	mu       sync.RWMutex
+	secrets  []string
+	replacer *strings.Replacer
+}
+
+// newRedactingWriter returns a writer to out that redacts secrets
+func newRedactingWriter(out io.Writer, secrets ...string) *redactingWriter {
+	w := &redactingWriter{out: out}
+	w.add(secrets...)
+	return w
+}
+
+// add registers more secret values, e.g. API keys loaded after logging is set up
+func (w *redactingWriter) add(secrets ...string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for _, secret := range secrets {
+		if len(secret) >= minRedactedSecretLength {
+			w.secrets = append(w.secrets, secret)
+		}
+	}
+	pairs := make([]string, 0, 2*len(w.secrets))
+	for _, secret := range w.secrets {
+		pairs = append(pairs, secret, redactedPlaceholder)
+	}
+	w.replacer = strings.NewReplacer(pairs...)
+}
+
+// Write implements io.Writer. It reports len(p) on success so callers see a complete write.
+func (w *redactingWriter) Write(p []byte) (int, error) {
+	w.mu.RLock()
+	replacer := w.replacer
+	w.mu.RUnlock()
+
+	text := replacer.Replace(string(p))
+	text = secretFieldPattern.ReplaceAllString(text, `${1}"`+redactedPlaceholder+`"`)
+	if _, err := io.WriteString(w.out, text); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
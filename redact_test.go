@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestRedactingWriterKeepsSecretsOutOfLogs(t *testing.T) {
+	const secret = "sk-test-0123456789"
+	var buf bytes.Buffer
+	savedOutput, savedFlags := log.Writer(), log.Flags()
+	t.Cleanup(func() {
+		log.SetOutput(savedOutput)
+		log.SetFlags(savedFlags)
+	})
+	log.SetFlags(0)
+	log.SetOutput(newRedactingWriter(&buf, secret, "short"))
+
+	log.Printf("Calling OpenAI with key %s", secret)
+	log.Printf(`TTS config: {"AppId": 1, "SecretId": "AKIDunknown", "SecretKey" : "not-a-known-secret", "VoiceType": 601005}`)
+	log.Printf("a short value stays: short")
+
+	got := buf.String()
+	want := `Calling OpenAI with key [REDACTED]
+TTS config: {"AppId": 1, "SecretId": "[REDACTED]", "SecretKey" : "[REDACTED]", "VoiceType": 601005}
+a short value stays: short
+`
+	if got != want {
+		t.Errorf("log output:\n%s\nwant:\n%s", got, want)
+	}
+	for _, leaked := range []string{secret, "AKIDunknown", "not-a-known-secret"} {
+		if strings.Contains(got, leaked) {
+			t.Errorf("log output contains %q", leaked)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Provider request IDs for support escalations
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// openAIRequestIDHeaders carry the provider's ID of a request: OpenAI's and Azure
+// OpenAI's, in the order they are tried.
+var openAIRequestIDHeaders = []string{"X-Request-Id", "Apim-Request-Id"}
+
+// requestIDMetadataKey records the provider's request ID on the final artifact.
+const requestIDMetadataKey = "openai_request_id"
+
+// openAIRequestID returns the request ID of a response, or "" if it has none
+func openAIRequestID(header http.Header) string {
+	for _, name := range openAIRequestIDHeaders {
+		if id := header.Get(name); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+// setRequestID records id as the request ID of the latest chat completion; a
+// response without one clears it, so an ID never belongs to an earlier request
+func (s *servedBy) setRequestID(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requestID = id
+}
+
+// getRequestID returns the latest chat completion's request ID, or "" if none was
+// reported. A nil *servedBy has none.
+func (s *servedBy) getRequestID() string {
+	if s == nil {
+		return ""
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requestID
+}
+
+// servedByOf returns the recorder of ctx, or nil if withServedBy did not create it
+func servedByOf(ctx context.Context) *servedBy {
+	served, _ := ctx.Value(servedByContextKey{}).(*servedBy)
+	return served
+}
+
+// requestIDError is a completion failure with the provider's ID of the request
+// that failed, which the provider's support needs to look into it.
+type requestIDError struct {
+	err       error
+	requestID string
+}
+
+// Error implements error
+func (e *requestIDError) Error() string {
+	return fmt.Sprintf("%v (OpenAI request ID %s)", e.err, e.requestID)
+}
+
+// Unwrap returns the failure
+func (e *requestIDError) Unwrap() error {
+	return e.err
+}
+
+// annotate adds the latest request ID to err, if one was reported; nil stays nil
+func (s *servedBy) annotate(err error) error {
+	id := s.getRequestID()
+	if err == nil || id == "" {
+		return err
+	}
+	return &requestIDError{err: err, requestID: id}
+}
+
+// requestIDMetadata records the latest request ID in metadata, if one was reported
+func (s *servedBy) requestIDMetadata(metadata map[string]interface{}) {
+	if id := s.getRequestID(); id != "" {
+		metadata[requestIDMetadataKey] = id
+	}
+}
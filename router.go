@@ -0,0 +1,170 @@
+// Persona routing strategies
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+)
+
+// ROUTER values
+const (
+	routerLLM     = "llm"
+	routerKeyword = "keyword"
+	routerSticky  = "sticky"
+)
+
+// Router picks the persona that answers a message.
+type Router interface {
+	// Route returns the persona for text; it must be one of session.prompts' personas.
+	Route(ctx context.Context, text string, session routingSession) (persona string, err error)
+}
+
+// routingSession is the conversation a message is routed in.
+type routingSession struct {
+	// id is the session's task ID, or "" for requests without a session.
+	id string
+	// previous is the persona that answered the session's last turn, or "".
+	previous string
+	prompts  *promptSet
+	// answering is set when the message answers a clarifying question the previous
+	// persona asked.
+	answering bool
+	// skill is the persona whose skill the message asked for, or "".
+	skill string
+}
+
+// current returns the previous persona if it can keep answering: it still exists
+// after prompt reloads and is not the guard, so a declined message does not stick.
+func (s routingSession) current() string {
+	if s.previous == "" || !s.prompts.hasPersona(s.previous) || s.prompts.isGuard(s.previous) {
+		return ""
+	}
+	return s.previous
+}
+
+// newRouter returns the routing strategy named by ROUTER
+func newRouter(strategy string, rules []KeywordRule, p *streamingTaskProcessor) (Router, error) {
+	switch strategy {
+	case "", routerLLM:
+		return llmRouter{processor: p}, nil
+	case routerKeyword:
+		compiled, err := compileKeywordRules(rules)
+		if err != nil {
+			return nil, err
+		}
+		if len(compiled) == 0 {
+			return nil, fmt.Errorf("ROUTER=%s needs ROUTER_KEYWORDS", routerKeyword)
+		}
+		return keywordRouter{rules: compiled}, nil
+	case routerSticky:
+		return stickyRouter{next: llmRouter{processor: p}}, nil
+	default:
+		return nil, fmt.Errorf("unknown ROUTER %q, expected %q, %q or %q", strategy, routerLLM, routerKeyword, routerSticky)
+	}
+}
+
+// llmRouter asks the model which persona the user wants, on every message. With
+// INTENT_CACHE_SIZE, messages outside a persona's session may be answered from the cache.
+type llmRouter struct {
+	processor *streamingTaskProcessor
+}
+
+// Route implements Router
+func (r llmRouter) Route(ctx context.Context, text string, session routingSession) (string, error) {
+	cacheable := session.current() == ""
+	if cacheable {
+		if persona, ok := r.processor.intentCache.get(session.prompts, text); ok {
+			log.Printf("Intent detection result from cache: User wants to talk to %s", persona)
+			return persona, nil
+		}
+	}
+	result, err := r.processor.classifyIntent(ctx, session.prompts, text, session.previous, false)
+	if err != nil {
+		return "", err
+	}
+	if cacheable && result.Matched {
+		r.processor.intentCache.put(session.prompts, text, result.Persona)
+	}
+	return result.Persona, nil
+}
+
+// KeywordRule routes messages matching Pattern, a case-insensitive Go regular expression, to Persona.
+type KeywordRule struct {
+	Persona string `yaml:"persona" toml:"persona"`
+	Pattern string `yaml:"pattern" toml:"pattern"`
+}
+
+// keywordRule is a KeywordRule with its pattern compiled.
+type keywordRule struct {
+	persona string
+	pattern *regexp.Regexp
+}
+
+// compileKeywordRules compiles the rules' patterns
+func compileKeywordRules(rules []KeywordRule) ([]keywordRule, error) {
+	compiled := make([]keywordRule, 0, len(rules))
+	for _, rule := range rules {
+		if rule.Persona == "" || rule.Pattern == "" {
+			return nil, fmt.Errorf("ROUTER_KEYWORDS rule %q=%q needs a persona and a pattern", rule.Persona, rule.Pattern)
+		}
+		pattern, err := regexp.Compile("(?i)" + rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("ROUTER_KEYWORDS rule for %s: %w", rule.Persona, err)
+		}
+		compiled = append(compiled, keywordRule{persona: rule.Persona, pattern: pattern})
+	}
+	return compiled, nil
+}
+
+// parseKeywordRules parses persona=pattern rules separated by semicolons
+func parseKeywordRules(value string) ([]KeywordRule, error) {
+	var rules []KeywordRule
+	for _, entry := range strings.Split(value, ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		persona, pattern, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed rule %q, expected persona=pattern", entry)
+		}
+		rules = append(rules, KeywordRule{Persona: strings.TrimSpace(persona), Pattern: strings.TrimSpace(pattern)})
+	}
+	return rules, nil
+}
+
+// keywordRouter routes by the first rule whose pattern matches the message, without
+// calling the model. Without a match the session stays with its persona, and a new
+// session gets the default persona. Rules for personas that no longer exist are skipped.
+type keywordRouter struct {
+	rules []keywordRule
+}
+
+// Route implements Router
+func (r keywordRouter) Route(ctx context.Context, text string, session routingSession) (string, error) {
+	for _, rule := range r.rules {
+		if session.prompts.hasPersona(rule.persona) && rule.pattern.MatchString(text) {
+			return rule.persona, nil
+		}
+	}
+	if current := session.current(); current != "" {
+		return current, nil
+	}
+	return session.prompts.defaultPersona(), nil
+}
+
+// stickyRouter keeps a session with the persona that answered its last turn and
+// only routes the first message of a session, with next.
+type stickyRouter struct {
+	next Router
+}
+
+// Route implements Router
+func (r stickyRouter) Route(ctx context.Context, text string, session routingSession) (string, error) {
+	if current := session.current(); current != "" {
+		return current, nil
+	}
+	return r.next.Route(ctx, text, session)
+}
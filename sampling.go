@@ -0,0 +1,98 @@
+// Sampling parameters merged from the defaults, the persona and the request
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Message metadata keys a client sets to override sampling for one request
+const (
+	temperatureMetadataKey      = "temperature"
+	topPMetadataKey             = "top_p"
+	presencePenaltyMetadataKey  = "presence_penalty"
+	frequencyPenaltyMetadataKey = "frequency_penalty"
+)
+
+// errInvalidSampling rejects a sampling metadata value that is not a number in range.
+var errInvalidSampling = errors.New("invalid sampling parameter")
+
+// samplingParams holds optional sampling parameters; nil leaves the API default.
+type samplingParams struct {
+	temperature *float32
+	topP        *float32
+	presence    *float32
+	frequency   *float32
+}
+
+// merge returns s with every parameter override sets replacing its own
+func (s samplingParams) merge(override samplingParams) samplingParams {
+	if override.temperature != nil {
+		s.temperature = override.temperature
+	}
+	if override.topP != nil {
+		s.topP = override.topP
+	}
+	if override.presence != nil {
+		s.presence = override.presence
+	}
+	if override.frequency != nil {
+		s.frequency = override.frequency
+	}
+	return s
+}
+
+// apply sets the parameters on req
+func (s samplingParams) apply(req *openai.ChatCompletionRequest) {
+	if s.temperature != nil {
+		// The client omits a zero temperature, which would leave the API default of 1.
+		req.Temperature = max(*s.temperature, math.SmallestNonzeroFloat32)
+	}
+	if s.topP != nil {
+		req.TopP = *s.topP
+	}
+	if s.presence != nil {
+		req.PresencePenalty = *s.presence
+	}
+	if s.frequency != nil {
+		req.FrequencyPenalty = *s.frequency
+	}
+}
+
+// samplingFor returns the sampling of a reply by the persona: the request's
+// parameters, then the persona's, then the defaults
+func (p *streamingTaskProcessor) samplingFor(intent string, requested samplingParams) samplingParams {
+	return p.sampling.merge(p.personaSampling[intent]).merge(requested)
+}
+
+// requestedSampling returns the sampling parameters set in message metadata
+func requestedSampling(metadata map[string]interface{}) (samplingParams, error) {
+	var requested samplingParams
+	fields := []struct {
+		key       string
+		low, high float64
+		target    **float32
+	}{
+		{temperatureMetadataKey, 0, 2, &requested.temperature},
+		{topPMetadataKey, 0, 1, &requested.topP},
+		{presencePenaltyMetadataKey, -2, 2, &requested.presence},
+		{frequencyPenaltyMetadataKey, -2, 2, &requested.frequency},
+	}
+	for _, field := range fields {
+		raw, ok := metadata[field.key]
+		if !ok {
+			continue
+		}
+		// JSON numbers decode as float64.
+		value, ok := raw.(float64)
+		if !ok || value < field.low || value > field.high {
+			return samplingParams{}, fmt.Errorf("%w: the %q metadata field must be a number from %v to %v",
+				errInvalidSampling, field.key, field.low, field.high)
+		}
+		*field.target = float32Ptr(&value)
+	}
+	return requested, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestSamplingPrecedence(t *testing.T) {
+	global, persona := 0.7, 0.3
+	cfg := defaultConfig()
+	cfg.OpenAI.Temperature = &global
+	cfg.OpenAI.TopP = &global
+	cfg.OpenAI.PresencePenalty = &global
+	cfg.Personas.Temperatures = map[string]float64{"XiaoShuai": persona}
+	cfg.Personas.TopP = map[string]float64{"XiaoShuai": persona}
+	p := testProcessor(t, nil)
+	p.sampling, p.personaSampling = cfg.sampling()
+
+	requested, err := requestedSampling(map[string]interface{}{temperatureMetadataKey: 1.5})
+	if err != nil {
+		t.Fatalf("requestedSampling: %v", err)
+	}
+	tests := []struct {
+		persona   string
+		requested samplingParams
+		want      openai.ChatCompletionRequest
+	}{
+		{"XiaoMei", samplingParams{}, openai.ChatCompletionRequest{Temperature: 0.7, TopP: 0.7, PresencePenalty: 0.7}},
+		{"XiaoShuai", samplingParams{}, openai.ChatCompletionRequest{Temperature: 0.3, TopP: 0.3, PresencePenalty: 0.7}},
+		{"XiaoShuai", requested, openai.ChatCompletionRequest{Temperature: 1.5, TopP: 0.3, PresencePenalty: 0.7}},
+	}
+	for _, test := range tests {
+		var got openai.ChatCompletionRequest
+		p.samplingFor(test.persona, test.requested).apply(&got)
+		if got.Temperature != test.want.Temperature || got.TopP != test.want.TopP ||
+			got.PresencePenalty != test.want.PresencePenalty || got.FrequencyPenalty != test.want.FrequencyPenalty {
+			t.Errorf("%s with %+v: temperature %v, top_p %v, presence %v, frequency %v; want %v, %v, %v, %v",
+				test.persona, test.requested, got.Temperature, got.TopP, got.PresencePenalty, got.FrequencyPenalty,
+				test.want.Temperature, test.want.TopP, test.want.PresencePenalty, test.want.FrequencyPenalty)
+		}
+	}
+}
+
+func TestRequestedSamplingRejectsOutOfRange(t *testing.T) {
+	for _, metadata := range []map[string]interface{}{
+		{temperatureMetadataKey: 2.5},
+		{topPMetadataKey: -0.1},
+		{presencePenaltyMetadataKey: 3.0},
+		{frequencyPenaltyMetadataKey: -2.5},
+		{temperatureMetadataKey: "hot"},
+	} {
+		if _, err := requestedSampling(metadata); !errors.Is(err, errInvalidSampling) {
+			t.Errorf("requestedSampling(%v) error = %v, want errInvalidSampling", metadata, err)
+		}
+	}
+	if _, err := requestedSampling(map[string]interface{}{temperatureMetadataKey: 2.0, topPMetadataKey: 0.0}); err != nil {
+		t.Errorf("requestedSampling accepted bounds: %v", err)
+	}
+}
+
+func TestZeroTemperatureIsSent(t *testing.T) {
+	requested, err := requestedSampling(map[string]interface{}{temperatureMetadataKey: 0.0})
+	if err != nil {
+		t.Fatalf("requestedSampling: %v", err)
+	}
+	var req openai.ChatCompletionRequest
+	requested.apply(&req)
+	if req.Temperature != math.SmallestNonzeroFloat32 {
+		t.Errorf("temperature = %v, want the smallest nonzero float32 so it is not omitted", req.Temperature)
+	}
+	var unset openai.ChatCompletionRequest
+	samplingParams{}.apply(&unset)
+	if unset.Temperature != 0 {
+		t.Errorf("unset temperature = %v, want it omitted", unset.Temperature)
+	}
+}
@@ -0,0 +1,43 @@
+// Sampling seeds for reproducible completions
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// seedMetadataKey is the message metadata key a client sets to a whole number to
+// seed the reply's completion, overriding OPENAI_SEED.
+const seedMetadataKey = "seed"
+
+// errInvalidSeed rejects a seed metadata value that is not a whole number.
+var errInvalidSeed = errors.New("invalid seed")
+
+// requestedSeed returns the seed asked for in metadata, or the configured seed
+// (nil for none) when metadata has no seed
+func (p *streamingTaskProcessor) requestedSeed(metadata map[string]interface{}) (*int, error) {
+	raw, ok := metadata[seedMetadataKey]
+	if !ok {
+		return p.seed, nil
+	}
+	// JSON numbers decode as float64.
+	value, ok := raw.(float64)
+	if !ok || value != math.Trunc(value) || value < math.MinInt32 || value > math.MaxInt32 {
+		return nil, fmt.Errorf("%w: the %q metadata field must be a whole number", errInvalidSeed, seedMetadataKey)
+	}
+	seed := int(value)
+	return &seed, nil
+}
+
+// seedMetadata adds the seed and the system fingerprint the reply was generated
+// with to artifact metadata, so a changed fingerprint shows that the same seed may
+// no longer reproduce a reply. Neither is added when unknown.
+func seedMetadata(metadata map[string]interface{}, seed *int, fingerprint string) {
+	if seed != nil {
+		metadata[seedMetadataKey] = *seed
+	}
+	if fingerprint != "" {
+		metadata["system_fingerprint"] = fingerprint
+	}
+}
@@ -0,0 +1,165 @@
+// Session state tracking for multi-turn conversations
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// sessionTTL is how long an idle session's state is retained before it is pruned.
+const sessionTTL = 30 * time.Minute
+
+// sessionState holds what we remember about a conversation between turns.
+type sessionState struct {
+	persona   string
+	updatedAt time.Time
+	// history holds the most recent exchanges, oldest first, when history is enabled;
+	// summary condenses older exchanges that were summarized away.
+	history []historyTurn
+	summary string
+	// question is the clarifying question asked on the previous turn, which the next
+	// message answers; "" when none is pending.
+	question string
+}
+
+// historyTurn is one completed exchange of a session.
+type historyTurn struct {
+	user      string
+	assistant string
+	persona   string
+	at        time.Time
+}
+
+// sessionStore keeps per-session state keyed by session ID.
+type sessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]*sessionState
+}
+
+// newSessionStore creates an empty session store
+func newSessionStore() *sessionStore {
+	return &sessionStore{
+		sessions: make(map[string]*sessionState),
+	}
+}
+
+// lastPersona returns the persona chosen on the previous turn of the session, or "" if none
+func (s *sessionStore) lastPersona(sessionID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.sessions[sessionID]
+	if !ok || time.Since(state.updatedAt) > sessionTTL {
+		return ""
+	}
+	return state.persona
+}
+
+// setPersona records the persona chosen for the current turn of the session.
+// It reports whether this is the session's first turn, which is true exactly once
+// per session even when turns race.
+func (s *sessionStore) setPersona(sessionID, persona string) (firstTurn bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pruneLocked()
+	state, ok := s.sessions[sessionID]
+	if !ok {
+		state = &sessionState{}
+		s.sessions[sessionID] = state
+	}
+	state.persona = persona
+	state.updatedAt = time.Now()
+	return !ok
+}
+
+// awaitAnswer records that persona asked question on the current turn of the session
+func (s *sessionStore) awaitAnswer(sessionID, persona, question string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.sessions[sessionID]
+	if !ok {
+		state = &sessionState{}
+		s.sessions[sessionID] = state
+	}
+	state.persona = persona
+	state.question = question
+	state.updatedAt = time.Now()
+}
+
+// takeQuestion returns and clears the session's pending clarifying question, or ""
+// if none is pending. The session's persona is the one that asked it.
+func (s *sessionStore) takeQuestion(sessionID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.sessions[sessionID]
+	if !ok || time.Since(state.updatedAt) > sessionTTL {
+		return ""
+	}
+	question := state.question
+	state.question = ""
+	return question
+}
+
+// pruneLocked drops sessions that have been idle longer than sessionTTL.
+// The caller must hold s.mu.
+func (s *sessionStore) pruneLocked() {
+	for id, state := range s.sessions {
+		if time.Since(state.updatedAt) > sessionTTL {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// history returns the session's summary of older exchanges and a copy of its recent
+// exchanges, oldest first
+func (s *sessionStore) history(sessionID string) (summary string, turns []historyTurn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.sessions[sessionID]
+	if !ok || time.Since(state.updatedAt) > sessionTTL {
+		return "", nil
+	}
+	return state.summary, append([]historyTurn(nil), state.history...)
+}
+
+// appendHistory records a completed exchange, keeping at most maxTurns exchanges
+func (s *sessionStore) appendHistory(sessionID string, turn historyTurn, maxTurns int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.sessions[sessionID]
+	if !ok {
+		state = &sessionState{persona: turn.persona}
+		s.sessions[sessionID] = state
+	}
+	state.history = append(state.history, turn)
+	if excess := len(state.history) - maxTurns; maxTurns > 0 && excess > 0 {
+		state.history = append([]historyTurn(nil), state.history[excess:]...)
+	}
+	state.updatedAt = time.Now()
+}
+
+// replaceWithSummary swaps the session's oldest exchanges, up to and including the
+// one recorded at through, for summary. It reports false, changing nothing, if
+// those exchanges are no longer stored, e.g. because a concurrent turn trimmed them.
+func (s *sessionStore) replaceWithSummary(sessionID string, through time.Time, summary string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.sessions[sessionID]
+	if !ok {
+		return false
+	}
+	for i, turn := range state.history {
+		if turn.at.Equal(through) {
+			state.history = append([]historyTurn(nil), state.history[i+1:]...)
+			state.summary = summary
+			return true
+		}
+	}
+	return false
+}
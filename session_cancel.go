@@ -0,0 +1,63 @@
+// Cancellation of all tasks in a session
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+)
+
+// errSessionCanceled is the cancellation cause of tasks stopped by CancelSession.
+var errSessionCanceled = errors.New("session canceled")
+
+// CancelSession cancels every task of sessionID that is being processed: their
+// OpenAI requests are aborted, their status becomes canceled, and TRTC conversations
+// are interrupted so the AI stops speaking. It returns the canceled task IDs.
+func (p *streamingTaskProcessor) CancelSession(sessionID string) []string {
+	canceled := p.tasks.cancelSession(sessionID, errSessionCanceled, nil)
+	taskIDs := make([]string, 0, len(canceled))
+	for taskID, task := range canceled {
+		taskIDs = append(taskIDs, taskID)
+		msg := protocol.NewMessage(
+			protocol.MessageRoleAgent,
+			[]protocol.Part{protocol.NewTextPart(fmt.Sprintf("Task canceled: session %s was canceled", sessionID))},
+		)
+		if err := task.handle.UpdateStatus(protocol.TaskStateCanceled, &msg); err != nil {
+			log.Printf("Task %s: failed to mark canceled: %v", taskID, err)
+		}
+		if validateTRTCTaskID(taskID) == nil {
+			if err := InterruptAIConversation(taskID); err != nil && !errors.Is(err, errAIConversationInactive) {
+				log.Printf("Task %s: TRTC interrupt after session cancel failed: %v", taskID, err)
+			}
+		}
+	}
+	sort.Strings(taskIDs)
+	log.Printf("Session %s canceled: %d active task(s) stopped %v", sessionID, len(taskIDs), taskIDs)
+	return taskIDs
+}
+
+// canceledBySession reports whether ctx was canceled by CancelSession
+func canceledBySession(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), errSessionCanceled)
+}
+
+// cancelSessionResponse is the body returned by POST /admin/sessions/{id}/cancel.
+type cancelSessionResponse struct {
+	SessionID string   `json:"sessionId"`
+	Canceled  []string `json:"canceled"`
+}
+
+// handleCancelSession cancels all active tasks of the session in the URL path
+func (p *streamingTaskProcessor) handleCancelSession(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.PathValue("id")
+	if sessionID == "" {
+		writeJSONError(w, http.StatusBadRequest, "session ID is required")
+		return
+	}
+	writeJSON(w, http.StatusOK, cancelSessionResponse{SessionID: sessionID, Canceled: p.CancelSession(sessionID)})
+}
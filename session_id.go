@@ -0,0 +1,108 @@
+// Derivation of the session a task belongs to
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Sources of a task's session ID, selected by SESSION_ID_STRATEGY
+const (
+	// sessionStrategySession uses the A2A sessionId, or the task ID without one.
+	sessionStrategySession = "session"
+	// sessionStrategyTask makes every task ID its own session, as TRTC task IDs are.
+	sessionStrategyTask = "task"
+	// sessionStrategyPrefix uses the task ID up to the first SESSION_ID_SEPARATOR.
+	sessionStrategyPrefix = "prefix"
+	// sessionStrategyMetadata uses the message metadata field SESSION_ID_METADATA_KEY.
+	sessionStrategyMetadata = "metadata"
+	// sessionStrategyHeader uses the HTTP request header SESSION_ID_HEADER.
+	sessionStrategyHeader = "header"
+)
+
+// sessionHeaderContextKey holds the session ID header of the request.
+const sessionHeaderContextKey contextKey = "sessionHeader"
+
+// sessionIDStrategy derives the session of a task, the one identity that history,
+// the per-session task limit, session cancellation and new-input interrupts of
+// TRTC conversations all key off. When its source is missing for a task, the
+// prefix, metadata and header strategies fall back to the session strategy.
+// A nil *sessionIDStrategy is the session strategy.
+type sessionIDStrategy struct {
+	kind        string
+	separator   string
+	metadataKey string
+	header      string
+}
+
+// newSessionIDStrategy creates the strategy configured by c
+func newSessionIDStrategy(c SessionConfig) *sessionIDStrategy {
+	return &sessionIDStrategy{
+		kind:        c.Strategy,
+		separator:   c.Separator,
+		metadataKey: c.MetadataKey,
+		header:      c.Header,
+	}
+}
+
+// sessionID returns the session of taskID, sent with the A2A sessionID (nil for
+// none) and message metadata, in a request whose context is ctx
+func (s *sessionIDStrategy) sessionID(ctx context.Context, taskID string, sessionID *string, metadata map[string]interface{}) string {
+	if s == nil {
+		return sessionOf(taskID, sessionID)
+	}
+	var id string
+	switch s.kind {
+	case sessionStrategyTask:
+		return taskID
+	case sessionStrategyPrefix:
+		id, _, _ = strings.Cut(taskID, s.separator)
+		if id == taskID {
+			id = ""
+		}
+	case sessionStrategyMetadata:
+		id, _ = metadata[s.metadataKey].(string)
+	case sessionStrategyHeader:
+		id, _ = ctx.Value(sessionHeaderContextKey).(string)
+	}
+	if id = strings.TrimSpace(id); id != "" {
+		return id
+	}
+	return sessionOf(taskID, sessionID)
+}
+
+// wrap records the session ID header in the request context when the strategy uses it
+func (s *sessionIDStrategy) wrap(next http.Handler) http.Handler {
+	if s == nil || s.kind != sessionStrategyHeader {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := strings.TrimSpace(r.Header.Get(s.header)); id != "" {
+			r = r.WithContext(context.WithValue(r.Context(), sessionHeaderContextKey, id))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// sessionOf returns sessionID if set, and taskID otherwise
+func sessionOf(taskID string, sessionID *string) string {
+	if sessionID != nil && *sessionID != "" {
+		return *sessionID
+	}
+	return taskID
+}
+
+// taskSession returns the session of taskID, whose message carries metadata, using
+// the A2A session ID the task manager recorded for it
+func (p *streamingTaskProcessor) taskSession(ctx context.Context, taskID string, metadata map[string]interface{}) string {
+	var sessionID *string
+	if p.taskManager != nil {
+		p.taskManager.TasksMutex.RLock()
+		if task, ok := p.taskManager.Tasks[taskID]; ok {
+			sessionID = task.SessionID
+		}
+		p.taskManager.TasksMutex.RUnlock()
+	}
+	return p.sessionIDs.sessionID(ctx, taskID, sessionID, metadata)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSessionIDStrategies(t *testing.T) {
+	config := SessionConfig{Separator: ":", MetadataKey: "session_id", Header: "X-Session-ID"}
+	a2aSession := "a2a-session"
+	headerCtx := context.WithValue(context.Background(), sessionHeaderContextKey, "header-session")
+	metadata := map[string]interface{}{"session_id": " metadata-session "}
+
+	tests := []struct {
+		name      string
+		strategy  string
+		ctx       context.Context
+		taskID    string
+		sessionID *string
+		metadata  map[string]interface{}
+		want      string
+	}{
+		{"session uses the A2A session", sessionStrategySession, context.Background(), "room42:turn7", &a2aSession, nil, "a2a-session"},
+		{"session falls back to the task", sessionStrategySession, context.Background(), "room42:turn7", nil, nil, "room42:turn7"},
+		{"task ignores the A2A session", sessionStrategyTask, context.Background(), "room42:turn7", &a2aSession, metadata, "room42:turn7"},
+		{"prefix", sessionStrategyPrefix, context.Background(), "room42:turn7", &a2aSession, nil, "room42"},
+		{"prefix without separator", sessionStrategyPrefix, context.Background(), "room42", &a2aSession, nil, "a2a-session"},
+		{"metadata", sessionStrategyMetadata, context.Background(), "task-1", &a2aSession, metadata, "metadata-session"},
+		{"metadata missing", sessionStrategyMetadata, context.Background(), "task-1", nil, nil, "task-1"},
+		{"header", sessionStrategyHeader, headerCtx, "task-1", &a2aSession, metadata, "header-session"},
+		{"header missing", sessionStrategyHeader, context.Background(), "task-1", &a2aSession, nil, "a2a-session"},
+	}
+	for _, test := range tests {
+		config.Strategy = test.strategy
+		got := newSessionIDStrategy(config).sessionID(test.ctx, test.taskID, test.sessionID, test.metadata)
+		if got != test.want {
+			t.Errorf("%s: sessionID = %q, want %q", test.name, got, test.want)
+		}
+	}
+	var unset *sessionIDStrategy
+	if got := unset.sessionID(context.Background(), "task-1", &a2aSession, nil); got != "a2a-session" {
+		t.Errorf("nil strategy: sessionID = %q, want %q", got, "a2a-session")
+	}
+}
+
+func TestSessionIDHeaderIsRecorded(t *testing.T) {
+	strategy := newSessionIDStrategy(SessionConfig{Strategy: sessionStrategyHeader, Header: "X-Session-ID"})
+	var got string
+	handler := strategy.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = strategy.sessionID(r.Context(), "task-1", nil, nil)
+	}))
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("X-Session-ID", "from-header")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if got != "from-header" {
+		t.Errorf("sessionID = %q, want %q", got, "from-header")
+	}
+}
@@ -0,0 +1,107 @@
+// Per-session limit on tasks processed at once
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// errSessionBusy is returned when a session already has as many tasks in progress
+// as MAX_CONCURRENT_TASKS_PER_SESSION allows and its new task could not wait.
+var errSessionBusy = errors.New("session busy: a previous message of this session is still being processed, please try again later")
+
+// sessionLimiter bounds the tasks of each session processed at once, so one TRTC
+// session cannot flood the server with overlapping turns. With a limit of 1 a
+// session's turns run one after another, in arrival order, which also keeps its
+// history consistent. A nil *sessionLimiter imposes no limit.
+type sessionLimiter struct {
+	limit        int
+	queueTimeout time.Duration
+	policy       string
+
+	mu       sync.Mutex
+	sessions map[string]*sessionSlots
+}
+
+// sessionSlots are the slots of one session; users counts the tasks holding or
+// waiting for one, so idle sessions are forgotten.
+type sessionSlots struct {
+	slots chan struct{}
+	users int
+}
+
+// newSessionLimiter creates a limiter allowing limit tasks per session, or nil if limit <= 0
+func newSessionLimiter(limit int, queueTimeout time.Duration, policy string) *sessionLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	if policy != busyPolicyReject {
+		policy = busyPolicyQueue
+	}
+	return &sessionLimiter{
+		limit:        limit,
+		queueTimeout: queueTimeout,
+		policy:       policy,
+		sessions:     make(map[string]*sessionSlots),
+	}
+}
+
+// acquire takes one of session's slots, waiting up to queueTimeout under the queue
+// policy. On success the returned release func must be called once the task is done.
+func (l *sessionLimiter) acquire(ctx context.Context, session string) (func(), error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	s := l.join(session)
+	release := func() {
+		<-s.slots
+		l.leave(session, s)
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if l.policy == busyPolicyReject {
+		l.leave(session, s)
+		return nil, errSessionBusy
+	}
+
+	timer := time.NewTimer(l.queueTimeout)
+	defer timer.Stop()
+	select {
+	case s.slots <- struct{}{}:
+		return release, nil
+	case <-timer.C:
+		l.leave(session, s)
+		return nil, errSessionBusy
+	case <-ctx.Done():
+		l.leave(session, s)
+		return nil, ctx.Err()
+	}
+}
+
+// join returns session's slots, counting the caller as a user
+func (l *sessionLimiter) join(session string) *sessionSlots {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, ok := l.sessions[session]
+	if !ok {
+		s = &sessionSlots{slots: make(chan struct{}, l.limit)}
+		l.sessions[session] = s
+	}
+	s.users++
+	return s
+}
+
+// leave drops the caller as a user of session's slots, forgetting them once unused
+func (l *sessionLimiter) leave(session string, s *sessionSlots) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if s.users--; s.users == 0 {
+		delete(l.sessions, session)
+	}
+}
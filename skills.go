@@ -0,0 +1,89 @@
+// Per-deployment selection of the skills the agent offers
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"trpc.group/trpc-go/trpc-a2a-go/server"
+)
+
+// skillMetadataKey is the message metadata field a client sets to the ID of the
+// agent card skill it wants; a persona skill makes that persona answer.
+const skillMetadataKey = "skill"
+
+// errSkillDisabled rejects a request for a skill this deployment does not offer.
+var errSkillDisabled = errors.New("skill not enabled")
+
+// skillFilter is the ENABLED_SKILLS allowlist of agent card skill IDs. A nil
+// filter enables every skill.
+type skillFilter map[string]bool
+
+// newSkillFilter returns the filter enabling ids, or nil if there are none
+func newSkillFilter(ids []string) skillFilter {
+	if len(ids) == 0 {
+		return nil
+	}
+	filter := make(skillFilter, len(ids))
+	for _, id := range ids {
+		filter[id] = true
+	}
+	return filter
+}
+
+// allows reports whether the skill with the given ID is enabled
+func (f skillFilter) allows(id string) bool {
+	return f == nil || f[id]
+}
+
+// filter returns the enabled skills among skills
+func (f skillFilter) filter(skills []server.AgentSkill) []server.AgentSkill {
+	if f == nil {
+		return skills
+	}
+	enabled := make([]server.AgentSkill, 0, len(skills))
+	for _, skill := range skills {
+		if f[skill.ID] {
+			enabled = append(enabled, skill)
+		}
+	}
+	return enabled
+}
+
+// checkPersona returns an error if the persona's skill is disabled. The guard
+// persona is not a skill and always answers.
+func (f skillFilter) checkPersona(prompts *promptSet, persona string) error {
+	if prompts.isGuard(persona) || f.allows(personaSkillPrefix+persona) {
+		return nil
+	}
+	return fmt.Errorf("%w: the %s assistant (skill %q) is not available on this server",
+		errSkillDisabled, persona, personaSkillPrefix+persona)
+}
+
+// requestedSkill returns the persona named by the skill in message metadata, or ""
+// when the metadata names none or a skill that is not a persona. Unknown and
+// disabled skills are rejected.
+func (p *streamingTaskProcessor) requestedSkill(prompts *promptSet, metadata map[string]interface{}) (string, error) {
+	raw, ok := metadata[skillMetadataKey]
+	if !ok {
+		return "", nil
+	}
+	id, _ := raw.(string)
+	if id = strings.TrimSpace(id); id == "" {
+		return "", fmt.Errorf("%w: the %q metadata field must be a skill ID", errSkillDisabled, skillMetadataKey)
+	}
+	for _, skill := range currentAgentCard(p.agentCard, prompts).Skills {
+		if skill.ID != id {
+			continue
+		}
+		if !p.skills.allows(id) {
+			return "", fmt.Errorf("%w: skill %q is not enabled on this server", errSkillDisabled, id)
+		}
+		if persona, ok := strings.CutPrefix(id, personaSkillPrefix); ok {
+			return persona, nil
+		}
+		return "", nil
+	}
+	return "", fmt.Errorf("%w: unknown skill %q", errSkillDisabled, id)
+}
@@ -0,0 +1,132 @@
+// Text-to-speech of the final response, attached as an audio artifact
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+	"trpc.group/trpc-go/trpc-a2a-go/taskmanager"
+)
+
+// speechProviderOpenAI selects OpenAI's speech endpoint via SPEECH_PROVIDER.
+const speechProviderOpenAI = "openai"
+
+// speechMetadataKey is the message metadata flag a client sets to request an audio artifact.
+const speechMetadataKey = "tts"
+
+// speechSynthesizer converts text to audio spoken in voice, returning the audio and its MIME type.
+type speechSynthesizer func(ctx context.Context, text, voice string) (audio []byte, mimeType string, err error)
+
+// newSpeechSynthesizer returns the synthesizer for provider, nil when provider is empty,
+// or an error for an unknown provider
+func newSpeechSynthesizer(provider string, client *openai.Client, model string) (speechSynthesizer, error) {
+	switch provider {
+	case "":
+		return nil, nil
+	case speechProviderOpenAI:
+		return openAISpeechSynthesizer(client, model), nil
+	default:
+		return nil, fmt.Errorf("unknown SPEECH_PROVIDER %q, expected %q", provider, speechProviderOpenAI)
+	}
+}
+
+// openAISpeechSynthesizer synthesizes MP3 audio with OpenAI's speech endpoint
+func openAISpeechSynthesizer(client *openai.Client, model string) speechSynthesizer {
+	return func(ctx context.Context, text, voice string) ([]byte, string, error) {
+		resp, err := client.CreateSpeech(ctx, openai.CreateSpeechRequest{
+			Model:          openai.SpeechModel(model),
+			Input:          text,
+			Voice:          openai.SpeechVoice(voice),
+			ResponseFormat: openai.SpeechResponseFormatMp3,
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("speech synthesis failed: %w", err)
+		}
+		defer resp.Close()
+		audio, err := io.ReadAll(resp)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read synthesized speech: %w", err)
+		}
+		return audio, "audio/mpeg", nil
+	}
+}
+
+// speechSettings decides which replies get an audio artifact and in which voice.
+type speechSettings struct {
+	synthesizer speechSynthesizer
+	// personas always get an audio artifact; other personas only when the request asks.
+	personas map[string]bool
+	// voices maps personas to voices; others use defaultVoice.
+	voices       map[string]string
+	defaultVoice string
+}
+
+// wanted reports whether the turn's reply should be synthesized
+func (s *speechSettings) wanted(turn *completionTurn) bool {
+	if s == nil || s.synthesizer == nil {
+		return false
+	}
+	requested, _ := turn.metadata[speechMetadataKey].(bool)
+	return requested || s.personas[turn.intent]
+}
+
+// voiceFor returns the voice the persona speaks with
+func (s *speechSettings) voiceFor(persona string) string {
+	if voice := s.voices[persona]; voice != "" {
+		return voice
+	}
+	return s.defaultVoice
+}
+
+// addSpeechArtifact synthesizes the reply and attaches it as an audio file artifact.
+// Synthesis is best effort: a failure is logged and the task completes without audio.
+func (p *streamingTaskProcessor) addSpeechArtifact(
+	ctx context.Context,
+	taskID string,
+	turn *completionTurn,
+	reply string,
+	index int,
+	handle taskmanager.TaskHandle,
+) {
+	if reply == "" || !p.speech.wanted(turn) {
+		return
+	}
+	voice := p.speech.voiceFor(turn.intent)
+	start := time.Now()
+	audio, mimeType, err := p.speech.synthesizer(ctx, reply, voice)
+	if err != nil {
+		log.Printf("Task %s: skipping audio artifact: %v", taskID, err)
+		return
+	}
+	log.Printf("Task %s: synthesized %d bytes of speech in %v", taskID, len(audio), time.Since(start))
+
+	artifact := protocol.Artifact{
+		Name:        stringPtr("Speech"),
+		Description: stringPtr("Spoken version of the response"),
+		Index:       index,
+		Parts: []protocol.Part{protocol.FilePart{
+			Type: protocol.PartTypeFile,
+			File: protocol.FileContent{
+				Name:     stringPtr("response.mp3"),
+				MimeType: stringPtr(mimeType),
+				Bytes:    stringPtr(base64.StdEncoding.EncodeToString(audio)),
+			},
+		}},
+		LastChunk: boolPtr(true),
+		Metadata: map[string]interface{}{
+			"timestamp":   time.Now().UnixNano(),
+			"voice":       voice,
+			"persona":     turn.intent,
+			"audio_bytes": len(audio),
+		},
+	}
+	if err := handle.AddArtifact(artifact); err != nil {
+		log.Printf("Error adding audio artifact for task %s: %v", taskID, err)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+)
+
+// testStopSequence is the OPENAI_STOP_SEQUENCES entry of the tests.
+const testStopSequence = "###"
+
+// artifactText joins the text of every artifact the handle recorded.
+func artifactText(h *fakeHandle) string {
+	var text strings.Builder
+	for _, artifact := range h.artifacts {
+		for _, part := range artifact.Parts {
+			if textPart, ok := part.(protocol.TextPart); ok {
+				text.WriteString(textPart.Text)
+			}
+		}
+	}
+	return text.String()
+}
+
+// checkStopped fails unless the task completed with a reply free of the stop sequence.
+func checkStopped(t *testing.T, handle *fakeHandle, stop []string, finish string) {
+	t.Helper()
+	if len(stop) != 1 || stop[0] != testStopSequence {
+		t.Errorf("request stop = %q, want [%q]", stop, testStopSequence)
+	}
+	if got := handle.states[len(handle.states)-1]; got != protocol.TaskStateCompleted {
+		t.Errorf("final state = %s, want completed", got)
+	}
+	if text := artifactText(handle); text != "The answer is 42." || strings.Contains(text, testStopSequence) {
+		t.Errorf("artifact text = %q, want the reply without the stop sequence", text)
+	}
+	if finish != string(openai.FinishReasonStop) {
+		t.Errorf("finish reason = %q, want stop", finish)
+	}
+}
+
+func TestStopSequencesStreaming(t *testing.T) {
+	var stop []string
+	client := streamServer(t, func(r *http.Request, send func(openai.ChatCompletionStreamResponse)) {
+		var req openai.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			t.Errorf("decode stream request: %v", err)
+		}
+		stop = req.Stop
+		send(textDelta("The answer "))
+		send(textDelta("is 42."))
+		send(openai.ChatCompletionStreamResponse{Choices: []openai.ChatCompletionStreamChoice{
+			{FinishReason: openai.FinishReasonStop},
+		}})
+	})
+	p := testProcessor(t, client)
+	p.stopSequences = []string{testStopSequence}
+	turn := testTurn(t, "what is the answer?")
+
+	handle, err := runStream(t, context.Background(), p, turn)
+	if err != nil {
+		t.Fatalf("processWithOpenAIStreaming: %v", err)
+	}
+	checkStopped(t, handle, stop, string(turn.finishReason))
+}
+
+func TestStopSequencesNonStreaming(t *testing.T) {
+	var stop []string
+	srv := completionServer(t, func(req openai.ChatCompletionRequest) string {
+		stop = req.Stop
+		// Some compatible backends echo the matched stop sequence.
+		return "The answer is 42." + testStopSequence
+	})
+	p := testProcessor(t, srv)
+	p.stopSequences = []string{testStopSequence}
+	turn := testTurn(t, "what is the answer?")
+	handle := &fakeHandle{}
+
+	if err := p.processNonStreaming(context.Background(), "task-1", turn, handle); err != nil {
+		t.Fatalf("processNonStreaming: %v", err)
+	}
+	checkStopped(t, handle, stop, string(turn.finishReason))
+}
@@ -0,0 +1,250 @@
+// Bounded hand-off between the OpenAI stream reader and the task event emitter
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+	"trpc.group/trpc-go/trpc-a2a-go/taskmanager"
+)
+
+// Policies for a full stream buffer, selected by STREAM_BUFFER_POLICY
+const (
+	streamBufferBlock      = "block"
+	streamBufferDropOldest = "drop-oldest"
+)
+
+// errArtifactEmission fails a streamed task once STREAM_ARTIFACT_FAILURE_LIMIT
+// artifact updates in a row could not be delivered.
+var errArtifactEmission = errors.New("the reply could not be delivered to the client")
+
+// streamChunk is one batch of streamed text waiting to be emitted.
+type streamChunk struct {
+	content string
+	// totalLength is the response length in bytes once this chunk is included.
+	totalLength int
+	// outputChars is the response length in characters, for the progress estimate.
+	outputChars int
+	// toolCall, when set, is a tool-call artifact to emit instead of text, for the
+	// call at position toolCallIndex; the emitter assigns its artifact index.
+	toolCall      *protocol.Artifact
+	toolCallIndex int
+}
+
+// chunkEmitter emits streamed chunks as status updates and artifacts from its own
+// goroutine, so a slow SSE consumer does not stall reading from OpenAI. Chunks wait
+// in a bounded buffer; when it is full the reader either blocks or the oldest
+// waiting chunk is dropped, depending on the policy. After failureLimit artifact
+// updates in a row have failed, failed is closed and later chunks are discarded;
+// a failureLimit of 0 only logs failures.
+type chunkEmitter struct {
+	taskID       string
+	handle       taskmanager.TaskHandle
+	model        string
+	policy       string
+	progress     progressEstimator
+	failureLimit int
+
+	chunks    chan streamChunk
+	done      chan struct{}
+	closeOnce sync.Once
+	// failed is closed once failErr is set.
+	failed  chan struct{}
+	failErr error
+
+	// emitted, failures, next, lastChunk and toolCalls are owned by the emitter
+	// goroutine until done is closed. emitted counts text chunks; next is the next
+	// free artifact index, shared with tool calls, and lastChunk the index of the
+	// latest text chunk. toolCalls maps tool call positions to their artifact index.
+	emitted   int
+	failures  int
+	next      int
+	lastChunk int
+	toolCalls map[int]int
+	// dropped is owned by the producer.
+	dropped int
+}
+
+// newChunkEmitter starts an emitter with room for size waiting chunks (at least 1)
+func newChunkEmitter(
+	taskID string,
+	handle taskmanager.TaskHandle,
+	model string,
+	size int,
+	policy string,
+	progress progressEstimator,
+	failureLimit int,
+) *chunkEmitter {
+	if size < 1 {
+		size = 1
+	}
+	e := &chunkEmitter{
+		taskID:       taskID,
+		handle:       handle,
+		model:        model,
+		policy:       policy,
+		progress:     progress,
+		failureLimit: failureLimit,
+		chunks:       make(chan streamChunk, size),
+		done:         make(chan struct{}),
+		failed:       make(chan struct{}),
+		toolCalls:    make(map[int]int),
+	}
+	go e.run()
+	return e
+}
+
+// resume returns an emitter for chunks of model that continues the numbering, drop
+// count and failure count of e, after e has been closed
+func (e *chunkEmitter) resume(model string) *chunkEmitter {
+	next := &chunkEmitter{
+		taskID:       e.taskID,
+		handle:       e.handle,
+		model:        model,
+		policy:       e.policy,
+		progress:     e.progress,
+		failureLimit: e.failureLimit,
+		chunks:       make(chan streamChunk, cap(e.chunks)),
+		done:         make(chan struct{}),
+		failed:       make(chan struct{}),
+		emitted:      e.emitted,
+		failures:     e.failures,
+		next:         e.next,
+		lastChunk:    e.lastChunk,
+		toolCalls:    e.toolCalls,
+		dropped:      e.dropped,
+	}
+	go next.run()
+	return next
+}
+
+// send queues a chunk for emission. With the block policy it waits for room or ctx;
+// with drop-oldest it never waits, discarding the oldest queued chunk instead.
+// Once emission has failed, send returns errArtifactEmission.
+func (e *chunkEmitter) send(ctx context.Context, chunk streamChunk) error {
+	if err := e.err(); err != nil {
+		return err
+	}
+	if e.policy != streamBufferDropOldest {
+		select {
+		case e.chunks <- chunk:
+			return nil
+		case <-e.failed:
+			return e.failErr
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	for {
+		select {
+		case e.chunks <- chunk:
+			return nil
+		default:
+		}
+		select {
+		case <-e.chunks:
+			e.dropped++
+			log.Printf("Task %s: stream buffer full, dropped oldest chunk (%d dropped so far)", e.taskID, e.dropped)
+		default:
+		}
+	}
+}
+
+// close stops accepting chunks, waits for queued ones to be emitted and returns
+// the number of artifact indices used, which is the index of the next artifact.
+// It is safe to call more than once.
+func (e *chunkEmitter) close() int {
+	e.closeOnce.Do(func() { close(e.chunks) })
+	<-e.done
+	return e.next
+}
+
+// err returns the emission failure once failureLimit has been reached, or nil
+func (e *chunkEmitter) err() error {
+	select {
+	case <-e.failed:
+		return e.failErr
+	default:
+		return nil
+	}
+}
+
+// run emits queued chunks until the buffer is closed
+func (e *chunkEmitter) run() {
+	defer close(e.done)
+	for chunk := range e.chunks {
+		if e.err() != nil {
+			continue
+		}
+		if chunk.toolCall != nil {
+			e.emitToolCall(chunk)
+			continue
+		}
+		log.Printf("Task %s: Sending chunk %d, content length: %d",
+			e.taskID, e.emitted+1, len(chunk.content))
+
+		statusMsg := protocol.NewMessage(
+			protocol.MessageRoleAgent,
+			[]protocol.Part{protocol.NewTextPart(chunk.content)},
+		)
+		statusMsg.Metadata = e.progress.metadata(chunk.outputChars)
+
+		if err := e.handle.UpdateStatus(protocol.TaskStateWorking, &statusMsg); err != nil {
+			log.Printf("Error updating progress status for task %s: %v", e.taskID, err)
+		}
+
+		chunkArtifact := protocol.Artifact{
+			Name:        stringPtr(fmt.Sprintf("Chunk %d", e.emitted+1)),
+			Description: stringPtr("Streaming chunk from OpenAI"),
+			Index:       e.next,
+			Parts:       []protocol.Part{protocol.NewTextPart(chunk.content)},
+			Append:      boolPtr(e.emitted > 0),
+			Metadata: map[string]interface{}{
+				"timestamp":    time.Now().UnixNano(),
+				"chunk_size":   len(chunk.content),
+				"chunk_index":  e.emitted,
+				"total_length": chunk.totalLength,
+				"model":        e.model,
+				"is_streaming": true,
+			},
+		}
+
+		if err := e.handle.AddArtifact(chunkArtifact); err != nil {
+			log.Printf("Error adding artifact for chunk %d of task %s: %v", e.emitted+1, e.taskID, err)
+			e.failures++
+			if e.failureLimit > 0 && e.failures >= e.failureLimit {
+				log.Printf("Task %s: %d artifact updates failed in a row, stopping the stream", e.taskID, e.failures)
+				e.failErr = fmt.Errorf("%w: %d artifact updates failed in a row: %v", errArtifactEmission, e.failures, err)
+				close(e.failed)
+			}
+		} else {
+			e.failures = 0
+		}
+
+		e.lastChunk = e.next
+		e.emitted++
+		e.next++
+	}
+}
+
+// emitToolCall emits a tool-call artifact, appending to the artifact of an earlier
+// delta of the same call or taking the next free index for a new call
+func (e *chunkEmitter) emitToolCall(chunk streamChunk) {
+	index, seen := e.toolCalls[chunk.toolCallIndex]
+	if !seen {
+		index = e.next
+		e.toolCalls[chunk.toolCallIndex] = index
+		e.next++
+	}
+	artifact := *chunk.toolCall
+	artifact.Index = index
+	artifact.Append = boolPtr(seen)
+	if err := e.handle.AddArtifact(artifact); err != nil {
+		log.Printf("Error adding tool call artifact for task %s: %v", e.taskID, err)
+	}
+}
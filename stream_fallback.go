@@ -0,0 +1,49 @@
+// Fallback to a non-streaming completion when a stream breaks mid-response
+package main
+
+import (
+	"context"
+	"errors"
+)
+
+// streamFallbackMetadataKey flags the reply artifact of a task that fell back to non-streaming.
+const streamFallbackMetadataKey = "stream_fallback"
+
+// streamBrokenError is a stream that failed while being read, after chunks chunk
+// artifacts had reached the client.
+type streamBrokenError struct {
+	err    error
+	chunks int
+}
+
+func (e *streamBrokenError) Error() string {
+	return e.err.Error()
+}
+
+func (e *streamBrokenError) Unwrap() error {
+	return e.err
+}
+
+// brokenStreamFallback returns the broken stream a streamed turn that failed with
+// err is retried for without streaming, or nil. With STREAM_FALLBACK_NONSTREAM it
+// is retried when the stream broke after at most STREAM_FALLBACK_MAX_CHUNKS chunks,
+// the task is still running and no handoff has happened, which the full reply
+// could not repeat.
+func (p *streamingTaskProcessor) brokenStreamFallback(ctx context.Context, turn *completionTurn, err error) *streamBrokenError {
+	var broken *streamBrokenError
+	if !p.streamFallback || !errors.As(err, &broken) || broken.chunks > p.streamFallbackMaxChunks ||
+		ctx.Err() != nil || turn.handoffFrom != "" {
+		return nil
+	}
+	return broken
+}
+
+// streamFallbackMetadata records on the reply artifact that it replaces a broken
+// stream, and how many chunks of it the client had already received
+func streamFallbackMetadata(metadata map[string]interface{}, turn *completionTurn) {
+	if turn.streamFallback == nil {
+		return
+	}
+	metadata[streamFallbackMetadataKey] = true
+	metadata["stream_fallback_chunks"] = turn.streamFallback.chunks
+}
@@ -0,0 +1,230 @@
+// Replayable per-task logs for debugging
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+	"trpc.group/trpc-go/trpc-a2a-go/taskmanager"
+)
+
+// Task log entry types, in the order a task produces them
+const (
+	taskLogInput    = "input"
+	taskLogPersona  = "persona"
+	taskLogStatus   = "status"
+	taskLogArtifact = "artifact"
+	taskLogEnd      = "end"
+)
+
+// taskLogEntry is one line of a task log. Message is the user's message on input
+// entries and the status message on status entries; Error is the error Process
+// returned, on end entries.
+type taskLogEntry struct {
+	Time           time.Time          `json:"time"`
+	TaskID         string             `json:"task_id"`
+	Type           string             `json:"type"`
+	Message        *protocol.Message  `json:"message,omitempty"`
+	State          protocol.TaskState `json:"state,omitempty"`
+	Artifact       *protocol.Artifact `json:"artifact,omitempty"`
+	Persona        string             `json:"persona,omitempty"`
+	IntentFallback bool               `json:"intent_fallback,omitempty"`
+	Error          string             `json:"error,omitempty"`
+}
+
+// plainTaskID matches task IDs that can be used as file names as they are.
+var plainTaskID = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+
+// taskLogFile returns the name of the log file for taskID. IDs that are not safe
+// file names are hashed, so a task ID can never point outside the log directory.
+func taskLogFile(taskID string) string {
+	if plainTaskID.MatchString(taskID) {
+		return taskID + ".jsonl"
+	}
+	sum := sha256.Sum256([]byte(taskID))
+	return "task-" + hex.EncodeToString(sum[:8]) + ".jsonl"
+}
+
+// taskRecorder writes a JSONL log per task ID to dir: each message sent to the task,
+// the persona that answered, every status update and artifact the client was sent,
+// and how processing ended. Later messages for the same task ID are appended, so
+// a file replays the whole conversation. A nil *taskRecorder records nothing.
+type taskRecorder struct {
+	dir string
+}
+
+// newTaskRecorder returns nil when recording is disabled, and creates dir otherwise
+func newTaskRecorder(enabled bool, dir string) (*taskRecorder, error) {
+	if !enabled {
+		return nil, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create TASK_LOG_DIR: %w", err)
+	}
+	return &taskRecorder{dir: dir}, nil
+}
+
+// start returns the log of one message's processing; nothing is written until it is used
+func (r *taskRecorder) start(taskID string) *taskLog {
+	if r == nil {
+		return nil
+	}
+	return &taskLog{taskID: taskID, path: filepath.Join(r.dir, taskLogFile(taskID))}
+}
+
+// taskLog appends the entries of one message's processing to the task's file.
+// A nil *taskLog records nothing. Recording never fails a task: write errors
+// are logged once and the rest of the entries dropped.
+type taskLog struct {
+	taskID string
+	path   string
+
+	mu     sync.Mutex
+	file   *os.File
+	failed bool
+}
+
+// write appends entry, opening the file on first use
+func (l *taskLog) write(entry taskLogEntry) {
+	if l == nil {
+		return
+	}
+	entry.Time = time.Now()
+	entry.TaskID = l.taskID
+	line, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("Task %s: failed to encode task log entry: %v", l.taskID, err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failed {
+		return
+	}
+	if l.file == nil {
+		if l.file, err = os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+			l.fail(err)
+			return
+		}
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		l.fail(err)
+	}
+}
+
+// fail stops recording after an I/O error; the caller holds l.mu
+func (l *taskLog) fail(err error) {
+	log.Printf("Task %s: task log %s disabled: %v", l.taskID, l.path, err)
+	l.failed = true
+}
+
+// input records the message the task was sent
+func (l *taskLog) input(message protocol.Message) {
+	l.write(taskLogEntry{Type: taskLogInput, Message: &message})
+}
+
+// persona records the persona chosen by intent detection
+func (l *taskLog) persona(persona string, intentFallback bool) {
+	l.write(taskLogEntry{Type: taskLogPersona, Persona: persona, IntentFallback: intentFallback})
+}
+
+// finish records how processing ended and closes the file
+func (l *taskLog) finish(err error) {
+	if l == nil {
+		return
+	}
+	entry := taskLogEntry{Type: taskLogEnd}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	l.write(entry)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		if err := l.file.Close(); err != nil {
+			log.Printf("Task %s: failed to close task log: %v", l.taskID, err)
+		}
+		l.file = nil
+	}
+}
+
+// wrap returns handle with its status updates and artifacts recorded
+func (l *taskLog) wrap(handle taskmanager.TaskHandle) taskmanager.TaskHandle {
+	if l == nil {
+		return handle
+	}
+	return &taskLogHandle{TaskHandle: handle, log: l}
+}
+
+// taskLogHandle records what passes through it to a taskLog.
+type taskLogHandle struct {
+	taskmanager.TaskHandle
+	log *taskLog
+}
+
+// UpdateStatus implements taskmanager.TaskHandle
+func (h *taskLogHandle) UpdateStatus(state protocol.TaskState, msg *protocol.Message) error {
+	h.log.write(taskLogEntry{Type: taskLogStatus, State: state, Message: msg})
+	return h.TaskHandle.UpdateStatus(state, msg)
+}
+
+// AddArtifact implements taskmanager.TaskHandle
+func (h *taskLogHandle) AddArtifact(artifact protocol.Artifact) error {
+	h.log.write(taskLogEntry{Type: taskLogArtifact, Artifact: &artifact})
+	return h.TaskHandle.AddArtifact(artifact)
+}
+
+// loadTaskLog parses the log of taskID from dir, oldest entry first
+func loadTaskLog(dir, taskID string) ([]taskLogEntry, error) {
+	file, err := os.Open(filepath.Join(dir, taskLogFile(taskID)))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []taskLogEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), maxRequestBodyBytes*4)
+	for line := 1; scanner.Scan(); line++ {
+		var entry taskLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// handleTaskLog returns the recorded log of the task in the URL path
+func (r *taskRecorder) handleTaskLog(w http.ResponseWriter, req *http.Request) {
+	if r == nil {
+		writeJSONError(w, http.StatusNotFound, "task recording is disabled; set RECORD_TASKS=true and TASK_LOG_DIR")
+		return
+	}
+	taskID := req.PathValue("id")
+	entries, err := loadTaskLog(r.dir, taskID)
+	if errors.Is(err, fs.ErrNotExist) {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("no log recorded for task %q", taskID))
+		return
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, fmt.Sprintf("failed to read task log: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"taskId": taskID, "entries": entries})
+}
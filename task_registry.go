@@ -0,0 +1,165 @@
+// Tracking of the tasks being processed
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+	"trpc.group/trpc-go/trpc-a2a-go/taskmanager"
+)
+
+// errDuplicateTask rejects a message for a task ID whose previous message is still being processed.
+var errDuplicateTask = errors.New("task is already being processed; wait for it to finish or cancel it before sending another message")
+
+// activeTask is a task that is being processed.
+type activeTask struct {
+	started time.Time
+	session string
+	// persona answers the task once intent detection has picked it, "" before.
+	persona string
+	// phase, state, outputLength, artifacts and updated follow the task's updates,
+	// as seen by track, for GET /admin/tasks.
+	phase        string
+	state        protocol.TaskState
+	outputLength int
+	artifacts    int
+	updated      time.Time
+	// done is closed when processing has finished.
+	done chan struct{}
+	// handle is the task's unwrapped handle, for reporting a cancellation from outside Process.
+	handle taskmanager.TaskHandle
+	cancel context.CancelCauseFunc
+}
+
+// taskRegistry holds the tasks being processed, so a second message for the same
+// task ID cannot run alongside the first and interleave its artifacts, and so all
+// tasks of a session can be canceled together.
+type taskRegistry struct {
+	mu     sync.Mutex
+	active map[string]*activeTask
+}
+
+// newTaskRegistry creates an empty task registry
+func newTaskRegistry() *taskRegistry {
+	return &taskRegistry{active: make(map[string]*activeTask)}
+}
+
+// begin registers taskID as active in session and returns its entry, or nil if it
+// already is active. cancel stops the task's processing.
+func (r *taskRegistry) begin(
+	taskID, session string,
+	handle taskmanager.TaskHandle,
+	cancel context.CancelCauseFunc,
+) *activeTask {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.active[taskID]; ok {
+		return nil
+	}
+	now := time.Now()
+	task := &activeTask{
+		started: now,
+		session: session,
+		phase:   phaseReceived,
+		updated: now,
+		done:    make(chan struct{}),
+		handle:  handle,
+		cancel:  cancel,
+	}
+	r.active[taskID] = task
+	return task
+}
+
+// setPersona records the persona answering taskID
+func (r *taskRegistry) setPersona(taskID, persona string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if task, ok := r.active[taskID]; ok {
+		task.persona = persona
+	}
+}
+
+// end removes taskID once its processing has finished, however it ended
+func (r *taskRegistry) end(taskID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if task, ok := r.active[taskID]; ok {
+		close(task.done)
+		delete(r.active, taskID)
+	}
+}
+
+// superseded reports whether a task other than task is being processed for taskID,
+// i.e. a later message reusing the ID of a task that has ended
+func (r *taskRegistry) superseded(taskID string, task *activeTask) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	current, ok := r.active[taskID]
+	return ok && current != task
+}
+
+// isActive reports whether taskID is being processed
+func (r *taskRegistry) isActive(taskID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.active[taskID]
+	return ok
+}
+
+// cancelSession cancels the active tasks of session that match, or all of them if
+// match is nil, with cause and returns them by task ID
+func (r *taskRegistry) cancelSession(session string, cause error, match func(taskID string, task *activeTask) bool) map[string]*activeTask {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	canceled := make(map[string]*activeTask)
+	for taskID, task := range r.active {
+		if task.session == session && (match == nil || match(taskID, task)) {
+			task.cancel(cause)
+			canceled[taskID] = task
+		}
+	}
+	return canceled
+}
+
+// rejectDuplicateTask logs and returns the error for a message sent to an active task
+func rejectDuplicateTask(taskID string) error {
+	log.Printf("Task %s rejected: its previous message is still being processed", taskID)
+	return fmt.Errorf("%w: %s", errDuplicateTask, taskID)
+}
+
+// duplicateTaskGuard rejects messages for active tasks before the task manager
+// records them. Process rejects duplicates too, but by then the task manager has
+// already stored the message and would mark the shared task failed, ending the
+// first request's stream; checking here first keeps the first task intact.
+// Before checking, it lets a new message interrupt the session's ongoing TRTC
+// response where that is enabled.
+type duplicateTaskGuard struct {
+	taskmanager.TaskManager
+	processor *streamingTaskProcessor
+}
+
+// OnSendTask implements taskmanager.TaskManager
+func (g *duplicateTaskGuard) OnSendTask(ctx context.Context, params protocol.SendTaskParams) (*protocol.Task, error) {
+	g.processor.interruptForNewInput(g.processor.sessionIDs.sessionID(ctx, params.ID, params.SessionID, params.Message.Metadata))
+	if g.processor.tasks.isActive(params.ID) {
+		return nil, rejectDuplicateTask(params.ID)
+	}
+	return g.TaskManager.OnSendTask(ctx, params)
+}
+
+// OnSendTaskSubscribe implements taskmanager.TaskManager
+func (g *duplicateTaskGuard) OnSendTaskSubscribe(
+	ctx context.Context,
+	params protocol.SendTaskParams,
+) (<-chan protocol.TaskEvent, error) {
+	g.processor.interruptForNewInput(g.processor.sessionIDs.sessionID(ctx, params.ID, params.SessionID, params.Message.Metadata))
+	if g.processor.tasks.isActive(params.ID) {
+		return nil, rejectDuplicateTask(params.ID)
+	}
+	return g.TaskManager.OnSendTaskSubscribe(ctx, params)
+}
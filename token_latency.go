@@ -0,0 +1,57 @@
+// Inter-token latency of streamed completions
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// tokenLatency records the gaps between successive content deltas of a stream, so
+// provider-side stalls mid-stream can be told apart from a slow first token.
+type tokenLatency struct {
+	last time.Time
+	gaps []time.Duration
+}
+
+// observe records a content delta received at now
+func (l *tokenLatency) observe(now time.Time) {
+	if !l.last.IsZero() {
+		l.gaps = append(l.gaps, now.Sub(l.last))
+	}
+	l.last = now
+}
+
+// percentile returns the q-th quantile (0 to 1) of the recorded gaps by the
+// nearest-rank method, or 0 if fewer than two deltas were observed
+func (l *tokenLatency) percentile(q float64) time.Duration {
+	if len(l.gaps) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(l.gaps))
+	copy(sorted, l.gaps)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+// metadata returns the p50 and p95 inter-token latency in milliseconds for the
+// final chunk marker; it is empty when there was no gap to measure.
+func (l *tokenLatency) metadata() map[string]interface{} {
+	if len(l.gaps) == 0 {
+		return nil
+	}
+	return map[string]interface{}{
+		"inter_token_latency_p50_ms": durationMillis(l.percentile(0.50)),
+		"inter_token_latency_p95_ms": durationMillis(l.percentile(0.95)),
+		"inter_token_gaps":           len(l.gaps),
+	}
+}
+
+// durationMillis converts d to fractional milliseconds
+func durationMillis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
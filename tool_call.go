@@ -0,0 +1,82 @@
+// Streaming of tool-call arguments to the client as they are generated
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+)
+
+// toolCallMetadataKey flags the artifacts that carry tool-call argument deltas.
+const toolCallMetadataKey = "tool_call"
+
+// toolCallStream accumulates the tool calls of a streamed completion by their index
+// and turns each argument delta into an artifact, so clients can show the call
+// being written. The server does not run the tools; the artifacts are informational.
+// Completion requests do not offer tools yet, so only backends that call tools on
+// their own produce them.
+type toolCallStream struct {
+	calls map[int]*streamedToolCall
+}
+
+// streamedToolCall is one tool call assembled from its deltas.
+type streamedToolCall struct {
+	id        string
+	name      string
+	arguments strings.Builder
+}
+
+// newToolCallStream creates an empty tool-call accumulator
+func newToolCallStream() *toolCallStream {
+	return &toolCallStream{calls: make(map[int]*streamedToolCall)}
+}
+
+// write records the tool-call deltas of one stream chunk and returns a chunk for
+// the emitter per delta, which gives each call its own artifact index after the
+// text chunks emitted so far. The first delta of a call names it; later ones only
+// carry arguments.
+func (s *toolCallStream) write(deltas []openai.ToolCall) []streamChunk {
+	chunks := make([]streamChunk, 0, len(deltas))
+	for position, delta := range deltas {
+		index := position
+		if delta.Index != nil {
+			index = *delta.Index
+		}
+		call, ok := s.calls[index]
+		if !ok {
+			call = &streamedToolCall{}
+			s.calls[index] = call
+		}
+		if delta.ID != "" {
+			call.id = delta.ID
+		}
+		if delta.Function.Name != "" {
+			call.name = delta.Function.Name
+		}
+		call.arguments.WriteString(delta.Function.Arguments)
+
+		artifact := &protocol.Artifact{
+			Name:        stringPtr(fmt.Sprintf("Tool call %d", index+1)),
+			Description: stringPtr("Streaming tool call arguments from OpenAI"),
+			Parts:       []protocol.Part{protocol.NewTextPart(delta.Function.Arguments)},
+			Metadata: map[string]interface{}{
+				"timestamp":         time.Now().UnixNano(),
+				toolCallMetadataKey: true,
+				"tool_name":         call.name,
+				"tool_call_id":      call.id,
+				"tool_call_index":   index,
+				"arguments":         call.arguments.String(),
+			},
+		}
+		chunks = append(chunks, streamChunk{toolCall: artifact, toolCallIndex: index})
+	}
+	return chunks
+}
+
+// count returns the number of tool calls seen
+func (s *toolCallStream) count() int {
+	return len(s.calls)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestStreamedToolCallsGetTheirOwnArtifactIndices(t *testing.T) {
+	first, second := 0, 1
+	client := streamServer(t, func(_ *http.Request, send func(openai.ChatCompletionStreamResponse)) {
+		send(textDelta("Looking that up. "))
+		send(openai.ChatCompletionStreamResponse{Choices: []openai.ChatCompletionStreamChoice{{
+			Delta: openai.ChatCompletionStreamChoiceDelta{ToolCalls: []openai.ToolCall{
+				{Index: &first, ID: "call_a", Type: openai.ToolTypeFunction,
+					Function: openai.FunctionCall{Name: "get_weather", Arguments: `{"city":`}},
+				{Index: &second, ID: "call_b", Type: openai.ToolTypeFunction,
+					Function: openai.FunctionCall{Name: "get_time", Arguments: `{}`}},
+			}},
+		}}})
+		send(openai.ChatCompletionStreamResponse{Choices: []openai.ChatCompletionStreamChoice{{
+			Delta: openai.ChatCompletionStreamChoiceDelta{ToolCalls: []openai.ToolCall{
+				{Index: &first, Function: openai.FunctionCall{Arguments: `"Paris"}`}},
+			}},
+		}}})
+		send(textDelta("Done."))
+	})
+	p := testProcessor(t, client)
+
+	handle, err := runStream(t, context.Background(), p, testTurn(t, "weather?"))
+	if err != nil {
+		t.Fatalf("processWithOpenAIStreaming: %v", err)
+	}
+
+	indices := map[string][]int{}
+	var weather []string
+	for _, artifact := range handle.artifacts {
+		if artifact.Metadata[toolCallMetadataKey] == true {
+			id := artifact.Metadata["tool_call_id"].(string)
+			indices[id] = append(indices[id], artifact.Index)
+			if id == "call_a" {
+				weather = append(weather, artifact.Metadata["arguments"].(string))
+				if appended := artifact.Append != nil && *artifact.Append; appended != (len(weather) > 1) {
+					t.Errorf("call_a delta %d: append = %v", len(weather), appended)
+				}
+			}
+			continue
+		}
+		indices["text"] = append(indices["text"], artifact.Index)
+	}
+
+	// The tool calls take the indices after the first text chunk, in the order they
+	// started; the second text chunk follows them and is marked last.
+	if got := indices["text"]; len(got) != 3 || got[0] != 0 || got[1] != 3 || got[2] != 3 {
+		t.Errorf("text artifact indices = %v, want [0 3 3]", got)
+	}
+	if got := indices["call_a"]; len(got) != 2 || got[0] != 1 || got[1] != 1 {
+		t.Errorf("call_a artifact indices = %v, want [1 1]", got)
+	}
+	if got := indices["call_b"]; len(got) != 1 || got[0] != 2 {
+		t.Errorf("call_b artifact indices = %v, want [2]", got)
+	}
+	if len(weather) != 2 || weather[1] != `{"city":"Paris"}` {
+		t.Errorf("call_a accumulated arguments = %q", weather)
+	}
+}
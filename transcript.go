@@ -0,0 +1,78 @@
+// Export of a session's stored conversation history as a transcript
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Transcript formats served by GET /admin/sessions/{id}/transcript
+const (
+	transcriptFormatMarkdown = "markdown"
+	transcriptFormatText     = "text"
+)
+
+// contentTypeMarkdown is the content type of a Markdown transcript.
+const contentTypeMarkdown = "text/markdown"
+
+// transcript renders the session's summary and stored exchanges, oldest first, in
+// format. Each exchange is stamped with the time its reply was recorded.
+func transcript(sessionID, summary string, turns []historyTurn, format string) string {
+	var out strings.Builder
+	if format == transcriptFormatText {
+		fmt.Fprintf(&out, "Transcript of session %s\n\n", sessionID)
+		if summary != "" {
+			fmt.Fprintf(&out, "Summary of the earlier conversation: %s\n\n", summary)
+		}
+		for _, turn := range turns {
+			stamp := turn.at.UTC().Format(time.RFC3339)
+			fmt.Fprintf(&out, "[%s] User: %s\n[%s] %s: %s\n\n", stamp, turn.user, stamp, turn.persona, turn.assistant)
+		}
+		return out.String()
+	}
+
+	fmt.Fprintf(&out, "# Transcript of session %s\n\n", sessionID)
+	if summary != "" {
+		fmt.Fprintf(&out, "> Summary of the earlier conversation: %s\n\n", summary)
+	}
+	for i, turn := range turns {
+		fmt.Fprintf(&out, "## Exchange %d (%s)\n\n", i+1, turn.at.UTC().Format(time.RFC3339))
+		fmt.Fprintf(&out, "**User:**\n\n%s\n\n**%s:**\n\n%s\n\n", turn.user, turn.persona, turn.assistant)
+	}
+	return out.String()
+}
+
+// handleSessionTranscript serves the stored history of the session in the URL path
+// as a Markdown transcript, or plain text with ?format=text, for download. Only the
+// exchanges HISTORY_MAX_TURNS keeps are included, after any summary of older ones.
+func (p *streamingTaskProcessor) handleSessionTranscript(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.PathValue("id")
+	if sessionID == "" {
+		writeJSONError(w, http.StatusBadRequest, "session ID is required")
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = transcriptFormatMarkdown
+	}
+	contentType, extension := contentTypeMarkdown, "md"
+	switch format {
+	case transcriptFormatMarkdown:
+	case transcriptFormatText:
+		contentType, extension = contentTypeText, "txt"
+	default:
+		writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("format must be %q or %q", transcriptFormatMarkdown, transcriptFormatText))
+		return
+	}
+	summary, turns := p.sessions.history(sessionID)
+	if summary == "" && len(turns) == 0 {
+		writeJSONError(w, http.StatusNotFound, fmt.Sprintf("session %q has no stored history", sessionID))
+		return
+	}
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "transcript."+extension))
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, transcript(sessionID, summary, turns, format))
+}
@@ -2,52 +2,252 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"log"
-	"os"
-	"strconv"
+	"regexp"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
-	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+	sdkerrors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
 	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
 	trtc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/trtc/v20190722"
 )
 
 var (
-	trtcClient     *trtc.Client
-	trtcClientOnce sync.Once
+	trtcClientMu      sync.Mutex
+	trtcClient        *trtc.Client
+	trtcClientErr     error
+	trtcClientErrTime time.Time
 )
 
+// trtcSettings holds the TRTC configuration; main sets it from the loaded Config before serving.
+var trtcSettings = TRTCConfig{Timeout: defaultTRTCTimeout, MaxRetries: defaultTRTCMaxRetries}
+
+// trtcClientRetryInterval limits how often a failed TRTC client setup is retried.
+const trtcClientRetryInterval = 30 * time.Second
+
+// Defaults used when TRTC_REGION or TRTC_ENDPOINT is unset
+const (
+	defaultTRTCRegion   = "ap-guangzhou"
+	defaultTRTCEndpoint = "trtc.tencentcloudapi.com"
+)
+
+// trtcRegions are the regions the TRTC API is served from.
+var trtcRegions = map[string]bool{
+	"ap-bangkok":       true,
+	"ap-beijing":       true,
+	"ap-chengdu":       true,
+	"ap-chongqing":     true,
+	"ap-guangzhou":     true,
+	"ap-hongkong":      true,
+	"ap-jakarta":       true,
+	"ap-mumbai":        true,
+	"ap-nanjing":       true,
+	"ap-seoul":         true,
+	"ap-shanghai":      true,
+	"ap-singapore":     true,
+	"ap-tokyo":         true,
+	"eu-frankfurt":     true,
+	"na-ashburn":       true,
+	"na-siliconvalley": true,
+	"na-toronto":       true,
+	"sa-saopaulo":      true,
+}
+
+// errTRTCUnavailable wraps client setup failures; TRTC side effects are skipped while it is returned.
+var errTRTCUnavailable = errors.New("TRTC client unavailable")
+
 // Voice constants for common voice types
 const (
 	VoiceTypeXiaoMei  = 601005
 	VoiceTypeXiaoShuai = 601008
 )
 
-// getTRTCClient returns a singleton TRTC client
-func getTRTCClient() *trtc.Client {
-	trtcClientOnce.Do(func() {
-		secretID := os.Getenv("TRTC_SECRET_ID")
-		secretKey := os.Getenv("TRTC_SECRET_KEY")
-		region := os.Getenv("TRTC_REGION")
-		endpoint := os.Getenv("TRTC_ENDPOINT")
-		
-		if secretID == "" || secretKey == "" {
-			log.Printf("Warning: TRTC credentials not found in environment variables")
-		}
-		
-		credential := common.NewCredential(secretID, secretKey)
-		cpf := profile.NewClientProfile()
-		cpf.HttpProfile.Endpoint = endpoint
-		
-		var err error
-		trtcClient, err = trtc.NewClient(credential, region, cpf)
-		if err != nil {
-			panic(fmt.Sprintf("Failed to create TRTC client: %v", err))
+// defaultTRTCTaskIDMinLength is the shortest task ID treated as a TRTC AI conversation
+// unless TRTC_TASK_ID_MIN_LENGTH is set. TRTC's AI conversation TaskIds are long
+// opaque strings (well over 64 characters), while ordinary A2A clients use short IDs
+// such as UUIDs that have no TRTC conversation behind them, so calling the TRTC API
+// for those would only fail.
+const defaultTRTCTaskIDMinLength = 65
+
+// defaultTRTCTaskIDPattern is the default TRTC_TASK_ID_PATTERN: TRTC TaskIds are
+// base64-style tokens, so IDs with spaces or other punctuation are not conversations.
+const defaultTRTCTaskIDPattern = `^[A-Za-z0-9+/=_.:-]+$`
+
+// trtcTaskIDPattern is the compiled TRTC_TASK_ID_PATTERN; main sets it along with trtcSettings.
+var trtcTaskIDPattern = regexp.MustCompile(defaultTRTCTaskIDPattern)
+
+// errNotTRTCTask is returned when a task ID does not belong to a TRTC AI conversation.
+var errNotTRTCTask = errors.New("task ID does not look like a TRTC AI conversation ID")
+
+// trtcTaskIDError says why a task ID was not taken for a TRTC AI conversation ID. It wraps errNotTRTCTask.
+type trtcTaskIDError struct {
+	taskID    string
+	minLength int
+	// pattern is set when the ID is long enough but does not match it.
+	pattern string
+}
+
+// Error implements error
+func (e *trtcTaskIDError) Error() string {
+	if e.pattern != "" {
+		return fmt.Sprintf("%v: %q does not match TRTC_TASK_ID_PATTERN %s", errNotTRTCTask, e.taskID, e.pattern)
+	}
+	return fmt.Sprintf("%v: %q has %d characters, need at least %d",
+		errNotTRTCTask, e.taskID, len(e.taskID), e.minLength)
+}
+
+// Unwrap returns errNotTRTCTask
+func (e *trtcTaskIDError) Unwrap() error {
+	return errNotTRTCTask
+}
+
+// logFields renders the check as key=value pairs for structured logs
+func (e *trtcTaskIDError) logFields() string {
+	reason := "too_short"
+	if e.pattern != "" {
+		reason = "pattern_mismatch"
+	}
+	return fmt.Sprintf("reason=%s task_id=%q length=%d min_length=%d pattern=%q",
+		reason, e.taskID, len(e.taskID), e.minLength, trtcTaskIDPattern.String())
+}
+
+// setTRTCSettings installs the TRTC configuration, which Config.Validate has checked.
+// An empty TRTC_TASK_ID_PATTERN matches every ID, leaving only the length check.
+func setTRTCSettings(cfg TRTCConfig) {
+	trtcSettings = cfg
+	trtcTaskIDPattern = regexp.MustCompile(cfg.TaskIDPattern)
+}
+
+// AI conversation statuses reported by DescribeAIConversation
+const (
+	AIConversationStatusIdle       = "Idle"
+	AIConversationStatusPreparing  = "Preparing"
+	AIConversationStatusInProgress = "InProgress"
+	AIConversationStatusStopped    = "Stopped"
+)
+
+// aiConversationStatusTTL is how long a DescribeAIConversation result is reused,
+// so a burst of pushes to one conversation costs a single status query.
+const aiConversationStatusTTL = 5 * time.Second
+
+// errAIConversationInactive is returned instead of pushing text into a conversation that is not running.
+var errAIConversationInactive = errors.New("TRTC AI conversation is not in progress")
+
+// cachedAIConversationStatus is a DescribeAIConversation result and when it stops being reused.
+type cachedAIConversationStatus struct {
+	status  string
+	expires time.Time
+}
+
+var (
+	aiConversationStatusMu    sync.Mutex
+	aiConversationStatusCache = make(map[string]cachedAIConversationStatus)
+)
+
+// Retry settings for TRTC API calls
+const (
+	defaultTRTCTimeout    = 10 * time.Second
+	defaultTRTCMaxRetries = 2
+	trtcRetryBackoff      = 200 * time.Millisecond
+)
+
+// getTRTCClient returns a singleton TRTC client, creating it on first use. A failed
+// setup is retried on a later call once trtcClientRetryInterval has passed, so fixing
+// the configuration does not require a restart; until then the error is returned.
+func getTRTCClient() (*trtc.Client, error) {
+	trtcClientMu.Lock()
+	defer trtcClientMu.Unlock()
+
+	if trtcClient != nil {
+		return trtcClient, nil
+	}
+	if trtcClientErr != nil && time.Since(trtcClientErrTime) < trtcClientRetryInterval {
+		return nil, trtcClientErr
+	}
+
+	client, err := newTRTCClient()
+	if err != nil {
+		log.Printf("TRTC features disabled, retrying in %v: %v", trtcClientRetryInterval, err)
+		trtcClientErr, trtcClientErrTime = err, time.Now()
+		return nil, err
+	}
+	trtcClient, trtcClientErr = client, nil
+	return client, nil
+}
+
+// newTRTCClient creates a TRTC client from the TRTC settings
+func newTRTCClient() (*trtc.Client, error) {
+	secretID := trtcSettings.SecretID
+	secretKey := trtcSettings.SecretKey
+	region := trtcSettings.Region
+	endpoint := trtcSettings.Endpoint
+	
+	if secretID == "" || secretKey == "" {
+		return nil, fmt.Errorf("%w: TRTC_SECRET_ID and TRTC_SECRET_KEY must be set", errTRTCUnavailable)
+	}
+	if region == "" {
+		region = defaultTRTCRegion
+	}
+	if !trtcRegions[region] {
+		return nil, fmt.Errorf("%w: unknown TRTC_REGION %q", errTRTCUnavailable, region)
+	}
+	if endpoint == "" {
+		endpoint = defaultTRTCEndpoint
+	}
+	
+	credential := common.NewCredential(secretID, secretKey)
+	cpf := profile.NewClientProfile()
+	cpf.HttpProfile.Endpoint = endpoint
+	cpf.HttpProfile.ReqTimeout = trtcTimeoutSeconds()
+	
+	client, err := trtc.NewClient(credential, region, cpf)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to create TRTC client: %v", errTRTCUnavailable, err)
+	}
+	return client, nil
+}
+
+// trtcTimeoutSeconds returns the TRTC request timeout from TRTC_TIMEOUT,
+// rounded up to whole seconds as the SDK requires
+func trtcTimeoutSeconds() int {
+	timeout := trtcSettings.Timeout
+	return int((timeout + time.Second - 1) / time.Second)
+}
+
+// trtcMaxRetries returns how many times a transient TRTC failure is retried, from TRTC_MAX_RETRIES
+func trtcMaxRetries() int {
+	return trtcSettings.MaxRetries
+}
+
+// isTransientTRTCError reports whether a failed TRTC call is worth retrying.
+// Network failures, internal errors and rate limiting are transient; anything else
+// (bad parameters, auth, missing conversation) will fail the same way again.
+func isTransientTRTCError(err error) bool {
+	sdkErr, ok := err.(*sdkerrors.TencentCloudSDKError)
+	if !ok {
+		return true
+	}
+	code := sdkErr.GetCode()
+	return code == "ClientError.NetworkError" ||
+		strings.HasPrefix(code, "InternalError") ||
+		strings.HasPrefix(code, "RequestLimitExceeded")
+}
+
+// withTRTCRetry runs call, retrying transient failures with a linear backoff
+func withTRTCRetry(operation string, call func() error) error {
+	maxRetries := trtcMaxRetries()
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = call(); err == nil || !isTransientTRTCError(err) || attempt >= maxRetries {
+			return err
 		}
-	})
-	return trtcClient
+		log.Printf("TRTC %s failed (attempt %d/%d), retrying: %v", operation, attempt+1, maxRetries+1, err)
+		time.Sleep(trtcRetryBackoff * time.Duration(attempt+1))
+	}
 }
 
 // UpdateAIConversation updates the AI conversation configuration
@@ -56,10 +256,17 @@ func UpdateAIConversation(taskID, ttsConfig string) error {
 	request.TaskId = common.StringPtr(taskID)
 	request.TTSConfig = common.StringPtr(ttsConfig)
 
-	_, err := getTRTCClient().UpdateAIConversation(request)
+	client, err := getTRTCClient()
+	if err != nil {
+		return err
+	}
+	err = withTRTCRetry("UpdateAIConversation", func() error {
+		_, err := client.UpdateAIConversation(request)
+		return err
+	})
 	if err != nil {
-		if sdkErr, ok := err.(*errors.TencentCloudSDKError); ok {
-			return fmt.Errorf("API error: %s", sdkErr)
+		if sdkErr, ok := err.(*sdkerrors.TencentCloudSDKError); ok {
+			return fmt.Errorf("API error: %w", sdkErr)
 		}
 		return fmt.Errorf("update failed: %w", err)
 	}
@@ -67,29 +274,78 @@ func UpdateAIConversation(taskID, ttsConfig string) error {
 	return nil
 }
 
+// validateTRTCTaskID returns a *trtcTaskIDError, which wraps errNotTRTCTask, if taskID
+// is shorter than TRTC_TASK_ID_MIN_LENGTH or does not match TRTC_TASK_ID_PATTERN
+func validateTRTCTaskID(taskID string) error {
+	minLength := trtcSettings.TaskIDMinLength
+	if minLength <= 0 {
+		minLength = defaultTRTCTaskIDMinLength
+	}
+	if len(taskID) < minLength {
+		return &trtcTaskIDError{taskID: taskID, minLength: minLength}
+	}
+	if !trtcTaskIDPattern.MatchString(taskID) {
+		return &trtcTaskIDError{taskID: taskID, minLength: minLength, pattern: trtcTaskIDPattern.String()}
+	}
+	return nil
+}
+
+// logSkippedTRTCCall logs a TRTC call that was not made because of err. A task ID
+// that failed validateTRTCTaskID is logged with the details of the check.
+func logSkippedTRTCCall(operation, taskID string, err error) {
+	var idErr *trtcTaskIDError
+	if errors.As(err, &idErr) {
+		log.Printf("TRTC %s skipped, task ID is not a TRTC AI conversation ID: %s", operation, idErr.logFields())
+		return
+	}
+	log.Printf("TRTC %s skipped for task %s: %v", operation, taskID, err)
+}
+
+// builtinTTSVoices are the voices of the built-in personas unless PERSONA_TTS_VOICES overrides them.
+var builtinTTSVoices = map[string]int{
+	"XiaoMei":   VoiceTypeXiaoMei,
+	"XiaoShuai": VoiceTypeXiaoShuai,
+}
+
+// ttsVoiceType returns the TTS voice type for the persona: its PERSONA_TTS_VOICES
+// entry, the built-in voice of XiaoMei and XiaoShuai, or TTS_VOICE_TYPE
+func ttsVoiceType(persona string) int {
+	if voice, ok := trtcSettings.TTSPersonaVoices[persona]; ok {
+		return voice
+	}
+	if voice, ok := builtinTTSVoices[persona]; ok {
+		return voice
+	}
+	if trtcSettings.TTSVoiceType > 0 {
+		return trtcSettings.TTSVoiceType
+	}
+	return VoiceTypeXiaoMei
+}
+
+// UpdateAIConversationForPersona updates the AI conversation's TTS voice to match the persona.
+// It returns errNotTRTCTask without calling TRTC when taskID is not a TRTC conversation ID.
+func UpdateAIConversationForPersona(taskID, persona string) error {
+	if err := validateTRTCTaskID(taskID); err != nil {
+		return err
+	}
+	return UpdateAIConversationVoice(taskID, ttsVoiceType(persona))
+}
+
 // UpdateAIConversationXiaoMei updates the AI conversation with XiaoMei's voice
 func UpdateAIConversationXiaoMei(taskID string) error {
-	appID, _ := strconv.Atoi(os.Getenv("TTS_APP_ID"))
-	secretID := os.Getenv("TTS_SECRET_ID")
-	secretKey := os.Getenv("TTS_SECRET_KEY")
-	
-	ttsConfig := fmt.Sprintf(`{
-		"TTSType": "tencent",
-		"AppId": %d,
-		"SecretId": "%s",
-		"SecretKey": "%s",
-		"VoiceType": %d,
-		"Speed": 1
-	}`, appID, secretID, secretKey, VoiceTypeXiaoMei)
-	
-	return UpdateAIConversation(taskID, ttsConfig)
+	return UpdateAIConversationVoice(taskID, VoiceTypeXiaoMei)
 }
 
 // UpdateAIConversationXiaoShuai updates the AI conversation with XiaoShuai's voice
 func UpdateAIConversationXiaoShuai(taskID string) error {
-	appID, _ := strconv.Atoi(os.Getenv("TTS_APP_ID"))
-	secretID := os.Getenv("TTS_SECRET_ID")
-	secretKey := os.Getenv("TTS_SECRET_KEY")
+	return UpdateAIConversationVoice(taskID, VoiceTypeXiaoShuai)
+}
+
+// UpdateAIConversationVoice updates the AI conversation with the given TTS voice type
+func UpdateAIConversationVoice(taskID string, voiceType int) error {
+	appID := trtcSettings.TTSAppID
+	secretID := trtcSettings.TTSSecretID
+	secretKey := trtcSettings.TTSSecretKey
 	
 	ttsConfig := fmt.Sprintf(`{
 		"TTSType": "tencent",
@@ -98,24 +354,115 @@ func UpdateAIConversationXiaoShuai(taskID string) error {
 		"SecretKey": "%s",
 		"VoiceType": %d,
 		"Speed": 1
-	}`, appID, secretID, secretKey, VoiceTypeXiaoShuai)
+	}`, appID, secretID, secretKey, voiceType)
 	
 	return UpdateAIConversation(taskID, ttsConfig)
 }
 
+// DescribeAIConversation returns the conversation's status (one of the AIConversationStatus
+// constants). Results are cached for aiConversationStatusTTL.
+func DescribeAIConversation(taskID string) (string, error) {
+	aiConversationStatusMu.Lock()
+	cached, ok := aiConversationStatusCache[taskID]
+	aiConversationStatusMu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.status, nil
+	}
+
+	request := trtc.NewDescribeAIConversationRequest()
+	request.TaskId = common.StringPtr(taskID)
+
+	client, err := getTRTCClient()
+	if err != nil {
+		return "", err
+	}
+	var response *trtc.DescribeAIConversationResponse
+	err = withTRTCRetry("DescribeAIConversation", func() error {
+		var err error
+		response, err = client.DescribeAIConversation(request)
+		return err
+	})
+	if err != nil {
+		if sdkErr, ok := err.(*sdkerrors.TencentCloudSDKError); ok {
+			return "", fmt.Errorf("API error: %w", sdkErr)
+		}
+		return "", fmt.Errorf("describe failed: %w", err)
+	}
+
+	status := ""
+	if response.Response != nil && response.Response.Status != nil {
+		status = *response.Response.Status
+	}
+
+	aiConversationStatusMu.Lock()
+	now := time.Now()
+	for id, entry := range aiConversationStatusCache {
+		if now.After(entry.expires) {
+			delete(aiConversationStatusCache, id)
+		}
+	}
+	aiConversationStatusCache[taskID] = cachedAIConversationStatus{status: status, expires: now.Add(aiConversationStatusTTL)}
+	aiConversationStatusMu.Unlock()
+	return status, nil
+}
+
+// requireActiveAIConversation returns errAIConversationInactive unless the conversation is in progress.
+// If the status cannot be queried the push is allowed, so a failing status API never blocks TRTC control.
+func requireActiveAIConversation(taskID string) error {
+	status, err := DescribeAIConversation(taskID)
+	if errors.Is(err, errTRTCUnavailable) {
+		return err
+	}
+	if err != nil {
+		log.Printf("Could not query TRTC conversation status for %s, pushing anyway: %v", taskID, err)
+		return nil
+	}
+	if status != AIConversationStatusInProgress {
+		return fmt.Errorf("%w: status is %q", errAIConversationInactive, status)
+	}
+	return nil
+}
+
 // ControlAIConversation sends control commands to an AI conversation
 func ControlAIConversation(taskID, text string) error {
+	return pushServerText(taskID, &trtc.ServerPushText{
+		Text: common.StringPtr(text),
+	})
+}
+
+// InterruptAIConversation stops the AI from finishing what it is currently saying,
+// e.g. when the user barges in. TRTC has no standalone interrupt command; an
+// interrupting ServerPushText with no text cuts off the current speech without
+// saying anything new.
+func InterruptAIConversation(taskID string) error {
+	return pushServerText(taskID, &trtc.ServerPushText{
+		Text:      common.StringPtr(""),
+		Interrupt: common.BoolPtr(true),
+	})
+}
+
+// pushServerText sends a ServerPushText control command to an AI conversation
+func pushServerText(taskID string, push *trtc.ServerPushText) error {
+	if err := requireActiveAIConversation(taskID); err != nil {
+		return err
+	}
+
 	request := trtc.NewControlAIConversationRequest()
 	request.TaskId = common.StringPtr(taskID)
 	request.Command = common.StringPtr("ServerPushText")
-	request.ServerPushText = &trtc.ServerPushText{
-		Text: common.StringPtr(text),
-	}
+	request.ServerPushText = push
 
-	_, err := getTRTCClient().ControlAIConversation(request)
+	client, err := getTRTCClient()
+	if err != nil {
+		return err
+	}
+	err = withTRTCRetry("ControlAIConversation", func() error {
+		_, err := client.ControlAIConversation(request)
+		return err
+	})
 	if err != nil {
-		if sdkErr, ok := err.(*errors.TencentCloudSDKError); ok {
-			return fmt.Errorf("API error: %s", sdkErr)
+		if sdkErr, ok := err.(*sdkerrors.TencentCloudSDKError); ok {
+			return fmt.Errorf("API error: %w", sdkErr)
 		}
 		return fmt.Errorf("control failed: %w", err)
 	}
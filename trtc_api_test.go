@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestTTSVoiceType(t *testing.T) {
+	saved := trtcSettings
+	t.Cleanup(func() { trtcSettings = saved })
+	trtcSettings.TTSVoiceType = 601002
+	trtcSettings.TTSPersonaVoices = map[string]int{"XiaoGang": 601010, "XiaoShuai": 601003}
+
+	tests := []struct {
+		persona string
+		want    int
+	}{
+		{"XiaoMei", VoiceTypeXiaoMei},
+		{"XiaoShuai", 601003},
+		{"XiaoGang", 601010},
+		{"Lulu", 601002},
+	}
+	for _, test := range tests {
+		if got := ttsVoiceType(test.persona); got != test.want {
+			t.Errorf("ttsVoiceType(%q) = %d, want %d", test.persona, got, test.want)
+		}
+	}
+}
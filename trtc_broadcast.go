@@ -0,0 +1,184 @@
+// Fan-out of a task's replies to several TRTC AI conversations
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// trtcRoomsMetadataKey lists, in message metadata, further TRTC AI conversations
+// that hear the task's replies: an array of task IDs or a comma-separated string.
+const trtcRoomsMetadataKey = "trtc_rooms"
+
+// trtcBroadcastQueueSize is how many sentences may wait for the rooms before the stream blocks.
+const trtcBroadcastQueueSize = 256
+
+// trtcRooms returns the conversations listed under trtcRoomsMetadataKey, without
+// duplicates and without taskID itself. IDs that cannot be TRTC conversations are
+// logged and skipped.
+func trtcRooms(taskID string, metadata map[string]interface{}) []string {
+	var listed []string
+	switch v := metadata[trtcRoomsMetadataKey].(type) {
+	case string:
+		listed = strings.Split(v, ",")
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				listed = append(listed, s)
+			}
+		}
+	}
+	seen := map[string]bool{taskID: true}
+	var rooms []string
+	for _, room := range listed {
+		room = strings.TrimSpace(room)
+		if room == "" || seen[room] {
+			continue
+		}
+		seen[room] = true
+		if err := validateTRTCTaskID(room); err != nil {
+			log.Printf("Task %s: ignoring TRTC room: %v", taskID, err)
+			continue
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// trtcConversations returns the task's own conversation, if taskID is one, followed by rooms
+func trtcConversations(taskID string, rooms []string) []string {
+	if validateTRTCTaskID(taskID) != nil {
+		return rooms
+	}
+	return append([]string{taskID}, rooms...)
+}
+
+// pushToConversations pushes text to every conversation, at most concurrency at a
+// time. A failed conversation does not stop the others; the failures are returned
+// by conversation.
+func pushToConversations(conversations []string, text string, concurrency int) map[string]error {
+	var mu sync.Mutex
+	failures := make(map[string]error)
+	slots := make(chan struct{}, max(concurrency, 1))
+	var wg sync.WaitGroup
+	for _, conversation := range conversations {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			if err := ControlAIConversation(conversation, text); err != nil {
+				mu.Lock()
+				failures[conversation] = err
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return failures
+}
+
+// logPushFailures logs the conversations a push of what failed for
+func logPushFailures(taskID, what string, total int, failures map[string]error) {
+	if len(failures) == 0 {
+		return
+	}
+	conversations := make([]string, 0, len(failures))
+	for conversation := range failures {
+		conversations = append(conversations, conversation)
+	}
+	sort.Strings(conversations)
+	reasons := make([]string, len(conversations))
+	for i, conversation := range conversations {
+		reasons[i] = fmt.Sprintf("%s: %v", conversation, failures[conversation])
+	}
+	log.Printf("Task %s: pushing %s to TRTC failed for %d of %d conversation(s): %s",
+		taskID, what, len(failures), total, strings.Join(reasons, "; "))
+}
+
+// trtcBroadcast pushes a streamed reply to TRTC rooms a sentence at a time, so the
+// rooms speak along with the stream. Sentences are pushed in order by one worker;
+// each is fanned out to the rooms with bounded concurrency. A room whose
+// conversation has ended is dropped from later sentences. The stream never waits
+// for TRTC unless trtcBroadcastQueueSize sentences are pending.
+// A nil *trtcBroadcast does nothing.
+type trtcBroadcast struct {
+	taskID      string
+	rooms       []string
+	concurrency int
+
+	buf   strings.Builder
+	queue chan string
+}
+
+// newTRTCBroadcast starts a broadcast to rooms, returning nil when there are none
+func newTRTCBroadcast(taskID string, rooms []string, concurrency int) *trtcBroadcast {
+	if len(rooms) == 0 {
+		return nil
+	}
+	b := &trtcBroadcast{
+		taskID:      taskID,
+		rooms:       rooms,
+		concurrency: concurrency,
+		queue:       make(chan string, trtcBroadcastQueueSize),
+	}
+	go b.run()
+	return b
+}
+
+// write buffers text and queues the sentences it completes
+func (b *trtcBroadcast) write(text string) {
+	if b == nil || text == "" {
+		return
+	}
+	b.buf.WriteString(text)
+	buffered := b.buf.String()
+	end := lastSentenceEnd(buffered)
+	if end < 0 {
+		return
+	}
+	b.buf.Reset()
+	b.buf.WriteString(buffered[end:])
+	if sentence := strings.TrimSpace(buffered[:end]); sentence != "" {
+		b.queue <- sentence
+	}
+}
+
+// close queues what is left of the reply; the worker finishes in the background
+func (b *trtcBroadcast) close() {
+	if b == nil {
+		return
+	}
+	if rest := strings.TrimSpace(b.buf.String()); rest != "" {
+		b.queue <- rest
+	}
+	b.buf.Reset()
+	close(b.queue)
+}
+
+// run pushes the queued sentences until the queue is closed
+func (b *trtcBroadcast) run() {
+	rooms := b.rooms
+	sentences := 0
+	for sentence := range b.queue {
+		if len(rooms) == 0 {
+			continue
+		}
+		sentences++
+		failures := pushToConversations(rooms, sentence, b.concurrency)
+		logPushFailures(b.taskID, fmt.Sprintf("sentence %d", sentences), len(rooms), failures)
+		live := rooms[:0:0]
+		for _, room := range rooms {
+			if !errors.Is(failures[room], errAIConversationInactive) {
+				live = append(live, room)
+			}
+		}
+		rooms = live
+	}
+	log.Printf("Task %s: broadcast %d sentence(s) to TRTC rooms, %d of %d still active",
+		b.taskID, sentences, len(rooms), len(b.rooms))
+}
@@ -0,0 +1,87 @@
+// Graceful TRTC degradation when a task fails or is canceled
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	trtc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/trtc/v20190722"
+)
+
+// TRTC_FAILURE_ACTION values
+const (
+	trtcFailureNone      = "none"
+	trtcFailureInterrupt = "interrupt"
+	trtcFailureApology   = "apology"
+)
+
+// defaultTRTCApology is spoken on failure with TRTC_FAILURE_ACTION=apology unless TRTC_FAILURE_APOLOGY is set.
+const defaultTRTCApology = "Sorry, something went wrong on my side. Could you say that again?"
+
+// trtcFailureHandler leaves a TRTC AI conversation in a usable state after its task
+// fails or is canceled, instead of waiting for text that never arrives.
+// A nil *trtcFailureHandler does nothing.
+type trtcFailureHandler struct {
+	action  string
+	apology string
+	// push sends the ServerPushText command, pushServerText outside tests.
+	push func(taskID string, push *trtc.ServerPushText) error
+}
+
+// newTRTCFailureHandler validates TRTC_FAILURE_ACTION, returning nil for "none"
+func newTRTCFailureHandler(action, apology string) (*trtcFailureHandler, error) {
+	switch action {
+	case "", trtcFailureNone:
+		return nil, nil
+	case trtcFailureInterrupt, trtcFailureApology:
+	default:
+		return nil, fmt.Errorf("unknown TRTC_FAILURE_ACTION %q, expected %q, %q or %q",
+			action, trtcFailureNone, trtcFailureInterrupt, trtcFailureApology)
+	}
+	if apology == "" {
+		apology = defaultTRTCApology
+	}
+	return &trtcFailureHandler{action: action, apology: apology, push: pushServerText}, nil
+}
+
+// handle reacts to a task ending with taskErr. Canceled tasks are only interrupted,
+// since the user stopped them; failures get the apology when configured. It runs
+// in the background and logs the outcome. The TRTC task ID is reused by the next
+// message of the conversation, so nothing is sent once superseded reports that a
+// newer task for it is being processed, whose reply would be cut off. Tasks whose
+// cancellation cause is a newer message or CancelSession were already interrupted
+// by whoever canceled them, and are left alone.
+func (h *trtcFailureHandler) handle(taskID string, taskErr, cause error, superseded func() bool) {
+	if h == nil || taskErr == nil || validateTRTCTaskID(taskID) != nil {
+		return
+	}
+	if errors.Is(cause, errInterruptedByNewInput) || errors.Is(cause, errSessionCanceled) {
+		return
+	}
+	action := h.action
+	if errors.Is(taskErr, context.Canceled) {
+		action = trtcFailureInterrupt
+	}
+	go func() {
+		if superseded() {
+			log.Printf("TRTC %s after task %s ended with %q skipped, a newer task for it is running", action, taskID, taskErr)
+			return
+		}
+		text := ""
+		if action == trtcFailureApology {
+			text = h.apology
+		}
+		err := h.push(taskID, &trtc.ServerPushText{
+			Text:      common.StringPtr(text),
+			Interrupt: common.BoolPtr(true),
+		})
+		if err != nil {
+			log.Printf("TRTC %s after task %s ended with %q failed: %v", action, taskID, taskErr, err)
+			return
+		}
+		log.Printf("TRTC %s sent after task %s ended with %q", action, taskID, taskErr)
+	}()
+}
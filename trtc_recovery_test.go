@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	trtc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/trtc/v20190722"
+)
+
+// testTRTCTaskID passes validateTRTCTaskID with the default settings.
+var testTRTCTaskID = strings.Repeat("a1b2", 17)
+
+// recordingTRTCFailureHandler returns an interrupting handler whose pushes are sent on the returned channel.
+func recordingTRTCFailureHandler(t *testing.T) (*trtcFailureHandler, chan *trtc.ServerPushText) {
+	t.Helper()
+	if err := validateTRTCTaskID(testTRTCTaskID); err != nil {
+		t.Fatalf("test task ID is invalid: %v", err)
+	}
+	h, err := newTRTCFailureHandler(trtcFailureInterrupt, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushes := make(chan *trtc.ServerPushText, 1)
+	h.push = func(taskID string, push *trtc.ServerPushText) error {
+		if taskID != testTRTCTaskID {
+			t.Errorf("pushed to task %q, want %q", taskID, testTRTCTaskID)
+		}
+		pushes <- push
+		return nil
+	}
+	return h, pushes
+}
+
+func TestTRTCFailureInterruptsCanceledTask(t *testing.T) {
+	h, pushes := recordingTRTCFailureHandler(t)
+	tasks := newTaskRegistry()
+	task := tasks.begin(testTRTCTaskID, "session", &fakeHandle{}, func(error) {})
+	h.handle(testTRTCTaskID, context.Canceled, context.Canceled, func() bool {
+		<-task.done
+		return tasks.superseded(testTRTCTaskID, task)
+	})
+	tasks.end(testTRTCTaskID)
+
+	select {
+	case push := <-pushes:
+		if !*push.Interrupt || *push.Text != "" {
+			t.Errorf("pushed text %q with interrupt %v, want an empty interrupt", *push.Text, *push.Interrupt)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no interrupt was sent for the canceled task")
+	}
+}
+
+func TestTRTCFailureSkipsInterruptWhenNewMessageReusesTaskID(t *testing.T) {
+	h, pushes := recordingTRTCFailureHandler(t)
+	tasks := newTaskRegistry()
+	first := tasks.begin(testTRTCTaskID, "session", &fakeHandle{}, func(error) {})
+	// The canceled task ends and the next message of the conversation begins
+	// before the handler has got round to the interrupt.
+	tasks.end(testTRTCTaskID)
+	if tasks.begin(testTRTCTaskID, "session", &fakeHandle{}, func(error) {}) == nil {
+		t.Fatal("the next task was rejected as a duplicate")
+	}
+	defer tasks.end(testTRTCTaskID)
+	checked := make(chan struct{})
+	h.handle(testTRTCTaskID, context.Canceled, context.Canceled, func() bool {
+		defer close(checked)
+		<-first.done
+		return tasks.superseded(testTRTCTaskID, first)
+	})
+
+	<-checked
+	select {
+	case push := <-pushes:
+		t.Fatalf("interrupt %+v was sent while the next task was running", push)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestTRTCFailureLeavesInterruptToCanceller(t *testing.T) {
+	for _, cause := range []error{errInterruptedByNewInput, errSessionCanceled} {
+		h, pushes := recordingTRTCFailureHandler(t)
+		h.handle(testTRTCTaskID, context.Canceled, cause, func() bool { return false })
+		select {
+		case push := <-pushes:
+			t.Errorf("cause %q: interrupt %+v was sent a second time", cause, push)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
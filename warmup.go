@@ -0,0 +1,45 @@
+// Startup warmup of the OpenAI connection
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// warmupTimeout bounds each warmup completion.
+const warmupTimeout = 30 * time.Second
+
+// warmup sends a throwaway one-token completion to every configured model, so
+// the first real request finds connections (and any gateway model routing) warm.
+// Failures are logged as warnings and never affect the server.
+func (p *streamingTaskProcessor) warmup() {
+	models := map[string]bool{p.openaiModel: true}
+	for _, model := range p.personaModels {
+		models[model] = true
+	}
+	names := make([]string, 0, len(models))
+	for model := range models {
+		names = append(names, model)
+	}
+	sort.Strings(names)
+
+	for _, model := range names {
+		ctx, cancel := context.WithTimeout(context.Background(), warmupTimeout)
+		start := time.Now()
+		_, err := p.openaiClient.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+			Model:     model,
+			Messages:  []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "ping"}},
+			MaxTokens: 1,
+		})
+		cancel()
+		if err != nil {
+			log.Printf("Warning: warmup completion with %s failed after %v: %v", model, time.Since(start), err)
+			continue
+		}
+		log.Printf("Warmup completion with %s took %v", model, time.Since(start))
+	}
+}
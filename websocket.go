@@ -0,0 +1,147 @@
+// WebSocket transport carrying the same task event stream as tasks/sendSubscribe
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+	"trpc.group/trpc-go/trpc-a2a-go/taskmanager"
+)
+
+// WebSocket frame types sent to the client
+const (
+	wsFrameStatus   = "status"
+	wsFrameArtifact = "artifact"
+	wsFrameError    = "error"
+)
+
+// wsQueuedTasks is how many tasks a connection holds while one is running; frames
+// beyond that are answered with an error frame.
+const wsQueuedTasks = 4
+
+// wsFrame is one JSON message sent over the WebSocket. Event is a
+// TaskStatusUpdateEvent or TaskArtifactUpdateEvent, exactly as SSE would carry it.
+type wsFrame struct {
+	Type   string             `json:"type"`
+	TaskID string             `json:"taskId,omitempty"`
+	Event  protocol.TaskEvent `json:"event,omitempty"`
+	Error  string             `json:"error,omitempty"`
+}
+
+// webSocketTransport streams tasks over WebSocket for clients whose proxies mishandle SSE.
+// Each text frame the client sends is a tasks/sendSubscribe params object
+// ({"id": "...", "sessionId": "...", "message": {...}}); the server answers with the
+// task's events as they happen. Tasks on a connection run one at a time, up to
+// wsQueuedTasks waiting behind the running one, and closing the connection cancels
+// the running task.
+type webSocketTransport struct {
+	taskManager taskmanager.TaskManager
+	cors        *corsConfig
+	upgrader    websocket.Upgrader
+}
+
+// newWebSocketTransport creates the transport. Browser origins are allowed when they
+// match the request host or the CORS configuration.
+func newWebSocketTransport(tm taskmanager.TaskManager, cors *corsConfig) *webSocketTransport {
+	t := &webSocketTransport{taskManager: tm, cors: cors}
+	t.upgrader.CheckOrigin = t.checkOrigin
+	return t
+}
+
+// checkOrigin accepts non-browser clients, same-origin pages and CORS-allowed origins
+func (t *webSocketTransport) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return t.cors.allowsOrigin(origin)
+}
+
+// ServeHTTP upgrades the connection and runs tasks until the client disconnects
+func (t *webSocketTransport) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := t.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("WebSocket upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	// The reader and the task loop both send frames, one at a time.
+	var writeMu sync.Mutex
+	write := func(frame wsFrame) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return conn.WriteJSON(frame)
+	}
+
+	// A hijacked connection's request context is not cancelled when the client goes
+	// away, so the reader cancels it instead. It keeps reading while a task runs, so
+	// a disconnect is noticed at once.
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	requests := make(chan protocol.SendTaskParams, wsQueuedTasks)
+	go func() {
+		defer close(requests)
+		for {
+			var params protocol.SendTaskParams
+			if err := conn.ReadJSON(&params); err != nil {
+				cancel()
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
+					log.Printf("WebSocket read failed: %v", err)
+				}
+				return
+			}
+			select {
+			case requests <- params:
+			default:
+				log.Printf("WebSocket task %s rejected, %d tasks are already waiting", params.ID, wsQueuedTasks)
+				if err := write(wsFrame{Type: wsFrameError, TaskID: params.ID, Error: "too many tasks queued on this connection"}); err != nil {
+					cancel()
+					return
+				}
+			}
+		}
+	}()
+
+	for params := range requests {
+		if ctx.Err() != nil {
+			return
+		}
+		if params.ID == "" {
+			if err := write(wsFrame{Type: wsFrameError, Error: "task id is required"}); err != nil {
+				return
+			}
+			continue
+		}
+
+		events, err := t.taskManager.OnSendTaskSubscribe(ctx, params)
+		if err != nil {
+			if err := write(wsFrame{Type: wsFrameError, TaskID: params.ID, Error: err.Error()}); err != nil {
+				return
+			}
+			continue
+		}
+		for event := range events {
+			frame := wsFrame{TaskID: params.ID, Event: event}
+			switch event.(type) {
+			case protocol.TaskStatusUpdateEvent:
+				frame.Type = wsFrameStatus
+			case protocol.TaskArtifactUpdateEvent:
+				frame.Type = wsFrameArtifact
+			}
+			if err := write(frame); err != nil {
+				log.Printf("WebSocket write for task %s failed: %v", params.ID, err)
+				return
+			}
+		}
+	}
+}
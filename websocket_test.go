@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"trpc.group/trpc-go/trpc-a2a-go/protocol"
+	"trpc.group/trpc-go/trpc-a2a-go/taskmanager"
+)
+
+// blockingTaskManager runs every subscribed task until its context is canceled.
+type blockingTaskManager struct {
+	taskmanager.TaskManager
+	started  chan string
+	canceled chan string
+}
+
+func (m *blockingTaskManager) OnSendTaskSubscribe(ctx context.Context, params protocol.SendTaskParams) (<-chan protocol.TaskEvent, error) {
+	events := make(chan protocol.TaskEvent)
+	m.started <- params.ID
+	go func() {
+		defer close(events)
+		<-ctx.Done()
+		m.canceled <- params.ID
+	}()
+	return events, nil
+}
+
+// dialWebSocket serves the transport for tm and connects to it.
+func dialWebSocket(t *testing.T, tm taskmanager.TaskManager) *websocket.Conn {
+	t.Helper()
+	srv := httptest.NewServer(newWebSocketTransport(tm, nil))
+	t.Cleanup(srv.Close)
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// sendTask writes a task frame for id.
+func sendTask(t *testing.T, conn *websocket.Conn, id string) {
+	t.Helper()
+	params := protocol.SendTaskParams{ID: id, Message: protocol.NewMessage(protocol.MessageRoleUser,
+		[]protocol.Part{protocol.NewTextPart("hello")})}
+	if err := conn.WriteJSON(params); err != nil {
+		t.Fatalf("write task %s: %v", id, err)
+	}
+}
+
+func TestWebSocketRejectsFramesBeyondTheQueue(t *testing.T) {
+	tm := &blockingTaskManager{started: make(chan string, 1), canceled: make(chan string, 1)}
+	conn := dialWebSocket(t, tm)
+
+	sendTask(t, conn, "running")
+	<-tm.started
+	for i := 0; i < wsQueuedTasks; i++ {
+		sendTask(t, conn, "queued")
+	}
+	sendTask(t, conn, "extra")
+
+	var frame wsFrame
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if err := conn.ReadJSON(&frame); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if frame.Type != wsFrameError || frame.TaskID != "extra" {
+		t.Errorf("frame = %+v, want an error frame for the extra task", frame)
+	}
+}
+
+func TestWebSocketDisconnectCancelsRunningTask(t *testing.T) {
+	tm := &blockingTaskManager{started: make(chan string, 1), canceled: make(chan string, 1)}
+	conn := dialWebSocket(t, tm)
+
+	sendTask(t, conn, "running")
+	<-tm.started
+	// A second task waits behind the first, which used to stop the reader from
+	// noticing the disconnect.
+	sendTask(t, conn, "queued")
+	conn.Close()
+
+	select {
+	case id := <-tm.canceled:
+		if id != "running" {
+			t.Errorf("canceled task %s, want the running one", id)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the running task was not canceled after the client disconnected")
+	}
+	select {
+	case id := <-tm.started:
+		t.Errorf("queued task %s started after the client disconnected", id)
+	case <-time.After(100 * time.Millisecond):
+	}
+}